@@ -0,0 +1,351 @@
+// signald-exporter probes signald over its socket and exposes the results as Prometheus metrics on /metrics.
+//
+//	signald-exporter -socket /var/run/signald/signald.sock -listen :9595
+//
+// Every -interval it asks signald for its version and accounts. With -subscribe it also subscribes to each account,
+// which is what it takes to see whether signald is connected to the Signal servers for it and when it last received a
+// message. A subscribed exporter counts as a client: signald keeps receiving messages while it is running, even if
+// the real client is down, and those messages are not stored for later. Only use -subscribe where some other
+// subscriber is expected to be up at all times.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gitlab.com/signald/signald/internal/socket"
+)
+
+func main() {
+	listen := flag.String("listen", ":9595", "address to serve metrics on")
+	socketPath := flag.String("socket", socket.DefaultPath, "path to the signald socket")
+	interval := flag.Duration("interval", 30*time.Second, "how often to probe signald")
+	timeout := flag.Duration("timeout", 10*time.Second, "how long to wait for each reply")
+	accounts := flag.String("accounts", "", "comma separated accounts to report on (default: all accounts signald has)")
+	subscribe := flag.Bool("subscribe", false, "subscribe to the accounts to report listener state and incoming messages, see the warning in the docs")
+	flag.Parse()
+
+	e := &exporter{
+		socketPath:  *socketPath,
+		timeout:     *timeout,
+		subscribe:   *subscribe,
+		subscribed:  map[string]bool{},
+		listening:   map[string]bool{},
+		lastMessage: map[string]time.Time{},
+		received:    map[string]float64{},
+		errors:      map[errorKey]float64{},
+	}
+	for _, a := range strings.Split(*accounts, ",") {
+		if a = strings.TrimSpace(a); a != "" {
+			e.watch = append(e.watch, a)
+		}
+	}
+
+	go func() {
+		for {
+			e.probe()
+			time.Sleep(*interval)
+		}
+	}()
+
+	http.HandleFunc("/metrics", e.serveMetrics)
+	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`<html><body><a href="/metrics">metrics</a></body></html>`))
+	})
+	log.Printf("serving metrics on %s/metrics", *listen)
+	log.Fatal(http.ListenAndServe(*listen, nil))
+}
+
+type errorKey struct {
+	action    string
+	errorType string
+}
+
+type versionInfo struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	Branch  string `json:"branch"`
+	Commit  string `json:"commit"`
+}
+
+type exporter struct {
+	socketPath string
+	timeout    time.Duration
+	subscribe  bool
+	watch      []string
+
+	lock          sync.Mutex
+	conn          *socket.Conn
+	up            bool
+	probes        float64
+	probeFailures float64
+	probeDuration time.Duration
+	version       versionInfo
+	accounts      []string
+	subscribed    map[string]bool
+	listening     map[string]bool
+	lastMessage   map[string]time.Time
+	received      map[string]float64
+	errors        map[errorKey]float64
+}
+
+func (e *exporter) probe() {
+	started := time.Now()
+	ok := e.probeOnce()
+
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.probes++
+	if !ok {
+		e.probeFailures++
+	}
+	e.up = ok
+	e.probeDuration = time.Since(started)
+}
+
+func (e *exporter) probeOnce() bool {
+	conn, err := e.connection()
+	if err != nil {
+		e.countError("connect", err)
+		return false
+	}
+
+	var version versionInfo
+	if !e.request(conn, "v1", "version", nil, &version) {
+		return false
+	}
+	var list struct {
+		Accounts []struct {
+			AccountID string `json:"account_id"`
+		} `json:"accounts"`
+	}
+	if !e.request(conn, "v1", "list_accounts", nil, &list) {
+		return false
+	}
+	accounts := []string{}
+	for _, a := range list.Accounts {
+		accounts = append(accounts, a.AccountID)
+	}
+
+	e.lock.Lock()
+	e.version = version
+	e.accounts = accounts
+	e.lock.Unlock()
+
+	ok := true
+	if e.subscribe {
+		for _, account := range e.reported() {
+			e.lock.Lock()
+			subscribed := e.subscribed[account]
+			e.lock.Unlock()
+			if subscribed {
+				continue
+			}
+			if !e.request(conn, "v0", "subscribe", map[string]string{"username": account}, nil) {
+				ok = false
+				continue
+			}
+			e.lock.Lock()
+			e.subscribed[account] = true
+			// signald only announces when it starts or stops listening, not whether it already is
+			if _, known := e.listening[account]; !known {
+				e.listening[account] = true
+			}
+			e.lock.Unlock()
+		}
+	}
+	return ok
+}
+
+// request makes one request, counting the error if it fails. Connection errors drop the connection so the next probe
+// starts over.
+func (e *exporter) request(conn *socket.Conn, version, action string, payload interface{}, out interface{}) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), e.timeout)
+	defer cancel()
+	resp, err := conn.Request(ctx, version, action, payload)
+	if err == nil && out != nil {
+		err = json.Unmarshal(resp.Data, out)
+	}
+	if err == nil {
+		return true
+	}
+	e.countError(action, err)
+	var signaldErr *socket.Error
+	if !errors.As(err, &signaldErr) {
+		e.dropConnection(conn)
+	}
+	return false
+}
+
+func (e *exporter) countError(action string, err error) {
+	errorType := "connection"
+	var signaldErr *socket.Error
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &signaldErr):
+		errorType = signaldErr.Type
+		if errorType == "" {
+			errorType = "unexpected_error"
+		}
+	case errors.Is(err, context.DeadlineExceeded):
+		errorType = "timeout"
+	case errors.As(err, &syntaxErr), errors.As(err, &typeErr):
+		errorType = "invalid_response"
+	}
+	log.Printf("%s failed: %v", action, err)
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.errors[errorKey{action: action, errorType: errorType}]++
+}
+
+// reported is the accounts to report per account metrics for
+func (e *exporter) reported() []string {
+	if len(e.watch) > 0 {
+		return e.watch
+	}
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	return e.accounts
+}
+
+func (e *exporter) connection() (*socket.Conn, error) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if e.conn != nil {
+		return e.conn, nil
+	}
+	conn, err := socket.Dial(e.socketPath)
+	if err != nil {
+		return nil, err
+	}
+	e.conn = conn
+	incoming, _ := conn.Listen()
+	go e.listen(conn, incoming)
+	return conn, nil
+}
+
+func (e *exporter) dropConnection(conn *socket.Conn) {
+	conn.Close()
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if e.conn == conn {
+		e.conn = nil
+		e.subscribed = map[string]bool{}
+		e.listening = map[string]bool{}
+	}
+}
+
+// listen follows what signald sends on its own: incoming messages, and listen_started and listen_stopped as it
+// connects to and disconnects from the Signal servers for subscribed accounts
+func (e *exporter) listen(conn *socket.Conn, incoming <-chan socket.Response) {
+	for r := range incoming {
+		switch r.Type {
+		case "message":
+			var envelope struct {
+				Username string `json:"username"`
+			}
+			if json.Unmarshal(r.Data, &envelope) == nil && envelope.Username != "" {
+				e.lock.Lock()
+				e.received[envelope.Username]++
+				e.lastMessage[envelope.Username] = time.Now()
+				e.lock.Unlock()
+			}
+		case "listen_started", "listen_stopped":
+			var account string
+			if json.Unmarshal(r.Data, &account) == nil {
+				e.lock.Lock()
+				e.listening[account] = r.Type == "listen_started"
+				e.lock.Unlock()
+			}
+		}
+	}
+	e.dropConnection(conn)
+}
+
+func (e *exporter) serveMetrics(w http.ResponseWriter, r *http.Request) {
+	accounts := e.reported()
+
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m := metricWriter{w: w}
+
+	m.family("signald_up", "gauge", "Whether the last probe of signald succeeded.")
+	m.sample("signald_up", nil, boolValue(e.up))
+	m.family("signald_probe_duration_seconds", "gauge", "How long the last probe took.")
+	m.sample("signald_probe_duration_seconds", nil, e.probeDuration.Seconds())
+	m.family("signald_probes_total", "counter", "Probes of signald.")
+	m.sample("signald_probes_total", nil, e.probes)
+	m.family("signald_probe_failures_total", "counter", "Probes of signald that failed.")
+	m.sample("signald_probe_failures_total", nil, e.probeFailures)
+
+	m.family("signald_request_errors_total", "counter", "Failed requests by action and error type.")
+	keys := make([]errorKey, 0, len(e.errors))
+	for k := range e.errors {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].action != keys[j].action {
+			return keys[i].action < keys[j].action
+		}
+		return keys[i].errorType < keys[j].errorType
+	})
+	for _, k := range keys {
+		m.sample("signald_request_errors_total", map[string]string{"action": k.action, "error_type": k.errorType}, e.errors[k])
+	}
+
+	if e.version.Version != "" {
+		m.family("signald_version_info", "gauge", "The version of signald, as labels.")
+		m.sample("signald_version_info", map[string]string{"name": e.version.Name, "version": e.version.Version, "branch": e.version.Branch, "commit": e.version.Commit}, 1)
+	}
+	m.family("signald_accounts", "gauge", "Accounts registered with signald.")
+	m.sample("signald_accounts", nil, float64(len(e.accounts)))
+
+	m.family("signald_account_registered", "gauge", "Whether signald has the account.")
+	for _, account := range accounts {
+		m.sample("signald_account_registered", map[string]string{"account": account}, boolValue(stringInSlice(account, e.accounts)))
+	}
+	if !e.subscribe {
+		return
+	}
+	m.family("signald_account_subscribed", "gauge", "Whether the exporter is subscribed to the account.")
+	for _, account := range accounts {
+		m.sample("signald_account_subscribed", map[string]string{"account": account}, boolValue(e.subscribed[account]))
+	}
+	m.family("signald_account_listening", "gauge", "Whether signald is connected to the Signal servers for the account.")
+	for _, account := range accounts {
+		m.sample("signald_account_listening", map[string]string{"account": account}, boolValue(e.listening[account]))
+	}
+	m.family("signald_account_messages_received_total", "counter", "Messages received for the account while the exporter was subscribed.")
+	for _, account := range accounts {
+		m.sample("signald_account_messages_received_total", map[string]string{"account": account}, e.received[account])
+	}
+	m.family("signald_account_last_message_timestamp_seconds", "gauge", "When the last message for the account was received, use time() minus this for its age.")
+	for _, account := range accounts {
+		if t, ok := e.lastMessage[account]; ok {
+			m.sample("signald_account_last_message_timestamp_seconds", map[string]string{"account": account}, float64(t.UnixNano())/1e9)
+		}
+	}
+}
+
+func stringInSlice(s string, list []string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
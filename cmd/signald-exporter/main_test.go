@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"gitlab.com/signald/signald/internal/socket"
+)
+
+func TestCountError(t *testing.T) {
+	syntaxErr := json.Unmarshal([]byte(`{"accounts":`), &struct{}{})
+	typeErr := json.Unmarshal([]byte(`{"accounts":"x"}`), &struct{ Accounts []string }{})
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{"signald error", &socket.Error{Type: "NoSuchAccountError", Message: "no such account"}, "NoSuchAccountError"},
+		{"wrapped signald error", fmt.Errorf("list_accounts: %w", &socket.Error{Type: "InternalError"}), "InternalError"},
+		{"signald error without a type", &socket.Error{Message: "something"}, "unexpected_error"},
+		{"timeout", context.DeadlineExceeded, "timeout"},
+		{"wrapped timeout", fmt.Errorf("version: %w", context.DeadlineExceeded), "timeout"},
+		{"bad json", syntaxErr, "invalid_response"},
+		{"wrong json type", typeErr, "invalid_response"},
+		{"closed", socket.ErrClosed, "connection"},
+		{"anything else", errors.New("dial unix /var/run/signald/signald.sock: connect: no such file or directory"), "connection"},
+	}
+	for _, tt := range tests {
+		e := &exporter{errors: map[errorKey]float64{}}
+		e.countError("version", tt.err)
+		want := map[errorKey]float64{{action: "version", errorType: tt.want}: 1}
+		if !reflect.DeepEqual(e.errors, want) {
+			t.Errorf("%s: got %v, expected %v", tt.name, e.errors, want)
+		}
+	}
+
+	e := &exporter{errors: map[errorKey]float64{}}
+	e.countError("version", context.DeadlineExceeded)
+	e.countError("version", context.DeadlineExceeded)
+	e.countError("list_accounts", context.DeadlineExceeded)
+	want := map[errorKey]float64{{"version", "timeout"}: 2, {"list_accounts", "timeout"}: 1}
+	if !reflect.DeepEqual(e.errors, want) {
+		t.Errorf("got %v, expected errors counted by action and type %v", e.errors, want)
+	}
+}
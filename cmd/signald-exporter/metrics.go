@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// metricWriter writes the Prometheus text exposition format. Families must be written one at a time: family, then
+// all of its samples.
+type metricWriter struct {
+	w io.Writer
+}
+
+func (m metricWriter) family(name, metricType, help string) {
+	fmt.Fprintf(m.w, "# HELP %s %s\n# TYPE %s %s\n", name, helpEscaper.Replace(help), name, metricType)
+}
+
+func (m metricWriter) sample(name string, labels map[string]string, value float64) {
+	io.WriteString(m.w, name)
+	if len(labels) > 0 {
+		keys := make([]string, 0, len(labels))
+		for k := range labels {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		pairs := make([]string, 0, len(keys))
+		for _, k := range keys {
+			pairs = append(pairs, k+`="`+labelEscaper.Replace(labels[k])+`"`)
+		}
+		io.WriteString(m.w, "{"+strings.Join(pairs, ",")+"}")
+	}
+	io.WriteString(m.w, " "+strconv.FormatFloat(value, 'g', -1, 64)+"\n")
+}
+
+var (
+	labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+)
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
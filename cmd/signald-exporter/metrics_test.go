@@ -0,0 +1,52 @@
+package main
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestMetricWriterSample(t *testing.T) {
+	tests := []struct {
+		name   string
+		labels map[string]string
+		value  float64
+		want   string
+	}{
+		{"no labels", nil, 1, "signald_up 1\n"},
+		{"empty labels", map[string]string{}, 0, "signald_up 0\n"},
+		{"sorted labels", map[string]string{"version": "0.23.0", "name": "signald", "commit": "abc"}, 1, `signald_up{commit="abc",name="signald",version="0.23.0"} 1` + "\n"},
+		{"escaped quote", map[string]string{"account": `a"b`}, 1, `signald_up{account="a\"b"} 1` + "\n"},
+		{"escaped backslash", map[string]string{"account": `a\b`}, 1, `signald_up{account="a\\b"} 1` + "\n"},
+		{"escaped newline", map[string]string{"account": "a\nb"}, 1, `signald_up{account="a\nb"} 1` + "\n"},
+		{"fraction", nil, 0.25, "signald_up 0.25\n"},
+		{"large count", nil, 12345678, "signald_up 1.2345678e+07\n"},
+		{"timestamp", nil, 1700000000.5, "signald_up 1.7000000005e+09\n"},
+		{"infinity", nil, math.Inf(1), "signald_up +Inf\n"},
+		{"negative infinity", nil, math.Inf(-1), "signald_up -Inf\n"},
+		{"not a number", nil, math.NaN(), "signald_up NaN\n"},
+	}
+	for _, tt := range tests {
+		var b strings.Builder
+		metricWriter{w: &b}.sample("signald_up", tt.labels, tt.value)
+		if b.String() != tt.want {
+			t.Errorf("%s: got %q, expected %q", tt.name, b.String(), tt.want)
+		}
+	}
+}
+
+func TestMetricWriterFamily(t *testing.T) {
+	tests := []struct {
+		name, help, want string
+	}{
+		{"plain", "Whether the last probe of signald succeeded.", "# HELP signald_up Whether the last probe of signald succeeded.\n# TYPE signald_up gauge\n"},
+		{"escaped", "a\\b\nc \"d\"", "# HELP signald_up a\\\\b\\nc \"d\"\n# TYPE signald_up gauge\n"},
+	}
+	for _, tt := range tests {
+		var b strings.Builder
+		metricWriter{w: &b}.family("signald_up", "gauge", tt.help)
+		if b.String() != tt.want {
+			t.Errorf("%s: got %q, expected %q", tt.name, b.String(), tt.want)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"gitlab.com/signald/signald/internal/socket"
+)
+
+// keepaliveInterval is how often an idle event stream gets a comment line, so proxies don't time it out
+const keepaliveInterval = 30 * time.Second
+
+// events streams everything signald sends for an account without being asked, each as an event named after its type
+// with the whole message as data. Every stream has its own connection to signald, so the subscription ends when the
+// client goes away.
+func (g *gateway) events(w http.ResponseWriter, r *http.Request) {
+	account := r.URL.Query().Get("account")
+	if account == "" {
+		writeError(w, http.StatusBadRequest, "InvalidRequest", "account is required")
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "StreamingUnsupported", "the connection does not support streaming")
+		return
+	}
+
+	conn, err := socket.Dial(g.socketPath)
+	if err != nil {
+		writeError(w, http.StatusServiceUnavailable, "NotConnected", err.Error())
+		return
+	}
+	defer conn.Close()
+	incoming, stop := conn.Listen()
+	defer stop()
+
+	ctx, cancel := context.WithTimeout(r.Context(), g.timeout)
+	_, err = conn.Request(ctx, "v0", "subscribe", map[string]string{"username": account})
+	cancel()
+	if err != nil {
+		writeError(w, http.StatusBadGateway, "SubscribeFailed", err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepalive := time.NewTicker(keepaliveInterval)
+	defer keepalive.Stop()
+	for {
+		select {
+		case m, ok := <-incoming:
+			if !ok {
+				// signald went away, EventSource clients reconnect on their own
+				return
+			}
+			if m.Type == "version" {
+				// sent to every new connection, it's not about the account
+				continue
+			}
+			b, err := json.Marshal(m)
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", m.Type, b)
+		case <-keepalive.C:
+			fmt.Fprint(w, ": keepalive\n\n")
+		case <-r.Context().Done():
+			return
+		}
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,217 @@
+// signald-gateway exposes the signald socket over HTTP, for tools that can speak HTTP but not unix sockets:
+//
+//	POST /<version>/<action>          a request as documented in protocol.json, answered with signald's reply
+//	GET  /<version>/events?account=   incoming messages for an account as Server-Sent Events
+//
+// Request bodies are the JSON object that would be sent on the socket without id, type and version, and are checked
+// against the protocol documentation before they reach signald. Every request needs the token from -token-env as
+// "Authorization: Bearer <token>". Browsers can't set headers on EventSource, so the events endpoint also takes it
+// as ?access_token=. The token grants everything the socket does, so keep the gateway on a trusted network.
+//
+//	SIGNALD_GATEWAY_TOKEN=... signald-gateway -listen 127.0.0.1:8082
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"flag"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"gitlab.com/signald/signald/internal/protocol"
+	"gitlab.com/signald/signald/internal/socket"
+)
+
+// maxRequestSize is generous for any request body, attachments are referenced by path rather than uploaded
+const maxRequestSize = 1 << 20
+
+func main() {
+	listen := flag.String("listen", "127.0.0.1:8082", "address to listen on")
+	socketPath := flag.String("socket", socket.DefaultPath, "path to the signald socket")
+	protocolPath := flag.String("protocol", "", "protocol.json to validate requests against (default: ask signald for its own)")
+	tokenEnv := flag.String("token-env", "SIGNALD_GATEWAY_TOKEN", "environment variable holding the token clients must send")
+	timeout := flag.Duration("timeout", time.Minute, "how long to wait for signald to reply to a request")
+	flag.Parse()
+
+	token := os.Getenv(*tokenEnv)
+	if token == "" {
+		log.Fatalf("%s is not set, it holds the token clients must send", *tokenEnv)
+	}
+
+	g := &gateway{socketPath: *socketPath, token: token, timeout: *timeout}
+	var err error
+	if *protocolPath != "" {
+		g.protocol, err = protocol.Load(*protocolPath)
+	} else {
+		var conn *socket.Conn
+		conn, err = g.connection()
+		if err == nil {
+			ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+			g.protocol, err = protocol.Fetch(ctx, conn)
+			cancel()
+		}
+	}
+	if err != nil {
+		log.Fatal("error loading protocol documentation: ", err)
+	}
+
+	log.Printf("serving signald gateway on http://%s/", *listen)
+	log.Fatal(http.ListenAndServe(*listen, g))
+}
+
+type gateway struct {
+	socketPath string
+	token      string
+	timeout    time.Duration
+	protocol   *protocol.Protocol
+
+	// conn is shared by all requests and redialed when signald goes away, event streams have their own
+	lock sync.Mutex
+	conn *socket.Conn
+}
+
+func (g *gateway) connection() (*socket.Conn, error) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if g.conn == nil {
+		conn, err := socket.Dial(g.socketPath)
+		if err != nil {
+			return nil, err
+		}
+		g.conn = conn
+	}
+	return g.conn, nil
+}
+
+// dropConnection forgets conn after it failed, unless another request already replaced it
+func (g *gateway) dropConnection(conn *socket.Conn) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if g.conn == conn {
+		g.conn.Close()
+		g.conn = nil
+	}
+}
+
+func (g *gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) != 2 {
+		writeError(w, http.StatusNotFound, "NotFound", "expected /<version>/<action> or /<version>/events")
+		return
+	}
+	if !g.authorized(r) {
+		writeError(w, http.StatusUnauthorized, "Unauthorized", "missing or wrong bearer token")
+		return
+	}
+	version, action := parts[0], parts[1]
+	switch {
+	case action == "events" && r.Method == http.MethodGet:
+		g.events(w, r)
+	case r.Method == http.MethodPost:
+		g.request(w, r, version, action)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "MethodNotAllowed", "actions are POST, events are GET")
+	}
+}
+
+func (g *gateway) authorized(r *http.Request) bool {
+	const prefix = "Bearer "
+	var given string
+	if header := r.Header.Get("Authorization"); header != "" {
+		if !strings.HasPrefix(header, prefix) {
+			return false
+		}
+		given = header[len(prefix):]
+	} else if strings.HasSuffix(r.URL.Path, "/events") {
+		given = r.URL.Query().Get("access_token")
+	}
+	return given != "" && subtle.ConstantTimeCompare([]byte(given), []byte(g.token)) == 1
+}
+
+func (g *gateway) request(w http.ResponseWriter, r *http.Request, version, action string) {
+	a := g.protocol.Action(version, action)
+	if a == nil {
+		writeError(w, http.StatusNotFound, "UnknownAction", version+" "+action+" is not documented in the protocol")
+		return
+	}
+
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxRequestSize))
+	if err != nil {
+		writeError(w, http.StatusRequestEntityTooLarge, "RequestTooLarge", err.Error())
+		return
+	}
+	request := map[string]json.RawMessage{}
+	if len(strings.TrimSpace(string(body))) > 0 {
+		if err := json.Unmarshal(body, &request); err != nil {
+			writeError(w, http.StatusBadRequest, "InvalidRequest", "body must be a JSON object: "+err.Error())
+			return
+		}
+	}
+	// the envelope fields are ours to set
+	delete(request, "id")
+	delete(request, "type")
+	delete(request, "version")
+
+	if a.Request != "" {
+		checked, _ := json.Marshal(request)
+		if problems := g.protocol.Validate(version, a.Request, checked); len(problems) > 0 {
+			messages := []string{}
+			for _, problem := range problems {
+				messages = append(messages, problem.String())
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]interface{}{"error_type": "InvalidRequest", "error": map[string]interface{}{"message": "request does not match " + version + "." + a.Request, "problems": messages}})
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), g.timeout)
+	defer cancel()
+	resp, err := g.forward(ctx, version, action, request)
+	var signaldErr *socket.Error
+	switch {
+	case errors.As(err, &signaldErr):
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadGateway)
+		json.NewEncoder(w).Encode(map[string]interface{}{"error_type": signaldErr.Type, "error": signaldErr.Raw})
+	case errors.Is(err, context.DeadlineExceeded):
+		writeError(w, http.StatusGatewayTimeout, "Timeout", "signald did not reply in time")
+	case err != nil:
+		writeError(w, http.StatusServiceUnavailable, "NotConnected", err.Error())
+	default:
+		w.Header().Set("Content-Type", "application/json")
+		if len(resp.Data) == 0 {
+			resp.Data = json.RawMessage("{}")
+		}
+		w.Write(resp.Data)
+	}
+}
+
+// forward sends a request on the shared connection. When the connection turns out to be gone the request fails, since
+// signald may have handled it before going away, and the next request redials.
+func (g *gateway) forward(ctx context.Context, version, action string, request map[string]json.RawMessage) (socket.Response, error) {
+	conn, err := g.connection()
+	if err != nil {
+		return socket.Response{}, err
+	}
+	resp, err := conn.Request(ctx, version, action, request)
+	var signaldErr *socket.Error
+	if err != nil && !errors.As(err, &signaldErr) && ctx.Err() == nil {
+		g.dropConnection(conn)
+	}
+	return resp, err
+}
+
+func writeError(w http.ResponseWriter, status int, errorType, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{"error_type": errorType, "error": map[string]string{"message": message}})
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"gitlab.com/signald/signald/internal/protocol"
+)
+
+const testProtocol = `{
+	"actions": {"v1": {
+		"send": {"request": "SendRequest", "response": "SendResponse"},
+		"version": {"response": "JsonVersionMessage"}
+	}},
+	"types": {"v1": {
+		"SendRequest": {"fields": {
+			"username": {"type": "String", "required": true},
+			"messageBody": {"type": "String"},
+			"timestamp": {"type": "long"}
+		}},
+		"SendResponse": {"fields": {"timestamp": {"type": "long"}}},
+		"JsonVersionMessage": {"fields": {"version": {"type": "String"}}}
+	}}
+}`
+
+// fakeSignald answers each request with the reply handle returns for it, or nothing if it returns nil, and returns
+// the socket path
+func fakeSignald(t *testing.T, handle func(request map[string]interface{}) map[string]interface{}) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "signald.sock")
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				requests := bufio.NewScanner(conn)
+				for requests.Scan() {
+					var request map[string]interface{}
+					if err := json.Unmarshal(requests.Bytes(), &request); err != nil {
+						t.Errorf("request is not JSON: %v", err)
+						return
+					}
+					if reply := handle(request); reply != nil {
+						b, _ := json.Marshal(reply)
+						conn.Write(append(b, '\n'))
+					}
+				}
+			}()
+		}
+	}()
+	return path
+}
+
+func testGateway(t *testing.T, socketPath string) *httptest.Server {
+	t.Helper()
+	p, err := protocol.Decode(strings.NewReader(testProtocol))
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := &gateway{socketPath: socketPath, token: "t0ken", timeout: 100 * time.Millisecond, protocol: p}
+	server := httptest.NewServer(g)
+	t.Cleanup(func() {
+		server.Close()
+		if g.conn != nil {
+			g.conn.Close()
+		}
+	})
+	return server
+}
+
+func call(t *testing.T, method, url, authorization, body string) (int, string) {
+	t.Helper()
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, _ := ioutil.ReadAll(resp.Body)
+	return resp.StatusCode, string(b)
+}
+
+func TestAuthorized(t *testing.T) {
+	g := &gateway{token: "t0ken"}
+	tests := []struct {
+		name, path, authorization string
+		want                      bool
+	}{
+		{"header", "/v1/send", "Bearer t0ken", true},
+		{"header on events", "/v1/events?account=a", "Bearer t0ken", true},
+		{"no token", "/v1/send", "", false},
+		{"wrong token", "/v1/send", "Bearer nope", false},
+		{"token without bearer", "/v1/send", "t0ken", false},
+		{"other scheme", "/v1/send", "Basic t0ken", false},
+		{"empty bearer", "/v1/send", "Bearer ", false},
+		{"query on events", "/v1/events?account=a&access_token=t0ken", "", true},
+		{"wrong query on events", "/v1/events?account=a&access_token=nope", "", false},
+		{"query on an action", "/v1/send?access_token=t0ken", "", false},
+		{"wrong header wins over query", "/v1/events?access_token=t0ken", "Bearer nope", false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", tt.path, nil)
+		if tt.authorization != "" {
+			r.Header.Set("Authorization", tt.authorization)
+		}
+		if got := g.authorized(r); got != tt.want {
+			t.Errorf("%s: got %v, expected %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRequest(t *testing.T) {
+	var sent map[string]interface{}
+	socketPath := fakeSignald(t, func(request map[string]interface{}) map[string]interface{} {
+		reply := map[string]interface{}{"id": request["id"], "type": request["type"]}
+		switch request["messageBody"] {
+		case "fail":
+			reply["error_type"] = "UnregisteredUserError"
+			reply["error"] = map[string]string{"message": "not registered"}
+		case "hang":
+			return nil
+		default:
+			sent = request
+			reply["data"] = map[string]interface{}{"timestamp": 1700000000000}
+		}
+		return reply
+	})
+	server := testGateway(t, socketPath)
+
+	tests := []struct {
+		name, method, path, body string
+		status                   int
+		response                 string
+	}{
+		{"sent", "POST", "/v1/send", `{"id":"mine","type":"mark_read","version":"v0","username":"+12024561414","messageBody":"hi"}`, http.StatusOK, `{"timestamp":1700000000000}`},
+		{"signald error", "POST", "/v1/send", `{"username":"+12024561414","messageBody":"fail"}`, http.StatusBadGateway, `"error_type":"UnregisteredUserError"`},
+		{"timeout", "POST", "/v1/send", `{"username":"+12024561414","messageBody":"hang"}`, http.StatusGatewayTimeout, `"error_type":"Timeout"`},
+		{"not json", "POST", "/v1/send", `{"username":`, http.StatusBadRequest, `"error_type":"InvalidRequest"`},
+		{"not an object", "POST", "/v1/send", `["+12024561414"]`, http.StatusBadRequest, `"error_type":"InvalidRequest"`},
+		{"missing required field", "POST", "/v1/send", `{"messageBody":"hi"}`, http.StatusBadRequest, `required field is missing`},
+		{"wrong field type", "POST", "/v1/send", `{"username":"+12024561414","timestamp":"now"}`, http.StatusBadRequest, `expected an integer`},
+		{"undocumented field", "POST", "/v1/send", `{"username":"+12024561414","recipient":"x"}`, http.StatusBadRequest, `field is not documented`},
+		{"unknown action", "POST", "/v1/nope", `{}`, http.StatusNotFound, `"error_type":"UnknownAction"`},
+		{"get an action", "GET", "/v1/send", ``, http.StatusMethodNotAllowed, `"error_type":"MethodNotAllowed"`},
+		{"bad path", "POST", "/send", `{}`, http.StatusNotFound, `"error_type":"NotFound"`},
+	}
+	for _, tt := range tests {
+		status, body := call(t, tt.method, server.URL+tt.path, "Bearer t0ken", tt.body)
+		if status != tt.status || !strings.Contains(body, tt.response) {
+			t.Errorf("%s: got %d %s, expected %d containing %s", tt.name, status, body, tt.status, tt.response)
+		}
+	}
+
+	// the envelope is set by the gateway, not copied from the HTTP request
+	if sent["id"] == "mine" || sent["type"] != "send" || sent["version"] != "v1" || sent["messageBody"] != "hi" {
+		t.Errorf("got request %v", sent)
+	}
+}
+
+func TestRequestWithoutSignald(t *testing.T) {
+	server := testGateway(t, filepath.Join(t.TempDir(), "missing.sock"))
+	status, body := call(t, "POST", server.URL+"/v1/send", "Bearer t0ken", `{"username":"+12024561414"}`)
+	if status != http.StatusServiceUnavailable || !strings.Contains(body, `"error_type":"NotConnected"`) {
+		t.Errorf("got %d %s, expected 503 NotConnected", status, body)
+	}
+	status, body = call(t, "GET", server.URL+"/v1/events?account=%2B12024561414", "Bearer t0ken", "")
+	if status != http.StatusServiceUnavailable || !strings.Contains(body, `"error_type":"NotConnected"`) {
+		t.Errorf("events: got %d %s, expected 503 NotConnected", status, body)
+	}
+}
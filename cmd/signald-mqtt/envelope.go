@@ -0,0 +1,53 @@
+package main
+
+import "encoding/json"
+
+// incomingEnvelope is the part of a v1 JsonMessageEnvelope the bridge needs to pick a topic and build simple payloads
+type incomingEnvelope struct {
+	Username    string          `json:"username"`
+	Source      json.RawMessage `json:"source"`
+	Timestamp   int64           `json:"timestamp"`
+	DataMessage *struct {
+		Body        string            `json:"body"`
+		Attachments []json.RawMessage `json:"attachments"`
+		Group       *struct {
+			GroupID string `json:"groupId"`
+		} `json:"group"`
+		GroupV2 *struct {
+			ID string `json:"id"`
+		} `json:"groupV2"`
+	} `json:"dataMessage"`
+}
+
+func (e incomingEnvelope) groupID() string {
+	switch {
+	case e.DataMessage == nil:
+		return ""
+	case e.DataMessage.GroupV2 != nil:
+		return e.DataMessage.GroupV2.ID
+	case e.DataMessage.Group != nil:
+		return e.DataMessage.Group.GroupID
+	}
+	return ""
+}
+
+// simpleMessage is the payload published with payload: simple, for consumers that only care about the text
+type simpleMessage struct {
+	Account     string            `json:"account"`
+	Source      json.RawMessage   `json:"source"`
+	Group       string            `json:"group,omitempty"`
+	Timestamp   int64             `json:"timestamp"`
+	Body        string            `json:"body"`
+	Attachments []json.RawMessage `json:"attachments,omitempty"`
+}
+
+func (e incomingEnvelope) simple() simpleMessage {
+	return simpleMessage{
+		Account:     e.Username,
+		Source:      e.Source,
+		Group:       e.groupID(),
+		Timestamp:   e.Timestamp,
+		Body:        e.DataMessage.Body,
+		Attachments: e.DataMessage.Attachments,
+	}
+}
@@ -0,0 +1,324 @@
+// signald-mqtt publishes incoming Signal messages to an MQTT broker and sends messages published to a command topic,
+// for home automation and other pipelines built around MQTT.
+//
+//	signald-mqtt -config /etc/signald-mqtt.yaml
+//
+// Topics are under topic-prefix, with account numbers written without the leading + and group IDs in URL-safe
+// base64, since + and / have special meanings in MQTT topics:
+//
+//	signald/12024561414/messages                 messages sent to the account directly
+//	signald/12024561414/groups/<group>/messages  messages sent to a group the account is in
+//	signald/12024561414/events                   receipts, typing and other non-message events (envelope payloads only)
+//	signald/12024561414/send                     subscribed to: a v1 send request, username is filled in from the topic
+//	signald/12024561414/send/result              the reply to each send, with the "id" of the request if it had one
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"gitlab.com/signald/signald/internal/socket"
+)
+
+// Config is read from the file given with -config. Example:
+//
+//	socket: /var/run/signald/signald.sock
+//	accounts: ["+12024561414"]
+//	broker: ssl://mqtt.example.com:8883
+//	username: signald
+//	password-env: MQTT_PASSWORD
+//	qos: 1
+//	payload: simple
+type Config struct {
+	Socket   string   `yaml:"socket"`
+	Accounts []string `yaml:"accounts"`
+
+	Broker      string        `yaml:"broker"`
+	ClientID    string        `yaml:"client-id"`
+	Username    string        `yaml:"username"`
+	PasswordEnv string        `yaml:"password-env"`
+	KeepAlive   time.Duration `yaml:"keep-alive"`
+	TopicPrefix string        `yaml:"topic-prefix"`
+	QoS         byte          `yaml:"qos"`
+	Retain      bool          `yaml:"retain"`
+
+	// Payload is envelope to publish messages exactly as signald sent them, or simple for the flattened form in
+	// simpleMessage
+	Payload string `yaml:"payload"`
+
+	// Commands turns the send topics off when false
+	Commands *bool `yaml:"commands"`
+}
+
+const (
+	payloadEnvelope = "envelope"
+	payloadSimple   = "simple"
+)
+
+func loadConfig(path string) (*Config, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	config := &Config{
+		Socket:      socket.DefaultPath,
+		ClientID:    "signald-mqtt",
+		KeepAlive:   time.Minute,
+		TopicPrefix: "signald",
+		QoS:         1,
+		Payload:     payloadEnvelope,
+	}
+	if err := yaml.Unmarshal(b, config); err != nil {
+		return nil, fmt.Errorf("error parsing %s: %v", path, err)
+	}
+	if len(config.Accounts) == 0 {
+		return nil, fmt.Errorf("%s: no accounts configured", path)
+	}
+	if config.Broker == "" {
+		return nil, fmt.Errorf("%s: no broker configured", path)
+	}
+	if config.QoS > 1 {
+		return nil, fmt.Errorf("%s: qos must be 0 or 1", path)
+	}
+	if config.Payload != payloadEnvelope && config.Payload != payloadSimple {
+		return nil, fmt.Errorf("%s: payload must be %s or %s", path, payloadEnvelope, payloadSimple)
+	}
+	if config.PasswordEnv != "" && os.Getenv(config.PasswordEnv) == "" {
+		return nil, fmt.Errorf("%s: %s is not set, it holds the broker password", path, config.PasswordEnv)
+	}
+	config.TopicPrefix = strings.TrimSuffix(config.TopicPrefix, "/")
+	return config, nil
+}
+
+func (c *Config) commands() bool {
+	return c.Commands == nil || *c.Commands
+}
+
+func main() {
+	configPath := flag.String("config", "/etc/signald-mqtt.yaml", "configuration file")
+	flag.Parse()
+
+	config, err := loadConfig(*configPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		log.Println("shutting down")
+		cancel()
+	}()
+
+	b := &bridge{config: config}
+	backoff := time.Second
+	for ctx.Err() == nil {
+		started := time.Now()
+		err := b.run(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if time.Since(started) > time.Minute {
+			backoff = time.Second
+		}
+		log.Printf("bridge stopped (%v), reconnecting in %s", err, backoff)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return
+		}
+		if backoff < time.Minute {
+			backoff *= 2
+		}
+	}
+}
+
+// bridge connects to signald and the broker, and runs until either connection is lost
+type bridge struct {
+	config *Config
+}
+
+func (b *bridge) run(ctx context.Context) error {
+	conn, err := socket.Dial(b.config.Socket)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	mqtt, err := dialMQTT(mqttOptions{
+		Broker:    b.config.Broker,
+		ClientID:  b.config.ClientID,
+		Username:  b.config.Username,
+		Password:  os.Getenv(b.config.PasswordEnv),
+		KeepAlive: b.config.KeepAlive,
+	})
+	if err != nil {
+		return fmt.Errorf("error connecting to %s: %v", b.config.Broker, err)
+	}
+	defer mqtt.Close()
+
+	incoming, stop := conn.Listen()
+	defer stop()
+	for _, account := range b.config.Accounts {
+		subscribeCtx, cancel := context.WithTimeout(ctx, time.Minute)
+		_, err := conn.Request(subscribeCtx, "v0", "subscribe", map[string]string{"username": account})
+		cancel()
+		if err != nil {
+			return fmt.Errorf("error subscribing %s: %v", account, err)
+		}
+		if b.config.commands() {
+			if err := mqtt.Subscribe(b.topic(account, "send"), b.config.QoS); err != nil {
+				return fmt.Errorf("error subscribing to %s: %v", b.topic(account, "send"), err)
+			}
+		}
+	}
+	log.Printf("connected to %s and %s, subscribed %s", b.config.Socket, b.config.Broker, strings.Join(b.config.Accounts, ", "))
+
+	// commands are read on their own goroutine: publish below waits for the broker's PUBACK, which the MQTT read loop
+	// can't get to while it waits to hand over a command
+	go func() {
+		for m := range mqtt.Messages() {
+			// sends can take a while, don't hold up other commands meanwhile
+			go b.send(ctx, conn, mqtt, m)
+		}
+	}()
+
+	for {
+		select {
+		case r, ok := <-incoming:
+			if !ok {
+				return socket.ErrClosed
+			}
+			if err := b.publish(mqtt, r); err != nil {
+				return err
+			}
+		case <-mqtt.Done():
+			return errMQTTClosed
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// topic builds a topic under the account's prefix
+func (b *bridge) topic(account string, parts ...string) string {
+	return b.config.TopicPrefix + "/" + strings.TrimPrefix(account, "+") + "/" + strings.Join(parts, "/")
+}
+
+// account finds the configured account a topic segment refers to
+func (b *bridge) account(segment string) (string, bool) {
+	for _, account := range b.config.Accounts {
+		if strings.TrimPrefix(account, "+") == segment {
+			return account, true
+		}
+	}
+	return "", false
+}
+
+func (b *bridge) publish(mqtt *mqttClient, r socket.Response) error {
+	if r.Type != "message" {
+		return nil
+	}
+	var envelope incomingEnvelope
+	if err := json.Unmarshal(r.Data, &envelope); err != nil {
+		log.Println("error decoding incoming message:", err)
+		return nil
+	}
+
+	var topic string
+	switch {
+	case envelope.DataMessage == nil:
+		topic = b.topic(envelope.Username, "events")
+	case envelope.groupID() != "":
+		topic = b.topic(envelope.Username, "groups", topicSafe(envelope.groupID()), "messages")
+	default:
+		topic = b.topic(envelope.Username, "messages")
+	}
+
+	var payload []byte
+	var err error
+	if b.config.Payload == payloadSimple {
+		if envelope.DataMessage == nil {
+			return nil
+		}
+		payload, err = json.Marshal(envelope.simple())
+	} else {
+		payload, err = json.Marshal(r)
+	}
+	if err != nil {
+		return err
+	}
+	return mqtt.Publish(topic, payload, b.config.QoS, b.config.Retain)
+}
+
+// send handles a message on a send topic
+func (b *bridge) send(ctx context.Context, conn *socket.Conn, mqtt *mqttClient, m mqttMessage) {
+	parts := strings.Split(strings.TrimPrefix(m.Topic, b.config.TopicPrefix+"/"), "/")
+	if len(parts) != 2 || parts[1] != "send" {
+		return
+	}
+	account, ok := b.account(parts[0])
+	if !ok {
+		return
+	}
+	resultTopic := b.topic(account, "send", "result")
+
+	result := sendResult{}
+	request := map[string]json.RawMessage{}
+	if err := json.Unmarshal(m.Payload, &request); err != nil {
+		result.ErrorType = "InvalidRequest"
+		result.Error, _ = json.Marshal(map[string]string{"message": "payload must be a JSON object: " + err.Error()})
+	} else {
+		result.ID = request["id"]
+		delete(request, "id")
+		delete(request, "type")
+		delete(request, "version")
+		request["username"], _ = json.Marshal(account)
+
+		sendCtx, cancel := context.WithTimeout(ctx, time.Minute)
+		resp, err := conn.Request(sendCtx, "v1", "send", request)
+		cancel()
+		if signaldErr, ok := err.(*socket.Error); ok {
+			result.ErrorType = signaldErr.Type
+			result.Error = signaldErr.Raw
+		} else if err != nil {
+			result.ErrorType = "NotSent"
+			result.Error, _ = json.Marshal(map[string]string{"message": err.Error()})
+		} else {
+			result.Data = resp.Data
+		}
+	}
+
+	payload, err := json.Marshal(result)
+	if err != nil {
+		return
+	}
+	if err := mqtt.Publish(resultTopic, payload, b.config.QoS, false); err != nil {
+		log.Printf("error publishing to %s: %v", resultTopic, err)
+	}
+}
+
+type sendResult struct {
+	ID        json.RawMessage `json:"id,omitempty"`
+	Data      json.RawMessage `json:"data,omitempty"`
+	ErrorType string          `json:"error_type,omitempty"`
+	Error     json.RawMessage `json:"error,omitempty"`
+}
+
+// topicSafe rewrites base64 to its URL-safe form, which has no characters MQTT treats specially
+func topicSafe(s string) string {
+	return strings.NewReplacer("+", "-", "/", "_").Replace(s)
+}
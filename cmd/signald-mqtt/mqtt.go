@@ -0,0 +1,387 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// MQTT 3.1.1 control packet types, the subset the bridge uses. QoS 2 is not supported.
+const (
+	packetConnect     = 1
+	packetConnack     = 2
+	packetPublish     = 3
+	packetPuback      = 4
+	packetSubscribe   = 8
+	packetSuback      = 9
+	packetPingreq     = 12
+	packetPingresp    = 13
+	packetDisconnect  = 14
+	maxRemainingBytes = 268435455
+)
+
+var errMQTTClosed = errors.New("MQTT connection closed")
+
+// mqttMessage is a PUBLISH received from the broker
+type mqttMessage struct {
+	Topic   string
+	Payload []byte
+}
+
+// mqttClient is a minimal MQTT 3.1.1 client: QoS 0 and 1 in both directions, clean sessions, no will
+type mqttClient struct {
+	conn      net.Conn
+	keepAlive time.Duration
+
+	writeLock sync.Mutex
+
+	lock     sync.Mutex
+	nextID   uint16
+	pending  map[uint16]chan error
+	closed   bool
+	messages chan mqttMessage
+	done     chan struct{}
+}
+
+type mqttOptions struct {
+	Broker    string // tcp://host:1883 or ssl://host:8883
+	ClientID  string
+	Username  string
+	Password  string
+	KeepAlive time.Duration
+}
+
+func dialMQTT(o mqttOptions) (*mqttClient, error) {
+	u, err := url.Parse(o.Broker)
+	if err != nil {
+		return nil, fmt.Errorf("invalid broker %q: %v", o.Broker, err)
+	}
+	var conn net.Conn
+	dialer := &net.Dialer{Timeout: 30 * time.Second}
+	switch u.Scheme {
+	case "tcp", "mqtt":
+		conn, err = dialer.Dial("tcp", hostPort(u, "1883"))
+	case "ssl", "tls", "mqtts":
+		conn, err = tls.DialWithDialer(dialer, "tcp", hostPort(u, "8883"), &tls.Config{ServerName: u.Hostname()})
+	default:
+		return nil, fmt.Errorf("invalid broker %q: scheme must be tcp or ssl", o.Broker)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	c := &mqttClient{
+		conn:      conn,
+		keepAlive: o.KeepAlive,
+		pending:   map[uint16]chan error{},
+		messages:  make(chan mqttMessage, 100),
+		done:      make(chan struct{}),
+	}
+	reader := bufio.NewReader(conn)
+	if err := c.connect(reader, o); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	go c.readLoop(reader)
+	go c.pingLoop()
+	return c, nil
+}
+
+func hostPort(u *url.URL, defaultPort string) string {
+	if u.Port() == "" {
+		return net.JoinHostPort(u.Hostname(), defaultPort)
+	}
+	return u.Host
+}
+
+func (c *mqttClient) connect(reader *bufio.Reader, o mqttOptions) error {
+	var body []byte
+	body = appendString(body, "MQTT")
+	body = append(body, 4) // protocol level 3.1.1
+	flags := byte(0x02)    // clean session
+	if o.Username != "" {
+		flags |= 0x80
+		if o.Password != "" {
+			flags |= 0x40
+		}
+	}
+	body = append(body, flags)
+	keepAlive := uint16(o.KeepAlive / time.Second)
+	body = append(body, byte(keepAlive>>8), byte(keepAlive))
+	body = appendString(body, o.ClientID)
+	if o.Username != "" {
+		body = appendString(body, o.Username)
+		if o.Password != "" {
+			body = appendString(body, o.Password)
+		}
+	}
+
+	c.conn.SetDeadline(time.Now().Add(30 * time.Second))
+	defer c.conn.SetDeadline(time.Time{})
+	if err := c.writePacket(packetConnect<<4, body); err != nil {
+		return err
+	}
+	header, payload, err := readPacket(reader)
+	if err != nil {
+		return err
+	}
+	if header>>4 != packetConnack || len(payload) != 2 {
+		return fmt.Errorf("broker did not acknowledge the connection")
+	}
+	switch payload[1] {
+	case 0:
+		return nil
+	case 4, 5:
+		return fmt.Errorf("broker refused the connection: not authorized")
+	default:
+		return fmt.Errorf("broker refused the connection with code %d", payload[1])
+	}
+}
+
+// Messages returns the PUBLISHes received on subscribed topics. It is closed when the connection closes. Nothing else
+// is read from the broker while a message waits to be received, including the acknowledgements Publish and Subscribe
+// wait for, so it must be drained independently of them.
+func (c *mqttClient) Messages() <-chan mqttMessage {
+	return c.messages
+}
+
+// Done is closed when the connection closes
+func (c *mqttClient) Done() <-chan struct{} {
+	return c.done
+}
+
+// Publish sends a message, waiting for the broker to acknowledge it at QoS 1
+func (c *mqttClient) Publish(topic string, payload []byte, qos byte, retain bool) error {
+	header := byte(packetPublish << 4)
+	if retain {
+		header |= 0x01
+	}
+	body := appendString(nil, topic)
+	var ack chan error
+	if qos > 0 {
+		header |= 0x02
+		var id uint16
+		id, ack = c.track()
+		body = append(body, byte(id>>8), byte(id))
+	}
+	body = append(body, payload...)
+	if err := c.writePacket(header, body); err != nil {
+		return err
+	}
+	return c.wait(ack)
+}
+
+// Subscribe asks the broker for messages on a topic filter
+func (c *mqttClient) Subscribe(filter string, qos byte) error {
+	id, ack := c.track()
+	body := []byte{byte(id >> 8), byte(id)}
+	body = appendString(body, filter)
+	body = append(body, qos)
+	if err := c.writePacket(packetSubscribe<<4|0x02, body); err != nil {
+		return err
+	}
+	return c.wait(ack)
+}
+
+func (c *mqttClient) Close() error {
+	c.writePacket(packetDisconnect<<4, nil)
+	return c.conn.Close()
+}
+
+// track allocates a packet identifier and the channel its acknowledgement is reported on
+func (c *mqttClient) track() (uint16, chan error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	ch := make(chan error, 1)
+	if c.closed {
+		ch <- errMQTTClosed
+		return 0, ch
+	}
+	for {
+		c.nextID++
+		if _, taken := c.pending[c.nextID]; c.nextID != 0 && !taken {
+			break
+		}
+	}
+	c.pending[c.nextID] = ch
+	return c.nextID, ch
+}
+
+func (c *mqttClient) wait(ack chan error) error {
+	if ack == nil {
+		return nil
+	}
+	select {
+	case err := <-ack:
+		return err
+	case <-time.After(30 * time.Second):
+		c.conn.Close()
+		return fmt.Errorf("broker did not acknowledge in time")
+	}
+}
+
+func (c *mqttClient) acknowledge(id uint16, err error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if ch, ok := c.pending[id]; ok {
+		ch <- err
+		delete(c.pending, id)
+	}
+}
+
+func (c *mqttClient) readLoop(reader *bufio.Reader) {
+	defer c.shutdown()
+	for {
+		header, body, err := readPacket(reader)
+		if err != nil {
+			return
+		}
+		switch header >> 4 {
+		case packetPublish:
+			m, id, err := parsePublish(header, body)
+			if err != nil {
+				return
+			}
+			// acknowledge before handing the message over, the broker shouldn't wait on the consumer
+			if id != 0 {
+				c.writePacket(packetPuback<<4, []byte{byte(id >> 8), byte(id)})
+			}
+			c.messages <- m
+		case packetPuback:
+			if len(body) >= 2 {
+				c.acknowledge(binary.BigEndian.Uint16(body), nil)
+			}
+		case packetSuback:
+			if len(body) >= 3 {
+				var err error
+				if body[2] == 0x80 {
+					err = fmt.Errorf("broker refused the subscription")
+				}
+				c.acknowledge(binary.BigEndian.Uint16(body), err)
+			}
+		}
+	}
+}
+
+func (c *mqttClient) pingLoop() {
+	if c.keepAlive <= 0 {
+		return
+	}
+	ticker := time.NewTicker(c.keepAlive / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := c.writePacket(packetPingreq<<4, nil); err != nil {
+				c.conn.Close()
+				return
+			}
+		case <-c.done:
+			return
+		}
+	}
+}
+
+func (c *mqttClient) shutdown() {
+	c.conn.Close()
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.closed = true
+	for id, ch := range c.pending {
+		ch <- errMQTTClosed
+		delete(c.pending, id)
+	}
+	close(c.messages)
+	close(c.done)
+}
+
+func (c *mqttClient) writePacket(header byte, body []byte) error {
+	if len(body) > maxRemainingBytes {
+		return fmt.Errorf("MQTT packet too large")
+	}
+	packet := appendLength([]byte{header}, len(body))
+	packet = append(packet, body...)
+
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	_, err := c.conn.Write(packet)
+	return err
+}
+
+// appendLength appends an MQTT remaining length: seven bits per byte, least significant first, with the top bit set
+// on every byte but the last
+func appendLength(b []byte, length int) []byte {
+	for {
+		digit := byte(length % 128)
+		length /= 128
+		if length > 0 {
+			digit |= 0x80
+		}
+		b = append(b, digit)
+		if length == 0 {
+			return b
+		}
+	}
+}
+
+func readPacket(reader *bufio.Reader) (byte, []byte, error) {
+	header, err := reader.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	length, multiplier := 0, 1
+	for i := 0; ; i++ {
+		b, err := reader.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		length += int(b&0x7f) * multiplier
+		if b&0x80 == 0 {
+			break
+		}
+		if i == 3 {
+			return 0, nil, fmt.Errorf("malformed MQTT packet length")
+		}
+		multiplier *= 128
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(reader, body); err != nil {
+		return 0, nil, err
+	}
+	return header, body, nil
+}
+
+func parsePublish(header byte, body []byte) (mqttMessage, uint16, error) {
+	if len(body) < 2 {
+		return mqttMessage{}, 0, fmt.Errorf("malformed PUBLISH")
+	}
+	topicLength := int(binary.BigEndian.Uint16(body))
+	rest := body[2:]
+	if len(rest) < topicLength {
+		return mqttMessage{}, 0, fmt.Errorf("malformed PUBLISH")
+	}
+	m := mqttMessage{Topic: string(rest[:topicLength])}
+	rest = rest[topicLength:]
+	var id uint16
+	if qos := header >> 1 & 0x03; qos > 0 {
+		if len(rest) < 2 {
+			return mqttMessage{}, 0, fmt.Errorf("malformed PUBLISH")
+		}
+		id = binary.BigEndian.Uint16(rest)
+		rest = rest[2:]
+	}
+	m.Payload = rest
+	return m, id, nil
+}
+
+func appendString(b []byte, s string) []byte {
+	b = append(b, byte(len(s)>>8), byte(len(s)))
+	return append(b, s...)
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAppendLength(t *testing.T) {
+	tests := []struct {
+		length int
+		want   []byte
+	}{
+		{0, []byte{0x00}},
+		{127, []byte{0x7f}},
+		{128, []byte{0x80, 0x01}},
+		{16383, []byte{0xff, 0x7f}},
+		{16384, []byte{0x80, 0x80, 0x01}},
+		{2097151, []byte{0xff, 0xff, 0x7f}},
+		{2097152, []byte{0x80, 0x80, 0x80, 0x01}},
+		{maxRemainingBytes, []byte{0xff, 0xff, 0xff, 0x7f}},
+	}
+	for _, tt := range tests {
+		if got := appendLength(nil, tt.length); !bytes.Equal(got, tt.want) {
+			t.Errorf("appendLength(%d) = % x, expected % x", tt.length, got, tt.want)
+		}
+	}
+}
+
+func TestWritePacketRoundTrip(t *testing.T) {
+	for _, length := range []int{0, 1, 127, 128, 16384, 2097152} {
+		client, broker := net.Pipe()
+		c := &mqttClient{conn: client}
+		body := bytes.Repeat([]byte{'x'}, length)
+		go func() {
+			c.writePacket(packetPublish<<4, body)
+			client.Close()
+		}()
+		header, got, err := readPacket(bufio.NewReader(broker))
+		if err != nil {
+			t.Errorf("length %d: %v", length, err)
+		} else if header != packetPublish<<4 || len(got) != length {
+			t.Errorf("length %d: read header %x with %d bytes", length, header, len(got))
+		}
+		broker.Close()
+	}
+}
+
+func TestReadPacket(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  []byte
+		header byte
+		body   []byte
+		err    string
+	}{
+		{name: "empty packet", input: []byte{0xd0, 0x00}, header: 0xd0, body: []byte{}},
+		{name: "short body", input: []byte{0x40, 0x02, 0x00, 0x01}, header: 0x40, body: []byte{0x00, 0x01}},
+		{name: "two byte length", input: append([]byte{0x30, 0x80, 0x01}, make([]byte, 128)...), header: 0x30, body: make([]byte, 128)},
+		{name: "nothing", input: []byte{}, err: io.EOF.Error()},
+		{name: "no length", input: []byte{0x30}, err: io.EOF.Error()},
+		{name: "length cut off", input: []byte{0x30, 0x80}, err: io.EOF.Error()},
+		{name: "five byte length", input: []byte{0x30, 0x80, 0x80, 0x80, 0x80, 0x01}, err: "malformed MQTT packet length"},
+		{name: "truncated body", input: []byte{0x30, 0x05, 0x00, 0x01, 'a'}, err: io.ErrUnexpectedEOF.Error()},
+	}
+	for _, tt := range tests {
+		header, body, err := readPacket(bufio.NewReader(bytes.NewReader(tt.input)))
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s: got error %v, expected %q", tt.name, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", tt.name, err)
+			continue
+		}
+		if header != tt.header || !bytes.Equal(body, tt.body) {
+			t.Errorf("%s: got header %x body % x, expected header %x body % x", tt.name, header, body, tt.header, tt.body)
+		}
+	}
+}
+
+func TestParsePublish(t *testing.T) {
+	tests := []struct {
+		name    string
+		header  byte
+		body    []byte
+		message mqttMessage
+		id      uint16
+		err     bool
+	}{
+		{name: "qos 0", header: 0x30, body: append(appendString(nil, "a/b"), "hi"...), message: mqttMessage{Topic: "a/b", Payload: []byte("hi")}},
+		{name: "qos 1", header: 0x32, body: append(append(appendString(nil, "a/b"), 0x01, 0x02), "hi"...), message: mqttMessage{Topic: "a/b", Payload: []byte("hi")}, id: 0x0102},
+		{name: "retained, no payload", header: 0x31, body: appendString(nil, "a/b"), message: mqttMessage{Topic: "a/b", Payload: []byte{}}},
+		{name: "empty", header: 0x30, body: []byte{}, err: true},
+		{name: "half a topic length", header: 0x30, body: []byte{0x00}, err: true},
+		{name: "topic longer than packet", header: 0x30, body: []byte{0x00, 0x05, 'a', '/', 'b'}, err: true},
+		{name: "qos 1 without id", header: 0x32, body: append(appendString(nil, "a/b"), 0x01), err: true},
+	}
+	for _, tt := range tests {
+		message, id, err := parsePublish(tt.header, tt.body)
+		if tt.err {
+			if err == nil {
+				t.Errorf("%s: expected an error, got %+v", tt.name, message)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(message, tt.message) || id != tt.id {
+			t.Errorf("%s: got %+v id %d, expected %+v id %d", tt.name, message, id, tt.message, tt.id)
+		}
+	}
+}
+
+// TestPubackBeforeDelivery checks that a QoS 1 message is acknowledged even when nothing is receiving messages yet
+func TestPubackBeforeDelivery(t *testing.T) {
+	client, broker := net.Pipe()
+	defer broker.Close()
+	c := &mqttClient{conn: client, pending: map[uint16]chan error{}, messages: make(chan mqttMessage), done: make(chan struct{})}
+	go c.readLoop(bufio.NewReader(client))
+
+	body := append(append(appendString(nil, "signald/send"), 0x00, 0x07), "{}"...)
+	go broker.Write(append(appendLength([]byte{packetPublish<<4 | 0x02}, len(body)), body...))
+
+	broker.SetReadDeadline(time.Now().Add(5 * time.Second))
+	header, ack, err := readPacket(bufio.NewReader(broker))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header>>4 != packetPuback || !bytes.Equal(ack, []byte{0x00, 0x07}) {
+		t.Errorf("expected PUBACK for 7, got header %x body % x", header, ack)
+	}
+	if m := <-c.Messages(); m.Topic != "signald/send" {
+		t.Errorf("delivered %+v", m)
+	}
+}
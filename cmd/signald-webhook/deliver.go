@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"gitlab.com/signald/signald/internal/socket"
+)
+
+// incomingMessage is one message from signald, POSTed to endpoints exactly as signald sent it
+type incomingMessage struct {
+	Account string
+	Body    []byte
+
+	// Delivery identifies the message across retries, so receivers can drop duplicates
+	Delivery string
+}
+
+func newIncomingMessage(r socket.Response) (incomingMessage, error) {
+	var envelope struct {
+		Username string `json:"username"`
+	}
+	if err := json.Unmarshal(r.Data, &envelope); err != nil {
+		return incomingMessage{}, err
+	}
+	body, err := json.Marshal(r)
+	if err != nil {
+		return incomingMessage{}, err
+	}
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return incomingMessage{}, err
+	}
+	return incomingMessage{Account: envelope.Username, Body: body, Delivery: hex.EncodeToString(id)}, nil
+}
+
+// deliverer POSTs messages to one endpoint in the order they arrived, so a slow endpoint doesn't hold up the others.
+//
+// Requests are signed when the endpoint has a secret: X-Signald-Signature is sha256= followed by the hex HMAC-SHA256
+// of the X-Signald-Timestamp header, a dot and the body. Receivers should recompute it and reject old timestamps.
+type deliverer struct {
+	endpoint   Endpoint
+	secret     []byte
+	retry      Retry
+	deadLetter *deadLetter
+	client     *http.Client
+	queue      chan incomingMessage
+}
+
+func newDeliverer(e Endpoint, retry Retry, deadLetter *deadLetter) *deliverer {
+	d := &deliverer{
+		endpoint:   e,
+		retry:      retry,
+		deadLetter: deadLetter,
+		client:     &http.Client{Timeout: 30 * time.Second},
+		queue:      make(chan incomingMessage, e.QueueSize),
+	}
+	if e.SecretEnv != "" {
+		d.secret = []byte(os.Getenv(e.SecretEnv))
+	}
+	return d
+}
+
+func (d *deliverer) enqueue(m incomingMessage) {
+	if len(d.endpoint.Accounts) > 0 && !stringInSlice(m.Account, d.endpoint.Accounts) {
+		return
+	}
+	select {
+	case d.queue <- m:
+	default:
+		d.deadLetter.write(d.endpoint.URL, m, 0, fmt.Errorf("delivery queue full"))
+	}
+}
+
+func (d *deliverer) run(ctx context.Context) {
+	for {
+		select {
+		case m := <-d.queue:
+			attempts, err := d.deliver(ctx, m)
+			if err != nil {
+				d.deadLetter.write(d.endpoint.URL, m, attempts, err)
+			}
+		case <-ctx.Done():
+			// nothing else will deliver what's still queued, keep it
+			for {
+				select {
+				case m := <-d.queue:
+					d.deadLetter.write(d.endpoint.URL, m, 0, fmt.Errorf("shutting down"))
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// deliver tries to POST a message until it is accepted, it fails permanently or the attempts run out
+func (d *deliverer) deliver(ctx context.Context, m incomingMessage) (int, error) {
+	backoff := d.retry.Backoff
+	var err error
+	for attempt := 1; attempt <= d.retry.Attempts; attempt++ {
+		var retryable bool
+		retryable, err = d.post(ctx, m)
+		if err == nil {
+			return attempt, nil
+		}
+		if !retryable || attempt == d.retry.Attempts {
+			return attempt, err
+		}
+		log.Printf("delivery %s to %s failed, retrying in %s: %v", m.Delivery, d.endpoint.URL, backoff, err)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return attempt, ctx.Err()
+		}
+		backoff *= 2
+		if d.retry.MaxBackoff > 0 && backoff > d.retry.MaxBackoff {
+			backoff = d.retry.MaxBackoff
+		}
+	}
+	return d.retry.Attempts, err
+}
+
+func (d *deliverer) post(ctx context.Context, m incomingMessage) (retryable bool, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.endpoint.URL, bytes.NewReader(m.Body))
+	if err != nil {
+		return false, err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Signald-Account", m.Account)
+	req.Header.Set("X-Signald-Delivery", m.Delivery)
+	req.Header.Set("X-Signald-Timestamp", timestamp)
+	if d.secret != nil {
+		req.Header.Set("X-Signald-Signature", "sha256="+sign(d.secret, timestamp, m.Body))
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode <= 299:
+		return false, nil
+	case resp.StatusCode == http.StatusRequestTimeout, resp.StatusCode == http.StatusTooManyRequests, resp.StatusCode >= 500:
+		return true, fmt.Errorf("endpoint responded %s", resp.Status)
+	default:
+		return false, fmt.Errorf("endpoint responded %s", resp.Status)
+	}
+}
+
+func sign(secret []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// deadLetter keeps messages that could not be delivered, one JSON object per line, so they can be replayed by hand.
+// Without a file they are only logged.
+type deadLetter struct {
+	lock    sync.Mutex
+	encoder *json.Encoder
+}
+
+type deadLetterRecord struct {
+	Time     time.Time       `json:"time"`
+	Endpoint string          `json:"endpoint"`
+	Account  string          `json:"account"`
+	Delivery string          `json:"delivery"`
+	Attempts int             `json:"attempts"`
+	Error    string          `json:"error"`
+	Message  json.RawMessage `json:"message"`
+}
+
+func openDeadLetter(path string) (*deadLetter, error) {
+	if path == "" {
+		return &deadLetter{}, nil
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &deadLetter{encoder: json.NewEncoder(f)}, nil
+}
+
+func (d *deadLetter) write(endpoint string, m incomingMessage, attempts int, reason error) {
+	log.Printf("giving up on delivery %s to %s after %d attempts: %v", m.Delivery, endpoint, attempts, reason)
+	if d.encoder == nil {
+		return
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	record := deadLetterRecord{Time: time.Now().UTC(), Endpoint: endpoint, Account: m.Account, Delivery: m.Delivery, Attempts: attempts, Error: reason.Error(), Message: m.Body}
+	if err := d.encoder.Encode(record); err != nil {
+		log.Println("error writing to dead letter file:", err)
+	}
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSign(t *testing.T) {
+	// printf '1700000000.{"type":"message"}' | openssl dgst -sha256 -hmac s3cret
+	if got := sign([]byte("s3cret"), "1700000000", []byte(`{"type":"message"}`)); got != "dad3e0b3265c452a5f681627b4b1ee2bf0d5a906fec2e0791380c348ea1c1752" {
+		t.Errorf("got %s for the known vector", got)
+	}
+
+	signed := sign([]byte("s3cret"), "1700000000", []byte("body"))
+	tests := []struct {
+		name string
+		got  string
+	}{
+		{"other secret", sign([]byte("other"), "1700000000", []byte("body"))},
+		{"other timestamp", sign([]byte("s3cret"), "1700000001", []byte("body"))},
+		{"other body", sign([]byte("s3cret"), "1700000000", []byte("body!"))},
+		// the dot keeps the timestamp and body from running into each other
+		{"digit moved into the body", sign([]byte("s3cret"), "170000000", []byte("0body"))},
+	}
+	for _, tt := range tests {
+		if tt.got == signed {
+			t.Errorf("%s: got the same signature", tt.name)
+		}
+	}
+}
+
+// endpoint answers deliveries with statuses in turn, repeating the last one, and checks their signatures
+func endpoint(t *testing.T, secret string, statuses ...int) (*httptest.Server, *int32) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := int(atomic.AddInt32(&requests, 1))
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Error(err)
+		}
+		want := "sha256=" + sign([]byte(secret), r.Header.Get("X-Signald-Timestamp"), body)
+		if got := r.Header.Get("X-Signald-Signature"); got != want {
+			t.Errorf("request %d: signature %q, expected %q", n, got, want)
+		}
+		if r.Header.Get("X-Signald-Delivery") != "d1" || r.Header.Get("X-Signald-Account") != "+12024561414" {
+			t.Errorf("request %d: missing delivery headers: %v", n, r.Header)
+		}
+		if n > len(statuses) {
+			n = len(statuses)
+		}
+		w.WriteHeader(statuses[n-1])
+	}))
+	t.Cleanup(server.Close)
+	return server, &requests
+}
+
+func testDeliverer(url string, retry Retry, deadLetter *deadLetter) *deliverer {
+	d := newDeliverer(Endpoint{URL: url, QueueSize: 1}, retry, deadLetter)
+	d.secret = []byte("s3cret")
+	return d
+}
+
+var testMessage = incomingMessage{Account: "+12024561414", Body: []byte(`{"type":"message"}`), Delivery: "d1"}
+
+func TestDeliver(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []int
+		attempts int
+		err      string
+	}{
+		{"accepted", []int{200}, 1, ""},
+		{"retried until accepted", []int{500, 503, 204}, 3, ""},
+		{"rate limited", []int{429, 200}, 2, ""},
+		{"request timeout", []int{408, 200}, 2, ""},
+		{"permanent failure", []int{500, 400, 200}, 2, "400 Bad Request"},
+		{"attempts run out", []int{502}, 4, "502 Bad Gateway"},
+	}
+	for _, tt := range tests {
+		server, requests := endpoint(t, "s3cret", tt.statuses...)
+		d := testDeliverer(server.URL, Retry{Attempts: 4, Backoff: time.Millisecond, MaxBackoff: 2 * time.Millisecond}, &deadLetter{})
+		attempts, err := d.deliver(context.Background(), testMessage)
+		if attempts != tt.attempts || int(atomic.LoadInt32(requests)) != tt.attempts {
+			t.Errorf("%s: got %d attempts and %d requests, expected %d", tt.name, attempts, atomic.LoadInt32(requests), tt.attempts)
+		}
+		if (err == nil) != (tt.err == "") || (err != nil && !strings.Contains(err.Error(), tt.err)) {
+			t.Errorf("%s: got error %v, expected %q", tt.name, err, tt.err)
+		}
+	}
+}
+
+func TestDeliverBackoff(t *testing.T) {
+	var last time.Time
+	var gaps []time.Duration
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !last.IsZero() {
+			gaps = append(gaps, time.Since(last))
+		}
+		last = time.Now()
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	d := testDeliverer(server.URL, Retry{Attempts: 5, Backoff: 20 * time.Millisecond, MaxBackoff: 50 * time.Millisecond}, &deadLetter{})
+	if _, err := d.deliver(context.Background(), testMessage); err == nil {
+		t.Fatal("expected delivery to fail")
+	}
+	// 20ms doubling to 40ms, then held at max-backoff
+	for i, min := range []time.Duration{20, 40, 50, 50} {
+		if i >= len(gaps) {
+			t.Fatalf("expected 4 retries, got %d", len(gaps))
+		}
+		if gaps[i] < min*time.Millisecond || gaps[i] > (min+500)*time.Millisecond {
+			t.Errorf("retry %d came after %s, expected %dms", i+1, gaps[i], min)
+		}
+	}
+}
+
+func TestDeliverStopsWithContext(t *testing.T) {
+	server, _ := endpoint(t, "s3cret", 500)
+	d := testDeliverer(server.URL, Retry{Attempts: 10, Backoff: time.Hour}, &deadLetter{})
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if attempts, err := d.deliver(ctx, testMessage); attempts != 1 || err != context.DeadlineExceeded {
+		t.Errorf("got %d attempts and %v, expected to give up during the first backoff", attempts, err)
+	}
+}
+
+func readDeadLetters(t *testing.T, path string) []deadLetterRecord {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	records := []deadLetterRecord{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var r deadLetterRecord
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			t.Fatalf("dead letter line is not JSON: %v", err)
+		}
+		records = append(records, r)
+	}
+	return records
+}
+
+func TestDeadLetter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dead-letter.ndjson")
+	deadLetter, err := openDeadLetter(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server, _ := endpoint(t, "s3cret", 500)
+	d := testDeliverer(server.URL, Retry{Attempts: 2, Backoff: time.Millisecond}, deadLetter)
+	d.enqueue(testMessage)
+	// the queue holds one message, the next goes straight to the dead letter file
+	overflow := testMessage
+	overflow.Delivery = "d2"
+	d.enqueue(overflow)
+	// messages for accounts the endpoint isn't for are skipped, not dead lettered
+	d.endpoint.Accounts = []string{"+12024561414"}
+	d.enqueue(incomingMessage{Account: "+12024560000", Body: []byte(`{}`), Delivery: "d3"})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		d.run(ctx)
+		close(done)
+	}()
+	deadline := time.Now().Add(5 * time.Second)
+	for len(readDeadLetters(t, path)) < 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	<-done
+
+	records := readDeadLetters(t, path)
+	if len(records) != 2 {
+		t.Fatalf("expected 2 dead letters, got %+v", records)
+	}
+	tests := []struct {
+		delivery string
+		attempts int
+		err      string
+	}{
+		{"d2", 0, "delivery queue full"},
+		{"d1", 2, "500 Internal Server Error"},
+	}
+	for i, tt := range tests {
+		r := records[i]
+		if r.Delivery != tt.delivery || r.Attempts != tt.attempts || !strings.Contains(r.Error, tt.err) {
+			t.Errorf("record %d: got %s after %d attempts (%s), expected %s after %d (%s)", i, r.Delivery, r.Attempts, r.Error, tt.delivery, tt.attempts, tt.err)
+		}
+		if r.Endpoint != server.URL || r.Account != "+12024561414" || string(r.Message) != string(testMessage.Body) {
+			t.Errorf("record %d: got %+v", i, r)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"gitlab.com/signald/signald/internal/socket"
+)
+
+// maxSendRequestSize is generous for a message body, attachments are referenced by path rather than uploaded
+const maxSendRequestSize = 1 << 20
+
+// serveInbound accepts send requests over HTTP until ctx is done:
+//
+//	POST /v1/send    a v1 send request body, as documented in protocol.json, answered with signald's reply
+//	GET  /healthz    200 while connected to signald, 503 otherwise
+//
+// Requests must carry the token from inbound-token-env as "Authorization: Bearer <token>".
+func serveInbound(ctx context.Context, config *Config, s *session) error {
+	server := &http.Server{Addr: config.Listen, Handler: inboundHandler(config, s, os.Getenv(config.InboundTokenEnv))}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+	if err := server.ListenAndServe(); err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+func inboundHandler(config *Config, s *session, token string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/send", func(w http.ResponseWriter, r *http.Request) {
+		if !authorized(r, token) {
+			writeError(w, http.StatusUnauthorized, "Unauthorized", "missing or wrong bearer token")
+			return
+		}
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, "MethodNotAllowed", "use POST")
+			return
+		}
+		handleSend(w, r, config, s)
+	})
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		if s.current() == nil {
+			writeError(w, http.StatusServiceUnavailable, "NotConnected", "not connected to signald")
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+	return mux
+}
+
+func authorized(r *http.Request, token string) bool {
+	const prefix = "Bearer "
+	header := r.Header.Get("Authorization")
+	if token == "" || !strings.HasPrefix(header, prefix) {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(header[len(prefix):]), []byte(token)) == 1
+}
+
+func handleSend(w http.ResponseWriter, r *http.Request, config *Config, s *session) {
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxSendRequestSize))
+	if err != nil {
+		writeError(w, http.StatusRequestEntityTooLarge, "RequestTooLarge", err.Error())
+		return
+	}
+	var request map[string]interface{}
+	if err := json.Unmarshal(body, &request); err != nil {
+		writeError(w, http.StatusBadRequest, "InvalidRequest", "body must be a JSON object: "+err.Error())
+		return
+	}
+	account, _ := request["username"].(string)
+	if !stringInSlice(account, config.Accounts) {
+		writeError(w, http.StatusForbidden, "AccountNotConfigured", "username must be one of the accounts this bridge is configured for")
+		return
+	}
+	// the envelope fields are ours to set
+	delete(request, "id")
+	delete(request, "type")
+	delete(request, "version")
+
+	conn := s.current()
+	if conn == nil {
+		writeError(w, http.StatusServiceUnavailable, "NotConnected", "not connected to signald")
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), time.Minute)
+	defer cancel()
+	resp, err := conn.Request(ctx, "v1", "send", request)
+	var signaldErr *socket.Error
+	switch {
+	case errors.As(err, &signaldErr):
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadGateway)
+		json.NewEncoder(w).Encode(map[string]interface{}{"error_type": signaldErr.Type, "error": signaldErr.Raw})
+	case errors.Is(err, context.DeadlineExceeded):
+		writeError(w, http.StatusGatewayTimeout, "Timeout", "signald did not reply in time")
+	case err != nil:
+		writeError(w, http.StatusServiceUnavailable, "NotConnected", err.Error())
+	default:
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(resp.Data)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, errorType, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{"error_type": errorType, "error": map[string]string{"message": message}})
+}
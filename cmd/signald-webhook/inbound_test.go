@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gitlab.com/signald/signald/internal/socket"
+)
+
+// fakeSignald accepts one connection and answers each request with the reply handle returns for it
+func fakeSignald(t *testing.T, handle func(request map[string]interface{}) map[string]interface{}) *socket.Conn {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "signald.sock")
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		requests := bufio.NewScanner(conn)
+		for requests.Scan() {
+			var request map[string]interface{}
+			if err := json.Unmarshal(requests.Bytes(), &request); err != nil {
+				t.Errorf("request is not JSON: %v", err)
+				return
+			}
+			b, _ := json.Marshal(handle(request))
+			conn.Write(append(b, '\n'))
+		}
+	}()
+	conn, err := socket.Dial(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestInboundSend(t *testing.T) {
+	var sent map[string]interface{}
+	conn := fakeSignald(t, func(request map[string]interface{}) map[string]interface{} {
+		reply := map[string]interface{}{"id": request["id"], "type": request["type"]}
+		switch request["messageBody"] {
+		case "fail":
+			reply["error_type"] = "UnregisteredUserError"
+			reply["error"] = map[string]string{"message": "not registered"}
+		default:
+			sent = request
+			reply["data"] = map[string]interface{}{"timestamp": 1700000000000}
+		}
+		return reply
+	})
+
+	config := &Config{Accounts: []string{"+12024561414"}}
+	connected := &session{config: config, conn: conn}
+	disconnected := &session{config: config}
+
+	tests := []struct {
+		name          string
+		s             *session
+		method        string
+		authorization string
+		body          string
+		status        int
+		response      string
+	}{
+		{"no token", connected, "POST", "", `{}`, http.StatusUnauthorized, "Unauthorized"},
+		{"wrong token", connected, "POST", "Bearer nope", `{}`, http.StatusUnauthorized, "Unauthorized"},
+		{"token without bearer", connected, "POST", "t0ken", `{}`, http.StatusUnauthorized, "Unauthorized"},
+		{"wrong scheme", connected, "POST", "Basic t0ken", `{}`, http.StatusUnauthorized, "Unauthorized"},
+		{"get", connected, "GET", "Bearer t0ken", ``, http.StatusMethodNotAllowed, "MethodNotAllowed"},
+		{"not json", connected, "POST", "Bearer t0ken", `{"username":`, http.StatusBadRequest, "InvalidRequest"},
+		{"not an object", connected, "POST", "Bearer t0ken", `["+12024561414"]`, http.StatusBadRequest, "InvalidRequest"},
+		{"other account", connected, "POST", "Bearer t0ken", `{"username":"+12024560000"}`, http.StatusForbidden, "AccountNotConfigured"},
+		{"not connected", disconnected, "POST", "Bearer t0ken", `{"username":"+12024561414"}`, http.StatusServiceUnavailable, "NotConnected"},
+		{"signald error", connected, "POST", "Bearer t0ken", `{"username":"+12024561414","messageBody":"fail"}`, http.StatusBadGateway, `"error_type":"UnregisteredUserError"`},
+		{"sent", connected, "POST", "Bearer t0ken", `{"id":"mine","type":"mark_read","version":"v0","username":"+12024561414","messageBody":"hi"}`, http.StatusOK, `{"timestamp":1700000000000}`},
+	}
+	for _, tt := range tests {
+		server := httptest.NewServer(inboundHandler(config, tt.s, "t0ken"))
+		req, err := http.NewRequest(tt.method, server.URL+"/v1/send", strings.NewReader(tt.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tt.authorization != "" {
+			req.Header.Set("Authorization", tt.authorization)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		server.Close()
+
+		if resp.StatusCode != tt.status || !strings.Contains(string(body), tt.response) {
+			t.Errorf("%s: got %d %s, expected %d containing %s", tt.name, resp.StatusCode, string(body), tt.status, tt.response)
+		}
+	}
+
+	// the envelope is set by the bridge, not copied from the HTTP request
+	if sent["id"] == "mine" || sent["type"] != "send" || sent["version"] != "v1" || sent["messageBody"] != "hi" {
+		t.Errorf("got request %v", sent)
+	}
+}
+
+func TestInboundNeedsAToken(t *testing.T) {
+	server := httptest.NewServer(inboundHandler(&Config{Accounts: []string{"+12024561414"}}, &session{}, ""))
+	defer server.Close()
+	req, _ := http.NewRequest("POST", server.URL+"/v1/send", strings.NewReader(`{"username":"+12024561414"}`))
+	req.Header.Set("Authorization", "Bearer ")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("an empty token was accepted: got %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,274 @@
+// signald-webhook forwards incoming Signal messages to HTTP endpoints and accepts send requests over HTTP, for
+// consumers that can't keep a connection to the signald socket open.
+//
+//	signald-webhook -config /etc/signald-webhook.yaml
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"gitlab.com/signald/signald/internal/socket"
+)
+
+// Config is read from the file given with -config. Example:
+//
+//	socket: /var/run/signald/signald.sock
+//	accounts: ["+12024561414"]
+//	endpoints:
+//	  - url: https://hooks.example.com/signal
+//	    secret-env: SIGNAL_HOOK_SECRET
+//	retry:
+//	  attempts: 8
+//	  backoff: 1s
+//	  max-backoff: 5m
+//	dead-letter: /var/lib/signald-webhook/dead-letter.ndjson
+//	listen: 127.0.0.1:8081
+//	inbound-token-env: SIGNAL_WEBHOOK_TOKEN
+type Config struct {
+	Socket    string     `yaml:"socket"`
+	Accounts  []string   `yaml:"accounts"`
+	Types     []string   `yaml:"types"`
+	Endpoints []Endpoint `yaml:"endpoints"`
+	Retry     Retry      `yaml:"retry"`
+
+	// DeadLetter is a file that deliveries which ran out of retries are appended to
+	DeadLetter string `yaml:"dead-letter"`
+
+	// Listen is the address of the inbound HTTP server for send requests, which is not started if empty
+	Listen          string `yaml:"listen"`
+	InboundTokenEnv string `yaml:"inbound-token-env"`
+}
+
+// Endpoint is a URL that incoming messages are POSTed to
+type Endpoint struct {
+	URL string `yaml:"url"`
+
+	// SecretEnv names the environment variable holding the HMAC key for the X-Signald-Signature header. Secrets are
+	// not read from the config file itself so it can be checked in.
+	SecretEnv string `yaml:"secret-env"`
+
+	// Accounts limits the endpoint to messages for these accounts, all configured accounts if empty
+	Accounts []string `yaml:"accounts"`
+
+	// QueueSize is how many messages can wait for delivery before new ones go to the dead letter file
+	QueueSize int `yaml:"queue-size"`
+}
+
+// minBackoff is the shortest wait between delivery attempts retry.backoff can be set to
+const minBackoff = 100 * time.Millisecond
+
+type Retry struct {
+	Attempts   int           `yaml:"attempts"`
+	Backoff    time.Duration `yaml:"backoff"`
+	MaxBackoff time.Duration `yaml:"max-backoff"`
+}
+
+func loadConfig(path string) (*Config, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	config := &Config{
+		Socket: socket.DefaultPath,
+		Types:  []string{"message"},
+		Retry:  Retry{Attempts: 5, Backoff: time.Second, MaxBackoff: time.Minute},
+	}
+	if err := yaml.Unmarshal(b, config); err != nil {
+		return nil, fmt.Errorf("error parsing %s: %v", path, err)
+	}
+	if len(config.Accounts) == 0 {
+		return nil, fmt.Errorf("%s: no accounts configured", path)
+	}
+	if len(config.Endpoints) == 0 && config.Listen == "" {
+		return nil, fmt.Errorf("%s: nothing to do, configure endpoints, listen or both", path)
+	}
+	for i, e := range config.Endpoints {
+		if e.URL == "" {
+			return nil, fmt.Errorf("%s: endpoint %d has no url", path, i+1)
+		}
+		if e.SecretEnv != "" && os.Getenv(e.SecretEnv) == "" {
+			return nil, fmt.Errorf("%s: %s is not set, it holds the secret for %s", path, e.SecretEnv, e.URL)
+		}
+		if e.QueueSize <= 0 {
+			config.Endpoints[i].QueueSize = 1000
+		}
+	}
+	if config.Listen != "" && (config.InboundTokenEnv == "" || os.Getenv(config.InboundTokenEnv) == "") {
+		return nil, fmt.Errorf("%s: listen needs inbound-token-env naming a variable that holds the token clients send", path)
+	}
+	if config.Retry.Attempts < 1 {
+		config.Retry.Attempts = 1
+	}
+	// with no backoff every retry would hit a struggling endpoint immediately
+	if config.Retry.Backoff < minBackoff {
+		return nil, fmt.Errorf("%s: retry backoff must be at least %s", path, minBackoff)
+	}
+	return config, nil
+}
+
+func main() {
+	configPath := flag.String("config", "/etc/signald-webhook.yaml", "configuration file")
+	flag.Parse()
+
+	config, err := loadConfig(*configPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	deadLetter, err := openDeadLetter(config.DeadLetter)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		log.Println("shutting down")
+		cancel()
+	}()
+
+	s := &session{config: config}
+	deliverers := []*deliverer{}
+	for _, e := range config.Endpoints {
+		deliverers = append(deliverers, newDeliverer(e, config.Retry, deadLetter))
+	}
+
+	var wg sync.WaitGroup
+	for _, d := range deliverers {
+		wg.Add(1)
+		go func(d *deliverer) {
+			defer wg.Done()
+			d.run(ctx)
+		}(d)
+	}
+	if config.Listen != "" {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := serveInbound(ctx, config, s); err != nil {
+				log.Println("inbound server stopped:", err)
+				cancel()
+			}
+		}()
+	}
+
+	s.run(ctx, func(m incomingMessage) {
+		for _, d := range deliverers {
+			d.enqueue(m)
+		}
+	})
+	wg.Wait()
+}
+
+// session keeps a connection to signald open with every configured account subscribed, reconnecting when it drops
+type session struct {
+	config *Config
+
+	lock sync.Mutex
+	conn *socket.Conn
+}
+
+// current returns the open connection, or nil while reconnecting
+func (s *session) current() *socket.Conn {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.conn
+}
+
+func (s *session) run(ctx context.Context, handle func(incomingMessage)) {
+	backoff := time.Second
+	for ctx.Err() == nil {
+		started := time.Now()
+		err := s.listen(ctx, handle)
+		if ctx.Err() != nil {
+			return
+		}
+		if time.Since(started) > time.Minute {
+			backoff = time.Second
+		}
+		log.Printf("lost connection to signald (%v), reconnecting in %s", err, backoff)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return
+		}
+		if backoff < time.Minute {
+			backoff *= 2
+		}
+	}
+}
+
+func (s *session) listen(ctx context.Context, handle func(incomingMessage)) error {
+	conn, err := socket.Dial(s.config.Socket)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	// done stops the watcher when listen returns, otherwise every reconnect would leave one behind
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	incoming, stop := conn.Listen()
+	defer stop()
+	for _, account := range s.config.Accounts {
+		subscribeCtx, cancel := context.WithTimeout(ctx, time.Minute)
+		_, err := conn.Request(subscribeCtx, "v0", "subscribe", map[string]string{"username": account})
+		cancel()
+		if err != nil {
+			return fmt.Errorf("error subscribing %s: %v", account, err)
+		}
+	}
+	log.Printf("connected to %s, subscribed %s", s.config.Socket, strings.Join(s.config.Accounts, ", "))
+
+	s.lock.Lock()
+	s.conn = conn
+	s.lock.Unlock()
+	defer func() {
+		s.lock.Lock()
+		s.conn = nil
+		s.lock.Unlock()
+	}()
+
+	for r := range incoming {
+		if !stringInSlice(r.Type, s.config.Types) {
+			continue
+		}
+		m, err := newIncomingMessage(r)
+		if err != nil {
+			log.Println("error decoding incoming message:", err)
+			continue
+		}
+		handle(m)
+	}
+	return socket.ErrClosed
+}
+
+func stringInSlice(s string, list []string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadConfig(t *testing.T) {
+	tests := []struct {
+		name, doc, err string
+		retry          Retry
+	}{
+		{"defaults", "accounts: [a]\nendpoints: [{url: http://x}]", "", Retry{Attempts: 5, Backoff: time.Second, MaxBackoff: time.Minute}},
+		{"attempts clamped", "accounts: [a]\nendpoints: [{url: http://x}]\nretry: {attempts: 0}", "", Retry{Attempts: 1, Backoff: time.Second, MaxBackoff: time.Minute}},
+		{"no backoff", "accounts: [a]\nendpoints: [{url: http://x}]\nretry: {backoff: 0s}", "retry backoff must be at least", Retry{}},
+		{"backoff too short", "accounts: [a]\nendpoints: [{url: http://x}]\nretry: {backoff: 1ms}", "retry backoff must be at least", Retry{}},
+		{"no accounts", "endpoints: [{url: http://x}]", "no accounts", Retry{}},
+		{"nothing to do", "accounts: [a]", "nothing to do", Retry{}},
+		{"listen without token", "accounts: [a]\nlisten: 127.0.0.1:0", "inbound-token-env", Retry{}},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(t.TempDir(), "config.yaml")
+		if err := ioutil.WriteFile(path, []byte(tt.doc), 0644); err != nil {
+			t.Fatal(err)
+		}
+		config, err := loadConfig(path)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s: got error %v, expected %q", tt.name, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if config.Retry != tt.retry {
+			t.Errorf("%s: got retry %+v, expected %+v", tt.name, config.Retry, tt.retry)
+		}
+	}
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/spf13/cobra"
+)
+
+var accountCmd = &cobra.Command{
+	Use:   "account",
+	Short: "register, link and manage local accounts",
+}
+
+var (
+	registerVoice   bool
+	registerCaptcha string
+	linkDeviceName  string
+	linkQR          string
+	deleteServer    bool
+	deleteYes       bool
+)
+
+var accountRegisterCmd = &cobra.Command{
+	Use:   "register <phone number>",
+	Short: "begin registering a new account",
+	Long: `begin registering a new account. Signal will send a verification code by SMS (or voice call with --voice),
+which must then be submitted with "signaldctl account verify".
+
+Signal usually requires a captcha token to register, see https://signald.org/articles/captcha/`,
+	Args: cobra.ExactArgs(1),
+	PreRunE: func(_ *cobra.Command, _ []string) error {
+		return checkOutputFormat(outputTable, outputJSON)
+	},
+	RunE: func(_ *cobra.Command, args []string) error {
+		conn, ctx, cancel, err := connect()
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		defer cancel()
+
+		req := RegisterRequest{Account: args[0], Voice: registerVoice, Captcha: registerCaptcha}
+		var info Account
+		if err := conn.RequestInto(ctx, "v1", "register", req, &info); err != nil {
+			return err
+		}
+
+		if outputFormat == outputJSON {
+			return printJSON(info)
+		}
+		fmt.Println("verification code requested, submit it with: signaldctl account verify", args[0], "<code>")
+		return nil
+	},
+}
+
+var accountVerifyCmd = &cobra.Command{
+	Use:   "verify <phone number> <code>",
+	Short: "complete registration with the verification code",
+	Args:  cobra.ExactArgs(2),
+	PreRunE: func(_ *cobra.Command, _ []string) error {
+		return checkOutputFormat(outputTable, outputJSON)
+	},
+	RunE: func(_ *cobra.Command, args []string) error {
+		conn, ctx, cancel, err := connect()
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		defer cancel()
+
+		var info Account
+		if err := conn.RequestInto(ctx, "v1", "verify", VerifyRequest{Account: args[0], Code: args[1]}, &info); err != nil {
+			return err
+		}
+		return printAccounts([]Account{info})
+	},
+}
+
+var accountLinkCmd = &cobra.Command{
+	Use:   "link",
+	Short: "link signald to an existing Signal account as a secondary device",
+	Long: `link signald to an existing Signal account as a secondary device. The linking URI must be scanned
+from the primary device under Settings -> Linked Devices.
+
+When stderr is a terminal the URI is also drawn there as a QR code, using unicode blocks if the locale is UTF-8
+and plain ASCII otherwise. ASCII codes only scan on a light terminal background. Use --qr to pick a mode.`,
+	Args: cobra.NoArgs,
+	PreRunE: func(_ *cobra.Command, _ []string) error {
+		return checkOutputFormat(outputTable, outputJSON)
+	},
+	RunE: func(_ *cobra.Command, _ []string) error {
+		conn, ctx, cancel, err := connect()
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		defer cancel()
+
+		var uri LinkingURI
+		if err := conn.RequestInto(ctx, "v1", "generate_linking_uri", nil, &uri); err != nil {
+			return err
+		}
+
+		if outputFormat == outputJSON {
+			if err := printJSON(uri); err != nil {
+				return err
+			}
+		} else {
+			fmt.Fprintln(os.Stderr, "scan this linking URI from the primary device:")
+			fmt.Println(uri.URI)
+		}
+		if err := printQR(os.Stderr, uri.URI, linkQR); err != nil {
+			return err
+		}
+
+		// finish_link returns once the primary device has scanned the URI, signald enforces its own timeout
+		var info Account
+		req := FinishLinkRequest{DeviceName: linkDeviceName, SessionID: uri.SessionID}
+		if err := conn.RequestInto(context.Background(), "v1", "finish_link", req, &info); err != nil {
+			return err
+		}
+		return printAccounts([]Account{info})
+	},
+}
+
+var accountListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "list the accounts on this signald instance",
+	Args:  cobra.NoArgs,
+	PreRunE: func(_ *cobra.Command, _ []string) error {
+		return checkOutputFormat(outputTable, outputJSON)
+	},
+	RunE: func(_ *cobra.Command, _ []string) error {
+		conn, ctx, cancel, err := connect()
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		defer cancel()
+
+		var list AccountList
+		if err := conn.RequestInto(ctx, "v1", "list_accounts", nil, &list); err != nil {
+			return err
+		}
+		return printAccounts(list.Accounts)
+	},
+}
+
+var accountDeleteCmd = &cobra.Command{
+	Use:   "delete <phone number>",
+	Short: "delete all local data for an account",
+	Long: `delete all data signald has on disk for an account, and with --server delete the account from the Signal server too.
+Note that this is not "unlink": it deletes the entire account, even from a linked device.`,
+	Args:              cobra.ExactArgs(1),
+	ValidArgsFunction: firstArg(completeAccounts),
+	RunE: func(_ *cobra.Command, args []string) error {
+		if !deleteYes {
+			question := "delete all local data for " + args[0] + "?"
+			if deleteServer {
+				question = "delete " + args[0] + " locally and from the Signal server?"
+			}
+			ok, err := confirm(question)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return errAborted
+			}
+		}
+
+		conn, ctx, cancel, err := connect()
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		defer cancel()
+
+		if err := conn.RequestInto(ctx, "v1", "delete_account", DeleteAccountRequest{Account: args[0], Server: deleteServer}, nil); err != nil {
+			return err
+		}
+		fmt.Println("deleted", args[0])
+		return nil
+	},
+}
+
+func printAccounts(accounts []Account) error {
+	if outputFormat == outputJSON {
+		return printJSON(accounts)
+	}
+	rows := [][]string{}
+	for _, a := range accounts {
+		uuid := ""
+		if a.Address != nil {
+			uuid = a.Address.UUID
+		}
+		rows = append(rows, []string{a.AccountID, uuid, strconv.Itoa(a.DeviceID)})
+	}
+	return printTable([]string{"ACCOUNT", "UUID", "DEVICE ID"}, rows)
+}
+
+func init() {
+	accountRegisterCmd.Flags().BoolVar(&registerVoice, "voice", false, "request a voice call instead of an SMS")
+	accountRegisterCmd.Flags().StringVar(&registerCaptcha, "captcha", "", "captcha token, see https://signald.org/articles/captcha/")
+	accountLinkCmd.Flags().StringVarP(&linkDeviceName, "device-name", "n", "signald", "name this device will appear as on the primary device")
+	accountLinkCmd.Flags().StringVar(&linkQR, "qr", qrAuto, "how to draw the QR code: auto, unicode, ascii or none")
+	accountDeleteCmd.Flags().BoolVar(&deleteServer, "server", false, "also delete the account from the Signal server")
+	accountDeleteCmd.Flags().BoolVarP(&deleteYes, "yes", "y", false, "do not ask for confirmation")
+
+	accountCmd.AddCommand(accountRegisterCmd, accountVerifyCmd, accountLinkCmd, accountListCmd, accountDeleteCmd)
+	rootCmd.AddCommand(accountCmd)
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var uuidRegex = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// parseAddress accepts either an e164 phone number or a UUID
+func parseAddress(s string) (*JsonAddress, error) {
+	s = strings.TrimSpace(s)
+	if strings.HasPrefix(s, "+") {
+		return &JsonAddress{Number: s}, nil
+	}
+	if uuidRegex.MatchString(s) {
+		return &JsonAddress{UUID: strings.ToLower(s)}, nil
+	}
+	return nil, fmt.Errorf("%q is neither an e164 phone number (starting with +) nor a UUID", s)
+}
+
+// sameAddress compares by UUID or number, whichever a has. Incoming messages don't always carry both.
+func sameAddress(a, b *JsonAddress) bool {
+	sameUUID := a.UUID != "" && strings.EqualFold(a.UUID, b.UUID)
+	sameNumber := a.Number != "" && a.Number == b.Number
+	return sameUUID || sameNumber
+}
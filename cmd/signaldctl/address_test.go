@@ -0,0 +1,54 @@
+package main
+
+import "testing"
+
+func TestParseAddress(t *testing.T) {
+	tests := []struct {
+		input string
+		want  *JsonAddress
+	}{
+		{"+12024561414", &JsonAddress{Number: "+12024561414"}},
+		{" +12024561414\n", &JsonAddress{Number: "+12024561414"}},
+		{"AAAAAAAA-1111-2222-3333-444444444444", &JsonAddress{UUID: "aaaaaaaa-1111-2222-3333-444444444444"}},
+		{"12024561414", nil},
+		{"aaaaaaaa-1111-2222-3333", nil},
+		{"", nil},
+	}
+	for _, test := range tests {
+		got, err := parseAddress(test.input)
+		if test.want == nil {
+			if err == nil {
+				t.Errorf("parseAddress(%q) = %v, expected an error", test.input, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseAddress(%q): %v", test.input, err)
+			continue
+		}
+		if *got != *test.want {
+			t.Errorf("parseAddress(%q) = %v, expected %v", test.input, got, test.want)
+		}
+	}
+}
+
+func TestSameAddress(t *testing.T) {
+	full := &JsonAddress{Number: "+12024561414", UUID: "aaaaaaaa-1111-2222-3333-444444444444"}
+	tests := []struct {
+		name string
+		a    *JsonAddress
+		b    *JsonAddress
+		want bool
+	}{
+		{"same number", &JsonAddress{Number: "+12024561414"}, full, true},
+		{"same UUID in another case", &JsonAddress{UUID: "AAAAAAAA-1111-2222-3333-444444444444"}, full, true},
+		{"other number", &JsonAddress{Number: "+12024561111"}, full, false},
+		{"number against UUID only", &JsonAddress{Number: "+12024561414"}, &JsonAddress{UUID: full.UUID}, false},
+		{"empty", &JsonAddress{}, &JsonAddress{}, false},
+	}
+	for _, test := range tests {
+		if got := sameAddress(test.a, test.b); got != test.want {
+			t.Errorf("%s: sameAddress(%v, %v) = %v", test.name, test.a, test.b, got)
+		}
+	}
+}
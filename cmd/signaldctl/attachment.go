@@ -0,0 +1,373 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+var attachmentCmd = &cobra.Command{
+	Use:   "attachment",
+	Short: "send and retrieve attachments",
+}
+
+var (
+	attachmentTo          string
+	attachmentGroup       string
+	attachmentStdin       bool
+	attachmentFilename    string
+	attachmentContentType string
+	attachmentTempDir     string
+)
+
+var attachmentSendCmd = &cobra.Command{
+	Use:   "send [flags] <file> [caption]",
+	Short: "send a file to a user or group",
+	Long: `send a file to a user or group.
+
+signald reads the file itself, so it must be readable by the signald process. With --stdin the data is
+copied to a world readable temporary file in --temp-dir, which is removed once signald has sent it.
+When signald runs as a different user, --temp-dir must be a directory that user can reach.`,
+	Example: `  signaldctl attachment send --account +12024561414 --to +12024561111 ./report.pdf "monthly report"
+  pg_dump mydb | gzip | signaldctl attachment send --account +12024561414 --group EdSqI90cS0UomDpgUXOlCoObWvQOXlH5G3Z2d3f4ayE= --stdin --filename mydb.sql.gz`,
+	Args: func(_ *cobra.Command, args []string) error {
+		if attachmentStdin {
+			return cobra.MaximumNArgs(1)(nil, args)
+		}
+		return cobra.RangeArgs(1, 2)(nil, args)
+	},
+	PreRunE: func(_ *cobra.Command, _ []string) error {
+		if err := requireAccount(); err != nil {
+			return err
+		}
+		return checkOutputFormat(outputTable, outputJSON)
+	},
+	RunE: func(_ *cobra.Command, args []string) error {
+		req := SendRequest{Username: account}
+
+		switch {
+		case attachmentTo != "" && attachmentGroup != "":
+			return errors.New("--to and --group cannot be used together")
+		case attachmentTo != "":
+			address, err := parseAddress(attachmentTo)
+			if err != nil {
+				return err
+			}
+			req.RecipientAddress = address
+		case attachmentGroup != "":
+			req.RecipientGroupID = attachmentGroup
+		default:
+			return errors.New("one of --to or --group is required")
+		}
+
+		attachment := JsonAttachment{CustomFilename: attachmentFilename, ContentType: attachmentContentType}
+		if attachmentStdin {
+			path, err := copyStdinToTempFile()
+			if err != nil {
+				return err
+			}
+			defer os.Remove(path)
+			attachment.Filename = path
+			if attachment.ContentType == "" {
+				// signald guesses the type from the file extension, which a temporary file doesn't have
+				if attachment.ContentType, err = sniffContentType(path); err != nil {
+					return err
+				}
+			}
+		} else {
+			path, err := filepath.Abs(args[0])
+			if err != nil {
+				return err
+			}
+			if _, err := os.Stat(path); err != nil {
+				return err
+			}
+			attachment.Filename = path
+			args = args[1:]
+		}
+		if len(args) > 0 {
+			attachment.Caption = args[0]
+		}
+		req.Attachments = []JsonAttachment{attachment}
+
+		conn, ctx, cancel, err := connect()
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		defer cancel()
+
+		var resp SendResponse
+		if err := conn.RequestInto(ctx, "v1", "send", req, &resp); err != nil {
+			return err
+		}
+
+		if outputFormat == outputJSON {
+			return printJSON(resp)
+		}
+
+		timestamp := strconv.FormatInt(resp.Timestamp, 10)
+		rows := [][]string{}
+		for _, result := range resp.Results {
+			rows = append(rows, []string{result.Address.String(), result.Status(), timestamp})
+		}
+		return printTable([]string{"RECIPIENT", "RESULT", "TIMESTAMP"}, rows)
+	},
+}
+
+// copyStdinToTempFile saves stdin to a file that signald can read, returning its path
+func copyStdinToTempFile() (string, error) {
+	pattern := "signaldctl-*"
+	if ext := filepath.Ext(attachmentFilename); ext != "" {
+		pattern += ext
+	}
+	f, err := ioutil.TempFile(attachmentTempDir, pattern)
+	if err != nil {
+		return "", err
+	}
+	path, err := filepath.Abs(f.Name())
+	if err == nil {
+		_, err = io.Copy(f, os.Stdin)
+	}
+	if err == nil {
+		// TempFile creates the file 0600, which a signald running as another user can't open
+		err = f.Chmod(0644)
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return path, nil
+}
+
+func sniffContentType(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	head := make([]byte, 512)
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", err
+	}
+	return http.DetectContentType(head[:n]), nil
+}
+
+var (
+	attachmentDataDir  string
+	attachmentMessages string
+	attachmentIndex    int
+	attachmentID       string
+	attachmentOut      string
+	attachmentStdout   bool
+)
+
+var attachmentFetchCmd = &cobra.Command{
+	Use:   "fetch [flags] <sender> <timestamp>",
+	Short: "copy the attachments of a received message out of signald's data directory",
+	Long: `copy the attachments of a received message out of signald's data directory.
+
+A message is referred to by its sender and timestamp. signald does not keep messages after delivering them,
+so they are looked up in --messages: a file of envelopes as printed by "signaldctl tail -o json", or - for
+stdin. Sync messages for messages sent from another device are found by the account's own address. Every
+attachment of the message is saved, named after the file name the sender gave or the attachment ID;
+--index picks one.
+
+With --id the attachment is named directly by its ID or stored filename instead, and no messages are needed.
+
+signald downloads incoming attachments to the attachments folder of its data directory. It creates that
+directory readable only by the user it runs as, so this command usually has to run as that user too.`,
+	Example: `  signaldctl tail -a +12024561414 -o json >> messages.json
+  signaldctl attachment fetch --messages messages.json +12024561111 1600000000000
+  signaldctl attachment fetch --messages messages.json --index 0 --stdout +12024561111 1600000000000 | file -
+  signaldctl attachment fetch --id 7308218493102938711 --out cat.jpg`,
+	Args: func(_ *cobra.Command, args []string) error {
+		if attachmentID != "" {
+			return cobra.NoArgs(nil, args)
+		}
+		return cobra.ExactArgs(2)(nil, args)
+	},
+	PreRunE: func(_ *cobra.Command, _ []string) error {
+		if attachmentStdout && attachmentOut != "" {
+			return errors.New("--out and --stdout cannot be used together")
+		}
+		if attachmentID == "" && attachmentMessages == "" {
+			return errors.New("--messages is required to look up a message, or use --id")
+		}
+		return nil
+	},
+	RunE: func(_ *cobra.Command, args []string) error {
+		var attachments []JsonAttachment
+		if attachmentID != "" {
+			attachments = []JsonAttachment{{ID: attachmentID}}
+			if strings.ContainsRune(attachmentID, os.PathSeparator) {
+				attachments[0] = JsonAttachment{StoredFilename: attachmentID}
+			}
+		} else {
+			sender, err := parseAddress(args[0])
+			if err != nil {
+				return err
+			}
+			timestamp, err := strconv.ParseInt(args[1], 10, 64)
+			if err != nil {
+				return fmt.Errorf("invalid timestamp %q, expected milliseconds since the epoch", args[1])
+			}
+			message, err := findMessage(attachmentMessages, sender, timestamp)
+			if err != nil {
+				return err
+			}
+			attachments = message.Attachments
+			if len(attachments) == 0 {
+				return errors.New("the message has no attachments")
+			}
+		}
+
+		if attachmentIndex >= 0 {
+			if attachmentIndex >= len(attachments) {
+				return fmt.Errorf("--index %d is out of range, the message has %d attachment(s)", attachmentIndex, len(attachments))
+			}
+			attachments = attachments[attachmentIndex : attachmentIndex+1]
+		}
+		if (attachmentStdout || attachmentOut != "") && len(attachments) > 1 {
+			return fmt.Errorf("the message has %d attachments, use --index to pick one for --out or --stdout", len(attachments))
+		}
+
+		for _, a := range attachments {
+			if err := fetchAttachment(a); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+}
+
+// findMessage reads envelopes from path, as printed by tail -o json, and returns the message sent by sender at
+// timestamp
+func findMessage(path string, sender *JsonAddress, timestamp int64) (*JsonDataMessage, error) {
+	in := os.Stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		in = f
+	}
+
+	decoder := json.NewDecoder(in)
+	for {
+		var env JsonMessageEnvelope
+		if err := decoder.Decode(&env); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("error reading %s: %v", path, err)
+		}
+		message := env.DataMessage
+		if env.SyncMessage != nil && env.SyncMessage.Sent != nil {
+			message = env.SyncMessage.Sent.Message
+		}
+		if message == nil || env.Source == nil || !sameAddress(sender, env.Source) {
+			continue
+		}
+		if message.Timestamp == timestamp || env.Timestamp == timestamp {
+			return message, nil
+		}
+	}
+	return nil, fmt.Errorf("no message from %s at %d in %s", sender, timestamp, path)
+}
+
+// attachmentPath is where signald stored an attachment. storedFilename is only used if it exists, it's a path on
+// signald's side and differs when signald runs in a container.
+func attachmentPath(a JsonAttachment) string {
+	if a.StoredFilename != "" {
+		if _, err := os.Stat(a.StoredFilename); err == nil {
+			return a.StoredFilename
+		}
+		return filepath.Join(attachmentDataDir, "attachments", filepath.Base(a.StoredFilename))
+	}
+	return filepath.Join(attachmentDataDir, "attachments", a.ID)
+}
+
+func fetchAttachment(a JsonAttachment) error {
+	source := attachmentPath(a)
+	in, err := os.Open(source)
+	if err != nil {
+		if os.IsPermission(err) {
+			return fmt.Errorf("%v (signald's data directory is private to the user signald runs as, try running signaldctl as that user)", err)
+		}
+		if os.IsNotExist(err) {
+			return fmt.Errorf("%v (attachments are only stored once signald has downloaded them, check --data-dir)", err)
+		}
+		return err
+	}
+	defer in.Close()
+
+	if attachmentStdout {
+		_, err = io.Copy(os.Stdout, in)
+		return err
+	}
+
+	dest := attachmentOut
+	if dest == "" {
+		// the sender picks the file name, so only its last element is trusted
+		dest = filepath.Base(a.CustomFilename)
+		if a.CustomFilename == "" || dest == "." || dest == ".." || dest == string(os.PathSeparator) {
+			dest = filepath.Base(source)
+		}
+	}
+	out, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	fmt.Fprintln(os.Stderr, "saved", dest)
+	return nil
+}
+
+func defaultDataDir() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".config", "signald")
+}
+
+func init() {
+	addAccountFlag(attachmentSendCmd)
+	attachmentSendCmd.Flags().StringVarP(&attachmentTo, "to", "t", "", "phone number or UUID to send to")
+	attachmentSendCmd.Flags().StringVarP(&attachmentGroup, "group", "g", "", "group ID to send to")
+	attachmentSendCmd.RegisterFlagCompletionFunc("group", completeGroups)
+	attachmentSendCmd.Flags().BoolVar(&attachmentStdin, "stdin", false, "read the attachment from stdin instead of a file")
+	attachmentSendCmd.Flags().StringVar(&attachmentFilename, "filename", "", "file name shown to recipients (defaults to the name of the file)")
+	attachmentSendCmd.Flags().StringVar(&attachmentContentType, "content-type", "", "MIME type of the attachment (detected if not set)")
+	attachmentSendCmd.Flags().StringVar(&attachmentTempDir, "temp-dir", os.TempDir(), "where to stage data read with --stdin")
+
+	attachmentFetchCmd.Flags().StringVarP(&attachmentDataDir, "data-dir", "d", defaultDataDir(), "signald's data directory (signald's --data option)")
+	attachmentFetchCmd.Flags().StringVarP(&attachmentMessages, "messages", "m", "", "file of envelopes from tail -o json to look the message up in, - for stdin")
+	attachmentFetchCmd.Flags().IntVarP(&attachmentIndex, "index", "i", -1, "only fetch the attachment at this position in the message, counting from 0")
+	attachmentFetchCmd.Flags().StringVar(&attachmentID, "id", "", "fetch the attachment with this ID or stored filename instead of looking up a message")
+	attachmentFetchCmd.Flags().StringVarP(&attachmentOut, "out", "O", "", "file to write (defaults to the attachment's file name in the current directory)")
+	attachmentFetchCmd.Flags().BoolVar(&attachmentStdout, "stdout", false, "write the attachment to stdout")
+
+	attachmentCmd.AddCommand(attachmentSendCmd, attachmentFetchCmd)
+	rootCmd.AddCommand(attachmentCmd)
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"gitlab.com/signald/signald/internal/socket"
+)
+
+// completions query the running signald, but should never hang the shell
+const completionTimeout = 2 * time.Second
+
+func completionRequest(cmd *cobra.Command, requestType string, payload interface{}, out interface{}) error {
+	// the pre-run hook only ran for cobra's __complete command, apply the config for the command being completed
+	if err := applyConfig(cmd); err != nil {
+		return err
+	}
+	conn, err := socket.Dial(socketPath)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), completionTimeout)
+	defer cancel()
+	return conn.RequestInto(ctx, "v1", requestType, payload, out)
+}
+
+// completeAccounts suggests the accounts on the running signald instance
+func completeAccounts(cmd *cobra.Command, _ []string, _ string) ([]string, cobra.ShellCompDirective) {
+	var list AccountList
+	if err := completionRequest(cmd, "list_accounts", nil, &list); err != nil {
+		return nil, cobra.ShellCompDirectiveNoFileComp | cobra.ShellCompDirectiveError
+	}
+	suggestions := []string{}
+	for _, a := range list.Accounts {
+		description := ""
+		if a.Address != nil {
+			description = a.Address.UUID
+		}
+		suggestions = append(suggestions, a.AccountID+"\t"+description)
+	}
+	return suggestions, cobra.ShellCompDirectiveNoFileComp
+}
+
+// completeGroups suggests group IDs, described by their titles. It needs --account to already be on the command line.
+func completeGroups(cmd *cobra.Command, _ []string, _ string) ([]string, cobra.ShellCompDirective) {
+	if err := applyConfig(cmd); err != nil {
+		return nil, cobra.ShellCompDirectiveNoFileComp | cobra.ShellCompDirectiveError
+	}
+	if account == "" {
+		return cobra.AppendActiveHelp(nil, "set --account first to complete group IDs"), cobra.ShellCompDirectiveNoFileComp
+	}
+	var list GroupList
+	if err := completionRequest(cmd, "list_groups", ListGroupsRequest{Account: account}, &list); err != nil {
+		return nil, cobra.ShellCompDirectiveNoFileComp | cobra.ShellCompDirectiveError
+	}
+	suggestions := []string{}
+	for _, g := range list.Groups {
+		suggestions = append(suggestions, g.ID+"\t"+g.Title)
+	}
+	for _, g := range list.LegacyGroups {
+		suggestions = append(suggestions, g.GroupID+"\t"+g.Name)
+	}
+	return suggestions, cobra.ShellCompDirectiveNoFileComp
+}
+
+// firstArg restricts a completion function to the first positional argument
+func firstArg(complete func(*cobra.Command, []string, string) ([]string, cobra.ShellCompDirective)) func(*cobra.Command, []string, string) ([]string, cobra.ShellCompDirective) {
+	return func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		if len(args) > 0 {
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		}
+		return complete(cmd, args, toComplete)
+	}
+}
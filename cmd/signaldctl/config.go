@@ -0,0 +1,130 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the signaldctl configuration file. Example:
+//
+//	default-profile: prod
+//	profiles:
+//	  prod:
+//	    socket: /var/run/signald/signald.sock
+//	    account: "+12024561414"
+//	  staging:
+//	    socket: /srv/signald-staging/signald.sock
+//	    output: json
+type Config struct {
+	DefaultProfile string                   `yaml:"default-profile"`
+	Profiles       map[string]ConfigProfile `yaml:"profiles"`
+}
+
+// ConfigProfile holds defaults for one signald instance. Flags given on the command line always win.
+type ConfigProfile struct {
+	Socket  string `yaml:"socket"`
+	Account string `yaml:"account"`
+	Output  string `yaml:"output"`
+}
+
+var (
+	configPath  string
+	profileName string
+)
+
+func defaultConfigPath() string {
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		dir = filepath.Join(home, ".config")
+	}
+	return filepath.Join(dir, "signaldctl.yaml")
+}
+
+func loadConfig(path string) (Config, error) {
+	var config Config
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return config, err
+	}
+	if err := yaml.Unmarshal(b, &config); err != nil {
+		return config, fmt.Errorf("error parsing %s: %v", path, err)
+	}
+	return config, nil
+}
+
+// applyConfig fills in anything not given on the command line (or, for the socket, in SIGNALD_SOCKET) from the selected profile.
+// It is safe to call more than once, which completion relies on since cobra runs the pre-run hook for its own __complete command.
+func applyConfig(cmd *cobra.Command) error {
+	config, err := loadConfig(configPath)
+	if err != nil {
+		// a missing config file is only a problem if the user asked for it or for a profile in it
+		if errors.Is(err, os.ErrNotExist) && !cmd.Flags().Changed("config") && profileName == "" {
+			return nil
+		}
+		return err
+	}
+
+	name := profileName
+	if name == "" {
+		name = os.Getenv("SIGNALDCTL_PROFILE")
+	}
+	if name == "" {
+		name = config.DefaultProfile
+	}
+	if name == "" {
+		return nil
+	}
+	profile, ok := config.Profiles[name]
+	if !ok {
+		names := []string{}
+		for n := range config.Profiles {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return fmt.Errorf("no profile named %q in %s (available: %s)", name, configPath, strings.Join(names, ", "))
+	}
+
+	if profile.Socket != "" && !cmd.Flags().Changed("socket") && os.Getenv("SIGNALD_SOCKET") == "" {
+		socketPath = profile.Socket
+	}
+	if profile.Output != "" && !cmd.Flags().Changed("output") {
+		outputFormat = profile.Output
+	}
+	if profile.Account != "" && cmd.Flags().Lookup("account") != nil && !cmd.Flags().Changed("account") {
+		account = profile.Account
+	}
+	return nil
+}
+
+func init() {
+	rootCmd.PersistentFlags().StringVar(&configPath, "config", defaultConfigPath(), "path to the signaldctl config file")
+	rootCmd.PersistentFlags().StringVarP(&profileName, "profile", "p", "", "profile from the config file to use (env SIGNALDCTL_PROFILE)")
+	rootCmd.RegisterFlagCompletionFunc("profile", completeProfiles)
+	rootCmd.PersistentPreRunE = func(cmd *cobra.Command, _ []string) error {
+		return applyConfig(cmd)
+	}
+}
+
+func completeProfiles(_ *cobra.Command, _ []string, _ string) ([]string, cobra.ShellCompDirective) {
+	config, err := loadConfig(configPath)
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	names := []string{}
+	for name, profile := range config.Profiles {
+		names = append(names, name+"\t"+profile.Socket)
+	}
+	return names, cobra.ShellCompDirectiveNoFileComp
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+var contactCmd = &cobra.Command{
+	Use:   "contact",
+	Short: "list contacts",
+}
+
+var (
+	contactListAsync  bool
+	contactListSearch string
+)
+
+var contactListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "list an account's contacts and their profiles",
+	Args:  cobra.NoArgs,
+	PreRunE: func(_ *cobra.Command, _ []string) error {
+		if err := requireAccount(); err != nil {
+			return err
+		}
+		return checkOutputFormat(outputTable, outputJSON, outputCSV)
+	},
+	RunE: func(_ *cobra.Command, _ []string) error {
+		conn, ctx, cancel, err := connect()
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		defer cancel()
+
+		var list ProfileList
+		if err := conn.RequestInto(ctx, "v1", "list_contacts", ListContactsRequest{Account: account, Async: contactListAsync}, &list); err != nil {
+			return err
+		}
+
+		profiles := []Profile{}
+		search := strings.ToLower(contactListSearch)
+		for _, p := range list.Profiles {
+			if search != "" && !profileMatches(p, search) {
+				continue
+			}
+			profiles = append(profiles, p)
+		}
+
+		if outputFormat == outputJSON {
+			return printJSON(profiles)
+		}
+
+		rows := [][]string{}
+		for _, p := range profiles {
+			address := JsonAddress{}
+			if p.Address != nil {
+				address = *p.Address
+			}
+			rows = append(rows, []string{p.Name, p.ProfileName, address.Number, address.UUID})
+		}
+		return printRows([]string{"NAME", "PROFILE NAME", "NUMBER", "UUID"}, rows)
+	},
+}
+
+// profileMatches does a case insensitive substring match of search against the profile's names and address
+func profileMatches(p Profile, search string) bool {
+	candidates := []string{p.Name, p.ProfileName}
+	if p.Address != nil {
+		candidates = append(candidates, p.Address.Number, p.Address.UUID)
+	}
+	for _, c := range candidates {
+		if strings.Contains(strings.ToLower(c), search) {
+			return true
+		}
+	}
+	return false
+}
+
+func init() {
+	addAccountFlag(contactListCmd)
+	contactListCmd.Flags().BoolVar(&contactListAsync, "async", false, "return cached profiles immediately instead of waiting for them to be refreshed")
+	contactListCmd.Flags().StringVar(&contactListSearch, "search", "", "only show contacts whose name, profile name, number or UUID contains this")
+
+	contactCmd.AddCommand(contactListCmd)
+	rootCmd.AddCommand(contactCmd)
+}
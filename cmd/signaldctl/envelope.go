@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"gitlab.com/signald/signald/internal/socket"
+)
+
+// subscribe starts delivery of incoming messages for account on conn. subscribe is still a v0-only request.
+func subscribe(ctx context.Context, conn *socket.Conn, account string) error {
+	_, err := conn.Request(ctx, "v0", "subscribe", SubscribeRequest{Username: account})
+	return err
+}
+
+// envelopeKind names what an envelope carries: data, sync, receipt, typing, call or other
+func envelopeKind(env JsonMessageEnvelope) string {
+	switch {
+	case env.DataMessage != nil:
+		return "data"
+	case env.SyncMessage != nil:
+		return "sync"
+	case env.Receipt != nil:
+		return "receipt"
+	case env.Typing != nil:
+		return "typing"
+	case len(env.CallMessage) > 0:
+		return "call"
+	default:
+		return "other"
+	}
+}
+
+// formatEnvelope renders an incoming message as a single human readable line
+func formatEnvelope(env JsonMessageEnvelope) string {
+	ts := env.Timestamp
+	if ts == 0 {
+		ts = env.ServerTimestamp
+	}
+	when := time.Unix(0, ts*int64(time.Millisecond)).Format("15:04:05")
+
+	from := "unknown"
+	if env.Source != nil {
+		from = env.Source.String()
+	}
+
+	switch {
+	case env.DataMessage != nil:
+		return fmt.Sprintf("[%s] %s%s: %s", when, from, groupSuffix(env.DataMessage), describeDataMessage(env.DataMessage))
+	case env.SyncMessage != nil && env.SyncMessage.Sent != nil:
+		sent := env.SyncMessage.Sent
+		to := ""
+		if sent.Destination != nil {
+			to = sent.Destination.String()
+		}
+		return fmt.Sprintf("[%s] me -> %s%s: %s", when, to, groupSuffix(sent.Message), describeDataMessage(sent.Message))
+	case env.Receipt != nil:
+		return fmt.Sprintf("[%s] %s: %s receipt for %d message(s)", when, from, strings.ToLower(env.Receipt.Type), len(env.Receipt.Timestamps))
+	case env.Typing != nil:
+		return fmt.Sprintf("[%s] %s: typing %s", when, from, strings.ToLower(env.Typing.Action))
+	default:
+		return fmt.Sprintf("[%s] %s: %s envelope", when, from, strings.ToLower(env.Type))
+	}
+}
+
+func groupSuffix(m *JsonDataMessage) string {
+	if m == nil {
+		return ""
+	}
+	if m.GroupV2 != nil && m.GroupV2.Title != "" {
+		return " in " + m.GroupV2.Title
+	}
+	if m.Group != nil && m.Group.Name != "" {
+		return " in " + m.Group.Name
+	}
+	if id := m.GroupID(); id != "" {
+		return " in " + id
+	}
+	return ""
+}
+
+func describeDataMessage(m *JsonDataMessage) string {
+	if m == nil {
+		return ""
+	}
+	parts := []string{}
+	if m.Reaction != nil {
+		if m.Reaction.Remove {
+			parts = append(parts, fmt.Sprintf("removed reaction %s from %d", m.Reaction.Emoji, m.Reaction.TargetSentTimestamp))
+		} else {
+			parts = append(parts, fmt.Sprintf("reacted %s to %d", m.Reaction.Emoji, m.Reaction.TargetSentTimestamp))
+		}
+	}
+	if m.Quote != nil {
+		parts = append(parts, fmt.Sprintf("(replying to %d)", m.Quote.ID))
+	}
+	if m.Body != "" {
+		parts = append(parts, m.Body)
+	}
+	for _, a := range m.Attachments {
+		name := a.CustomFilename
+		if name == "" {
+			name = a.StoredFilename
+		}
+		parts = append(parts, fmt.Sprintf("[attachment %s %s]", a.ContentType, name))
+	}
+	if m.EndSession {
+		parts = append(parts, "[end session]")
+	}
+	return strings.Join(parts, " ")
+}
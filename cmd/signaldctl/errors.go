@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"gitlab.com/signald/signald/internal/socket"
+)
+
+// exit codes, so scripts can tell failures apart without parsing messages. Documented in the root command's help.
+const (
+	exitError          = 1  // anything not covered below
+	exitUsage          = 2  // bad flags or arguments
+	exitConnection     = 3  // signald could not be reached, or closed the connection
+	exitTimeout        = 4  // signald did not answer within --timeout
+	exitNotFound       = 5  // the account, group, session or profile does not exist
+	exitActionRequired = 6  // signal wants something only a human can provide, like a captcha
+	exitRejected       = 7  // signald refused the request as invalid
+	exitNoPrompt       = 8  // confirmation was needed but --no-prompt was given
+	exitAborted        = 9  // the user answered no at a confirmation prompt
+	exitProtocol       = 10 // any other error reported by signald
+)
+
+const exitCodeHelp = `exit codes:
+  1   unexpected error
+  2   invalid flags or arguments
+  3   could not connect to signald, or the connection was lost
+  4   signald did not respond within --timeout
+  5   account, group, session or profile not found
+  6   signal requires user action, such as solving a captcha or entering a registration lock PIN
+  7   signald rejected the request as invalid
+  8   confirmation was required but --no-prompt was given
+  9   aborted at a confirmation prompt
+  10  any other error reported by signald`
+
+// exit codes for protocol errors, keyed by error_type. Unlisted types exit with exitProtocol.
+var errorExitCodes = map[string]int{
+	"NoSuchAccountException":    exitNotFound,
+	"UnknownGroupException":     exitNotFound,
+	"NoSuchSession":             exitNotFound,
+	"AccountHasNoKeys":          exitNotFound,
+	"ProfileUnavailable":        exitNotFound,
+	"GroupNotFoundException":    exitNotFound,
+	"NotAGroupMemberException":  exitNotFound,
+	"CaptchaRequired":           exitActionRequired,
+	"AccountLocked":             exitActionRequired,
+	"RequestValidationFailure":  exitRejected,
+	"InvalidRequestException":   exitRejected,
+	"InvalidRecipientException": exitRejected,
+	"InvalidAddressException":   exitRejected,
+	"AccountAlreadyVerified":    exitRejected,
+	"UnknownIdentityKey":        exitRejected,
+}
+
+var (
+	errAborted  = errors.New("aborted")
+	errNoPrompt = errors.New("confirmation required but --no-prompt was given")
+)
+
+// usageError marks errors caused by how signaldctl was invoked rather than by signald
+type usageError struct {
+	err error
+}
+
+func (e usageError) Error() string { return e.err.Error() }
+func (e usageError) Unwrap() error { return e.err }
+
+// connectError is returned when the signald socket cannot be opened
+type connectError struct {
+	path string
+	err  error
+}
+
+func (e connectError) Error() string {
+	return fmt.Sprintf("error connecting to signald at %s: %v", e.path, e.err)
+}
+func (e connectError) Unwrap() error { return e.err }
+
+// markUsageErrors makes argument and pre-run validation errors of cmd and its subcommands usage errors.
+// cobra reports bad arguments as plain errors, and the pre-run hooks in signaldctl only validate input.
+func markUsageErrors(cmd *cobra.Command) {
+	if args := cmd.Args; args != nil {
+		cmd.Args = func(c *cobra.Command, a []string) error {
+			if err := args(c, a); err != nil {
+				return usageError{err}
+			}
+			return nil
+		}
+	}
+	if preRun := cmd.PreRunE; preRun != nil {
+		cmd.PreRunE = func(c *cobra.Command, a []string) error {
+			if err := preRun(c, a); err != nil {
+				return usageError{err}
+			}
+			return nil
+		}
+	}
+	for _, child := range cmd.Commands() {
+		markUsageErrors(child)
+	}
+}
+
+func exitCode(err error) int {
+	var usage usageError
+	var connect connectError
+	var protocolErr *socket.Error
+	var health healthError
+	switch {
+	case errors.As(err, &health):
+		return exitError
+	case errors.As(err, &usage):
+		return exitUsage
+	case strings.HasPrefix(err.Error(), "unknown command"):
+		// cobra has no error type for this
+		return exitUsage
+	case errors.As(err, &connect), errors.Is(err, socket.ErrClosed):
+		return exitConnection
+	case errors.Is(err, context.DeadlineExceeded):
+		return exitTimeout
+	case errors.Is(err, errNoPrompt):
+		return exitNoPrompt
+	case errors.Is(err, errAborted):
+		return exitAborted
+	case errors.As(err, &protocolErr):
+		if code, ok := errorExitCodes[protocolErr.Type]; ok {
+			return code
+		}
+		return exitProtocol
+	}
+	return exitError
+}
+
+// JSONError is written to stderr in place of the usual error line when --output is json
+type JSONError struct {
+	Error JSONErrorDetail `json:"error"`
+}
+
+type JSONErrorDetail struct {
+	ExitCode          int             `json:"exit_code"`
+	Type              string          `json:"type,omitempty"`
+	Message           string          `json:"message"`
+	Hint              string          `json:"hint,omitempty"`
+	ValidationResults []string        `json:"validation_results,omitempty"`
+	Raw               json.RawMessage `json:"raw,omitempty"`
+}
+
+// reportError prints err to stderr in the selected output format and returns the exit code for it
+func reportError(err error) int {
+	code := exitCode(err)
+	if outputFormat != outputJSON {
+		fmt.Fprintln(os.Stderr, "error:", describeError(err))
+		return code
+	}
+
+	detail := JSONErrorDetail{ExitCode: code, Message: err.Error()}
+	var protocolErr *socket.Error
+	if errors.As(err, &protocolErr) {
+		detail.Type = protocolErr.Type
+		detail.Message = protocolErr.Message
+		detail.Hint = errorHints[protocolErr.Type]
+		detail.ValidationResults = validationResults(protocolErr)
+		if json.Valid(protocolErr.Raw) {
+			detail.Raw = protocolErr.Raw
+		}
+	}
+	b, marshalErr := json.Marshal(JSONError{Error: detail})
+	if marshalErr != nil {
+		fmt.Fprintln(os.Stderr, "error:", describeError(err))
+		return code
+	}
+	fmt.Fprintln(os.Stderr, string(b))
+	return code
+}
+
+// hints for protocol errors that users are likely to hit from the command line, keyed by error_type
+var errorHints = map[string]string{
+	"CaptchaRequired":        "signal requires a captcha to register this number. see https://signald.org/articles/captcha/ and pass the token with --captcha",
+	"AccountHasNoKeys":       "this number has no registration in progress, run \"signaldctl account register\" first",
+	"AccountAlreadyVerified": "this account has already been verified",
+	"AccountLocked":          "this account is protected with a registration lock PIN, see https://gitlab.com/signald/signald/-/issues/47",
+	"NoSuchAccountException": "this signald instance does not have that account, see \"signaldctl account list\"",
+	"NoSuchSession":          "the linking session has expired or does not exist, start over with \"signaldctl account link\"",
+	"UnknownGroupException":  "this account is not in the requested group, see \"signaldctl group list\"",
+	"UnknownIdentityKey":     "that safety number does not match any known identity key for this user, see \"signaldctl identity list\"",
+}
+
+// describeError turns errors reported by signald into something more helpful on the command line
+func describeError(err error) error {
+	var protocolErr *socket.Error
+	if !errors.As(err, &protocolErr) {
+		return err
+	}
+
+	message := protocolErr.Message
+	if results := validationResults(protocolErr); len(results) > 0 {
+		message = message + ": " + strings.Join(results, ", ")
+	}
+
+	if hint, ok := errorHints[protocolErr.Type]; ok {
+		return fmt.Errorf("%s (%s)", message, hint)
+	}
+	if protocolErr.Type != "" {
+		return fmt.Errorf("%s: %s", protocolErr.Type, message)
+	}
+	return errors.New(message)
+}
+
+func validationResults(protocolErr *socket.Error) []string {
+	var validation struct {
+		ValidationResults []string `json:"validationResults"`
+	}
+	if json.Unmarshal(protocolErr.Raw, &validation) != nil {
+		return nil
+	}
+	return validation.ValidationResults
+}
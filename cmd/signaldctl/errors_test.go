@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"gitlab.com/signald/signald/internal/socket"
+)
+
+func TestExitCode(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"unexpected", errors.New("something broke"), exitError},
+		{"usage", usageError{errors.New("accepts 1 arg(s), received 0")}, exitUsage},
+		{"unknown command", errors.New(`unknown command "foo" for "signaldctl"`), exitUsage},
+		{"connect", connectError{path: "/tmp/signald.sock", err: errors.New("no such file or directory")}, exitConnection},
+		{"closed", socket.ErrClosed, exitConnection},
+		{"wrapped closed", fmt.Errorf("error sending: %w", socket.ErrClosed), exitConnection},
+		{"timeout", context.DeadlineExceeded, exitTimeout},
+		{"no prompt", errNoPrompt, exitNoPrompt},
+		{"aborted", errAborted, exitAborted},
+		{"known protocol error", &socket.Error{Type: "NoSuchAccountException"}, exitNotFound},
+		{"captcha", &socket.Error{Type: "CaptchaRequired"}, exitActionRequired},
+		{"validation", &socket.Error{Type: "RequestValidationFailure"}, exitRejected},
+		{"unknown protocol error", &socket.Error{Type: "SomethingNew"}, exitProtocol},
+		{"health wins over the cause", healthError{context.DeadlineExceeded}, exitError},
+	}
+	for _, test := range tests {
+		if got := exitCode(test.err); got != test.want {
+			t.Errorf("%s: exitCode(%v) = %d, expected %d", test.name, test.err, got, test.want)
+		}
+	}
+}
+
+func TestDescribeError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{"not from signald", errors.New("plain"), "plain"},
+		{"with hint", &socket.Error{Type: "NoSuchSession", Message: "no such session"}, `no such session (the linking session has expired or does not exist, start over with "signaldctl account link")`},
+		{"without hint", &socket.Error{Type: "SomethingNew", Message: "it broke"}, "SomethingNew: it broke"},
+		{"without type", &socket.Error{Message: "it broke"}, "it broke"},
+		{
+			"validation results",
+			&socket.Error{Type: "RequestValidationFailure", Message: "invalid request", Raw: []byte(`{"validationResults":["account is required"]}`)},
+			"RequestValidationFailure: invalid request: account is required",
+		},
+	}
+	for _, test := range tests {
+		if got := describeError(test.err).Error(); got != test.want {
+			t.Errorf("%s: got %q, expected %q", test.name, got, test.want)
+		}
+	}
+}
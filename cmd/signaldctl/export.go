@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"gitlab.com/signald/signald/internal/socket"
+)
+
+// exportFormatVersion is bumped whenever the layout of an export directory changes in an incompatible way
+const exportFormatVersion = 1
+
+// ExportManifest describes an export directory, it is written last so a directory without one is incomplete
+type ExportManifest struct {
+	FormatVersion int       `json:"format_version"`
+	Account       string    `json:"account"`
+	ExportedAt    time.Time `json:"exported_at"`
+	Files         []string  `json:"files"`
+}
+
+var exportOut string
+
+var exportCmd = &cobra.Command{
+	Use:   "export --account <account> --out <directory>",
+	Short: "export an account's contacts, groups and profile to a directory",
+	Long: `write everything signald can report about an account to a directory, for archival or migration.
+
+The directory contains the following files, each holding the data signald returned for the request in brackets:
+
+  manifest.json     format_version, account, exported_at and the list of files below. Written last.
+  account.json      the account as listed by list_accounts
+  profile.json      the account's own profile (get_profile), omitted if signald has none
+  contacts.json     contacts and their profiles (list_contacts)
+  groups.json       groups and legacy groups (list_groups)
+  identities.json   identity keys and trust levels for every known user (get_all_identities)
+
+Message history is not included: signald does not keep messages once they have been delivered to clients.
+The export does not contain key material, it is not a way to back up or move the account itself.`,
+	Example: `  signaldctl export --account +12024561414 --out ./backup-2024-01-01`,
+	Args:    cobra.NoArgs,
+	PreRunE: func(_ *cobra.Command, _ []string) error {
+		if err := requireAccount(); err != nil {
+			return err
+		}
+		if exportOut == "" {
+			return errors.New("--out is required")
+		}
+		return nil
+	},
+	RunE: func(_ *cobra.Command, _ []string) error {
+		conn, ctx, cancel, err := connect()
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		defer cancel()
+
+		if err := os.MkdirAll(exportOut, 0700); err != nil {
+			return err
+		}
+		manifest := ExportManifest{FormatVersion: exportFormatVersion, Account: account, ExportedAt: time.Now().UTC()}
+
+		write := func(name string, data interface{}) error {
+			var b []byte
+			if raw, ok := data.(json.RawMessage); ok {
+				var indented bytes.Buffer
+				if err := json.Indent(&indented, raw, "", "  "); err != nil {
+					return err
+				}
+				b = indented.Bytes()
+			} else if b, err = json.MarshalIndent(data, "", "  "); err != nil {
+				return err
+			}
+			if err := ioutil.WriteFile(filepath.Join(exportOut, name), append(b, '\n'), 0600); err != nil {
+				return err
+			}
+			if name != "manifest.json" {
+				manifest.Files = append(manifest.Files, name)
+			}
+			fmt.Fprintln(os.Stderr, "wrote", name)
+			return nil
+		}
+
+		var accounts struct {
+			Accounts []json.RawMessage `json:"accounts"`
+		}
+		if err := conn.RequestInto(ctx, "v1", "list_accounts", nil, &accounts); err != nil {
+			return err
+		}
+		found := false
+		for _, raw := range accounts.Accounts {
+			var a Account
+			if err := json.Unmarshal(raw, &a); err != nil {
+				return err
+			}
+			if a.AccountID == account {
+				if err := write("account.json", raw); err != nil {
+					return err
+				}
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("signald does not have an account %s", account)
+		}
+
+		var profile json.RawMessage
+		err = conn.RequestInto(ctx, "v1", "get_profile", GetProfileRequest{Account: account, Address: JsonAddress{Number: account}}, &profile)
+		var protocolErr *socket.Error
+		switch {
+		case err == nil:
+			if err := write("profile.json", profile); err != nil {
+				return err
+			}
+		case errors.As(err, &protocolErr):
+			// accounts that never set a profile have none to export
+			fmt.Fprintln(os.Stderr, "skipping profile.json:", describeError(err))
+		default:
+			return err
+		}
+
+		requests := []struct {
+			file        string
+			requestType string
+			payload     interface{}
+		}{
+			{"contacts.json", "list_contacts", ListContactsRequest{Account: account}},
+			{"groups.json", "list_groups", ListGroupsRequest{Account: account}},
+			{"identities.json", "get_all_identities", GetAllIdentitiesRequest{Account: account}},
+		}
+		for _, r := range requests {
+			var data json.RawMessage
+			if err := conn.RequestInto(ctx, "v1", r.requestType, r.payload, &data); err != nil {
+				return fmt.Errorf("error exporting %s: %w", r.file, err)
+			}
+			if err := write(r.file, data); err != nil {
+				return err
+			}
+		}
+
+		return write("manifest.json", manifest)
+	},
+}
+
+func init() {
+	addAccountFlag(exportCmd)
+	exportCmd.Flags().StringVar(&exportOut, "out", "", "directory to write the export to, created if it does not exist")
+	exportCmd.MarkFlagDirname("out")
+	rootCmd.AddCommand(exportCmd)
+}
@@ -0,0 +1,350 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+var groupCmd = &cobra.Command{
+	Use:   "group",
+	Short: "list, create and manage groups",
+}
+
+var (
+	createTitle      string
+	createMembers    []string
+	createAvatar     string
+	createMemberRole string
+	createTimer      int
+
+	updateTitle            string
+	updateAvatar           string
+	updateTimer            int
+	updateRole             string
+	updateLinkAccess       string
+	updateAttributesAccess string
+	updateMembersAccess    string
+	updateResetLink        bool
+)
+
+var groupListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "list the groups an account is in",
+	Args:  cobra.NoArgs,
+	PreRunE: func(_ *cobra.Command, _ []string) error {
+		if err := requireAccount(); err != nil {
+			return err
+		}
+		return checkOutputFormat(outputTable, outputJSON)
+	},
+	RunE: func(_ *cobra.Command, _ []string) error {
+		conn, ctx, cancel, err := connect()
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		defer cancel()
+
+		var list GroupList
+		if err := conn.RequestInto(ctx, "v1", "list_groups", ListGroupsRequest{Account: account}, &list); err != nil {
+			return err
+		}
+
+		if outputFormat == outputJSON {
+			return printJSON(list)
+		}
+
+		rows := [][]string{}
+		for _, g := range list.Groups {
+			rows = append(rows, []string{g.ID, g.Title, strconv.Itoa(len(g.Members)), "v2"})
+		}
+		for _, g := range list.LegacyGroups {
+			rows = append(rows, []string{g.GroupID, g.Name, strconv.Itoa(len(g.Members)), "v1"})
+		}
+		return printTable([]string{"ID", "TITLE", "MEMBERS", "VERSION"}, rows)
+	},
+}
+
+var groupCreateCmd = &cobra.Command{
+	Use:     "create",
+	Short:   "create a new group",
+	Example: `  signaldctl group create --account +12024561414 --title "book club" --member +12024561111 --member +12024562222`,
+	Args:    cobra.NoArgs,
+	PreRunE: func(_ *cobra.Command, _ []string) error {
+		if err := requireAccount(); err != nil {
+			return err
+		}
+		return checkOutputFormat(outputTable, outputJSON)
+	},
+	RunE: func(_ *cobra.Command, _ []string) error {
+		if createTitle == "" {
+			return errors.New("--title is required")
+		}
+		members, err := parseAddresses(createMembers)
+		if err != nil {
+			return err
+		}
+		req := CreateGroupRequest{
+			Account:    account,
+			Title:      createTitle,
+			Members:    members,
+			MemberRole: createMemberRole,
+			Timer:      createTimer,
+		}
+		if createAvatar != "" {
+			if req.Avatar, err = filepath.Abs(createAvatar); err != nil {
+				return err
+			}
+		}
+
+		conn, ctx, cancel, err := connect()
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		defer cancel()
+
+		var group JsonGroupV2Info
+		if err := conn.RequestInto(ctx, "v1", "create_group", req, &group); err != nil {
+			return err
+		}
+		return printGroup(GroupInfo{V2: &group})
+	},
+}
+
+var groupUpdateCmd = &cobra.Command{
+	Use:   "update <group ID>",
+	Short: "change a group's settings",
+	Long: `change a group's settings. signald only accepts one change per request, so exactly one of the flags must be given.
+
+Access levels are one of ANY, MEMBER, ADMINISTRATOR or UNSATISFIABLE. Setting --link-access to UNSATISFIABLE disables the group link.`,
+	Example: `  signaldctl group update --account +12024561414 EdSqI90cS0UomDpgUXOlCoObWvQOXlH5G3Z2d3f4ayE= --title "new title"
+  signaldctl group update --account +12024561414 EdSqI90cS0UomDpgUXOlCoObWvQOXlH5G3Z2d3f4ayE= --role aeed01f0-a234-478e-8cf7-261c283151e7=ADMINISTRATOR`,
+	Args:              cobra.ExactArgs(1),
+	ValidArgsFunction: firstArg(completeGroups),
+	PreRunE: func(_ *cobra.Command, _ []string) error {
+		if err := requireAccount(); err != nil {
+			return err
+		}
+		return checkOutputFormat(outputTable, outputJSON)
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		req := UpdateGroupRequest{Account: account, GroupID: args[0]}
+		changes := 0
+		flags := cmd.Flags()
+
+		if flags.Changed("title") {
+			changes++
+			req.Title = updateTitle
+		}
+		if flags.Changed("avatar") {
+			changes++
+			avatar, err := filepath.Abs(updateAvatar)
+			if err != nil {
+				return err
+			}
+			req.Avatar = avatar
+		}
+		if flags.Changed("timer") {
+			changes++
+			req.UpdateTimer = &updateTimer
+		}
+		if flags.Changed("role") {
+			changes++
+			parts := strings.SplitN(updateRole, "=", 2)
+			if len(parts) != 2 || !uuidRegex.MatchString(parts[0]) {
+				return errors.New("--role must be in the form <member UUID>=<DEFAULT or ADMINISTRATOR>")
+			}
+			req.UpdateRole = &GroupMember{UUID: strings.ToLower(parts[0]), Role: strings.ToUpper(parts[1])}
+		}
+		for _, access := range []struct {
+			flag  string
+			value string
+			set   func(*GroupAccessControl, string)
+		}{
+			{"link-access", updateLinkAccess, func(a *GroupAccessControl, v string) { a.Link = v }},
+			{"attributes-access", updateAttributesAccess, func(a *GroupAccessControl, v string) { a.Attributes = v }},
+			{"members-access", updateMembersAccess, func(a *GroupAccessControl, v string) { a.Members = v }},
+		} {
+			if flags.Changed(access.flag) {
+				changes++
+				req.UpdateAccessControl = &GroupAccessControl{}
+				access.set(req.UpdateAccessControl, strings.ToUpper(access.value))
+			}
+		}
+		if updateResetLink {
+			changes++
+			req.ResetLink = true
+		}
+
+		if changes != 1 {
+			return errors.New("exactly one change must be requested per update (see --help)")
+		}
+		return updateGroup(req)
+	},
+}
+
+var groupAddMemberCmd = &cobra.Command{
+	Use:               "add-member <group ID> <phone number or UUID>...",
+	Short:             "add members to a group",
+	Args:              cobra.MinimumNArgs(2),
+	ValidArgsFunction: firstArg(completeGroups),
+	PreRunE: func(_ *cobra.Command, _ []string) error {
+		if err := requireAccount(); err != nil {
+			return err
+		}
+		return checkOutputFormat(outputTable, outputJSON)
+	},
+	RunE: func(_ *cobra.Command, args []string) error {
+		members, err := parseAddresses(args[1:])
+		if err != nil {
+			return err
+		}
+		return updateGroup(UpdateGroupRequest{Account: account, GroupID: args[0], AddMembers: members})
+	},
+}
+
+var groupRemoveMemberCmd = &cobra.Command{
+	Use:               "remove-member <group ID> <phone number or UUID>...",
+	Short:             "remove members from a group",
+	Args:              cobra.MinimumNArgs(2),
+	ValidArgsFunction: firstArg(completeGroups),
+	PreRunE: func(_ *cobra.Command, _ []string) error {
+		if err := requireAccount(); err != nil {
+			return err
+		}
+		return checkOutputFormat(outputTable, outputJSON)
+	},
+	RunE: func(_ *cobra.Command, args []string) error {
+		members, err := parseAddresses(args[1:])
+		if err != nil {
+			return err
+		}
+		return updateGroup(UpdateGroupRequest{Account: account, GroupID: args[0], RemoveMembers: members})
+	},
+}
+
+var groupLeaveCmd = &cobra.Command{
+	Use:               "leave <group ID>",
+	Short:             "leave a group",
+	Args:              cobra.ExactArgs(1),
+	ValidArgsFunction: firstArg(completeGroups),
+	PreRunE: func(_ *cobra.Command, _ []string) error {
+		if err := requireAccount(); err != nil {
+			return err
+		}
+		return checkOutputFormat(outputTable, outputJSON)
+	},
+	RunE: func(_ *cobra.Command, args []string) error {
+		conn, ctx, cancel, err := connect()
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		defer cancel()
+
+		var group GroupInfo
+		if err := conn.RequestInto(ctx, "v1", "leave_group", LeaveGroupRequest{Account: account, GroupID: args[0]}, &group); err != nil {
+			return err
+		}
+		return printGroup(group)
+	},
+}
+
+func updateGroup(req UpdateGroupRequest) error {
+	conn, ctx, cancel, err := connect()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	defer cancel()
+
+	var group GroupInfo
+	if err := conn.RequestInto(ctx, "v1", "update_group", req, &group); err != nil {
+		return err
+	}
+	return printGroup(group)
+}
+
+func parseAddresses(in []string) ([]JsonAddress, error) {
+	out := make([]JsonAddress, 0, len(in))
+	for _, s := range in {
+		address, err := parseAddress(s)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, *address)
+	}
+	return out, nil
+}
+
+func printGroup(group GroupInfo) error {
+	if outputFormat == outputJSON {
+		return printJSON(group)
+	}
+
+	rows := [][]string{}
+	if g := group.V2; g != nil {
+		roles := map[string]string{}
+		for _, m := range g.MemberDetail {
+			roles[m.UUID] = m.Role
+		}
+		rows = append(rows, []string{"ID", g.ID}, []string{"TITLE", g.Title}, []string{"REVISION", strconv.Itoa(g.Revision)})
+		if g.Description != "" {
+			rows = append(rows, []string{"DESCRIPTION", g.Description})
+		}
+		if g.Timer > 0 {
+			rows = append(rows, []string{"TIMER", strconv.Itoa(g.Timer) + "s"})
+		}
+		if g.InviteLink != "" {
+			rows = append(rows, []string{"INVITE LINK", g.InviteLink})
+		}
+		if a := g.AccessControl; a != nil {
+			rows = append(rows, []string{"ACCESS", fmt.Sprintf("link=%s attributes=%s members=%s", a.Link, a.Attributes, a.Members)})
+		}
+		for _, m := range g.Members {
+			rows = append(rows, []string{"MEMBER", strings.TrimSpace(m.String() + " " + roles[m.UUID])})
+		}
+		for _, m := range g.PendingMembers {
+			rows = append(rows, []string{"PENDING", m.String()})
+		}
+		for _, m := range g.RequestingMembers {
+			rows = append(rows, []string{"REQUESTING", m.String()})
+		}
+	} else if g := group.V1; g != nil {
+		rows = append(rows, []string{"ID", g.GroupID}, []string{"TITLE", g.Name})
+		for _, m := range g.Members {
+			rows = append(rows, []string{"MEMBER", m.String()})
+		}
+	}
+	return printTable([]string{"FIELD", "VALUE"}, rows)
+}
+
+func init() {
+	for _, cmd := range []*cobra.Command{groupListCmd, groupCreateCmd, groupUpdateCmd, groupAddMemberCmd, groupRemoveMemberCmd, groupLeaveCmd} {
+		addAccountFlag(cmd)
+	}
+
+	groupCreateCmd.Flags().StringVar(&createTitle, "title", "", "title of the new group")
+	groupCreateCmd.Flags().StringArrayVarP(&createMembers, "member", "m", nil, "phone number or UUID of a member to add, may be repeated")
+	groupCreateCmd.Flags().StringVar(&createAvatar, "avatar", "", "path to an image to use as the group avatar, must be readable by signald")
+	groupCreateCmd.Flags().StringVar(&createMemberRole, "member-role", "", "role of all members other than the creator: DEFAULT or ADMINISTRATOR")
+	groupCreateCmd.Flags().IntVar(&createTimer, "timer", 0, "disappearing message timer, in seconds")
+
+	groupUpdateCmd.Flags().StringVar(&updateTitle, "title", "", "change the group title")
+	groupUpdateCmd.Flags().StringVar(&updateAvatar, "avatar", "", "path to a new group avatar, must be readable by signald")
+	groupUpdateCmd.Flags().IntVar(&updateTimer, "timer", 0, "change the disappearing message timer, in seconds (0 to disable)")
+	groupUpdateCmd.Flags().StringVar(&updateRole, "role", "", "change a member's role, as <member UUID>=<DEFAULT or ADMINISTRATOR>")
+	groupUpdateCmd.Flags().StringVar(&updateLinkAccess, "link-access", "", "who can join with the group link")
+	groupUpdateCmd.Flags().StringVar(&updateAttributesAccess, "attributes-access", "", "who can edit the group title, avatar and timer")
+	groupUpdateCmd.Flags().StringVar(&updateMembersAccess, "members-access", "", "who can add members")
+	groupUpdateCmd.Flags().BoolVar(&updateResetLink, "reset-link", false, "regenerate the group link, invalidating the old one")
+
+	groupCmd.AddCommand(groupListCmd, groupCreateCmd, groupUpdateCmd, groupAddMemberCmd, groupRemoveMemberCmd, groupLeaveCmd)
+	rootCmd.AddCommand(groupCmd)
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// healthTimeout replaces the usual --timeout default, probes are expected to answer quickly or not at all
+const healthTimeout = 5 * time.Second
+
+// healthError makes health exit with 1 whatever went wrong. Docker reserves 2 in health checks and orchestrators
+// only care about zero or not.
+type healthError struct {
+	err error
+}
+
+func (e healthError) Error() string { return "unhealthy: " + describeError(e.err).Error() }
+
+type healthStatus struct {
+	Healthy bool   `json:"healthy"`
+	Version string `json:"version"`
+	Account string `json:"account,omitempty"`
+}
+
+var healthCmd = &cobra.Command{
+	Use:   "health",
+	Short: "check that signald is up, for liveness probes",
+	Long: `connect to signald and ask for its version, exiting 0 if it answers and 1 otherwise. --timeout defaults
+to 5 seconds here.
+
+With --account the account must also be one signald has. signald does not say whether other clients are
+subscribed to an account, so this is as far as a probe can check without subscribing itself, which would
+take messages away from the real client while it's down.
+
+Docker:      HEALTHCHECK CMD signaldctl health
+Kubernetes:  livenessProbe: {exec: {command: [signaldctl, health]}}`,
+	Args: cobra.NoArgs,
+	PreRunE: func(_ *cobra.Command, _ []string) error {
+		return checkOutputFormat(outputTable, outputJSON)
+	},
+	RunE: func(cmd *cobra.Command, _ []string) error {
+		if !cmd.Flags().Changed("timeout") {
+			timeout = healthTimeout
+		}
+		status, err := checkHealth()
+		if err != nil {
+			return healthError{err}
+		}
+		if outputFormat == outputJSON {
+			return printJSON(status)
+		}
+		fmt.Println("healthy: signald " + status.Version)
+		return nil
+	},
+}
+
+func checkHealth() (healthStatus, error) {
+	conn, ctx, cancel, err := connect()
+	if err != nil {
+		return healthStatus{}, err
+	}
+	defer conn.Close()
+	defer cancel()
+
+	var version struct {
+		Version string `json:"version"`
+	}
+	if err := conn.RequestInto(ctx, "v1", "version", nil, &version); err != nil {
+		return healthStatus{}, err
+	}
+	status := healthStatus{Healthy: true, Version: version.Version, Account: account}
+	if account == "" {
+		return status, nil
+	}
+
+	var list AccountList
+	if err := conn.RequestInto(ctx, "v1", "list_accounts", nil, &list); err != nil {
+		return healthStatus{}, err
+	}
+	for _, a := range list.Accounts {
+		if a.AccountID == account {
+			return status, nil
+		}
+	}
+	return healthStatus{}, errors.New("signald does not have account " + account)
+}
+
+func init() {
+	addAccountFlag(healthCmd)
+	rootCmd.AddCommand(healthCmd)
+}
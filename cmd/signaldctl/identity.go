@@ -0,0 +1,337 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var identityCmd = &cobra.Command{
+	Use:   "identity",
+	Short: "inspect identity keys and safety numbers",
+}
+
+var (
+	identityListAddress       string
+	identityListUntrustedOnly bool
+)
+
+var identityListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "list known identity keys and their trust levels",
+	Args:  cobra.NoArgs,
+	PreRunE: func(_ *cobra.Command, _ []string) error {
+		if err := requireAccount(); err != nil {
+			return err
+		}
+		return checkOutputFormat(outputTable, outputJSON, outputCSV)
+	},
+	RunE: func(_ *cobra.Command, _ []string) error {
+		conn, ctx, cancel, err := connect()
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		defer cancel()
+
+		var keys []IdentityKeyList
+		if identityListAddress != "" {
+			address, err := parseAddress(identityListAddress)
+			if err != nil {
+				return err
+			}
+			var list IdentityKeyList
+			if err := conn.RequestInto(ctx, "v1", "get_identities", GetIdentitiesRequest{Account: account, Address: *address}, &list); err != nil {
+				return err
+			}
+			keys = append(keys, list)
+		} else {
+			var all AllIdentityKeyList
+			if err := conn.RequestInto(ctx, "v1", "get_all_identities", GetAllIdentitiesRequest{Account: account}, &all); err != nil {
+				return err
+			}
+			keys = all.IdentityKeys
+		}
+
+		if identityListUntrustedOnly {
+			keys = filterUntrusted(keys)
+		}
+
+		if outputFormat == outputJSON {
+			return printJSON(keys)
+		}
+
+		rows := [][]string{}
+		for _, list := range keys {
+			for _, key := range list.Identities {
+				added := time.Unix(0, key.Added*int64(time.Millisecond)).UTC().Format(time.RFC3339)
+				rows = append(rows, []string{list.Address.Number, list.Address.UUID, key.TrustLevel, key.SafetyNumber, added})
+			}
+		}
+		return printRows([]string{"NUMBER", "UUID", "TRUST LEVEL", "SAFETY NUMBER", "ADDED"}, rows)
+	},
+}
+
+// filterUntrusted keeps only the identity keys that have not been marked as trusted
+func filterUntrusted(in []IdentityKeyList) []IdentityKeyList {
+	out := []IdentityKeyList{}
+	for _, list := range in {
+		untrusted := []IdentityKey{}
+		for _, key := range list.Identities {
+			if !strings.HasPrefix(key.TrustLevel, "TRUSTED") {
+				untrusted = append(untrusted, key)
+			}
+		}
+		if len(untrusted) > 0 {
+			out = append(out, IdentityKeyList{Address: list.Address, Identities: untrusted})
+		}
+	}
+	return out
+}
+
+const (
+	trustedVerified   = "TRUSTED_VERIFIED"
+	trustedUnverified = "TRUSTED_UNVERIFIED"
+	untrusted         = "UNTRUSTED"
+)
+
+var (
+	trustSafetyNumber string
+	trustQRCodeData   string
+	trustLevel        string
+	trustAllNew       bool
+	trustYes          bool
+)
+
+var identityTrustCmd = &cobra.Command{
+	Use:   "trust [flags] <phone number or UUID>",
+	Short: "trust a user's identity key",
+	Long: `mark a user's identity key as trusted, so that messages to them stop failing with identity failures.
+
+Without --safety-number or --qr-code-data the newest key signald has for the user is trusted, after asking
+for confirmation. With --trust-all-new every key that is not trusted yet, for every user, is trusted.
+
+Keys trusted this way are TRUSTED_UNVERIFIED unless --level says otherwise. Use "signaldctl identity verify"
+after comparing safety numbers out of band.`,
+	Example: `  signaldctl identity trust --account +12024561414 +12024561111
+  signaldctl identity trust --account +12024561414 --trust-all-new`,
+	PreRunE: func(_ *cobra.Command, args []string) error {
+		if err := requireAccount(); err != nil {
+			return err
+		}
+		if trustAllNew {
+			if len(args) > 0 || trustSafetyNumber != "" || trustQRCodeData != "" {
+				return errors.New("--trust-all-new cannot be combined with an address, --safety-number or --qr-code-data")
+			}
+		} else if len(args) != 1 {
+			return errors.New("exactly one phone number or UUID is required")
+		}
+		if trustSafetyNumber != "" && trustQRCodeData != "" {
+			return errors.New("--safety-number and --qr-code-data cannot be used together")
+		}
+		return checkTrustLevel(trustLevel)
+	},
+	RunE: func(_ *cobra.Command, args []string) error {
+		conn, ctx, cancel, err := connect()
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		defer cancel()
+
+		var pending []TrustRequest
+		if trustAllNew {
+			var all AllIdentityKeyList
+			if err := conn.RequestInto(ctx, "v1", "get_all_identities", GetAllIdentitiesRequest{Account: account}, &all); err != nil {
+				return err
+			}
+			for _, list := range filterUntrusted(all.IdentityKeys) {
+				for _, key := range list.Identities {
+					pending = append(pending, TrustRequest{Account: account, Address: list.Address, SafetyNumber: key.SafetyNumber, TrustLevel: trustLevel})
+				}
+			}
+			if len(pending) == 0 {
+				fmt.Println("no untrusted identity keys")
+				return nil
+			}
+		} else {
+			address, err := parseAddress(args[0])
+			if err != nil {
+				return err
+			}
+			req := TrustRequest{Account: account, Address: *address, SafetyNumber: normalizeSafetyNumber(trustSafetyNumber), QRCodeData: trustQRCodeData, TrustLevel: trustLevel}
+			if req.SafetyNumber == "" && req.QRCodeData == "" {
+				var list IdentityKeyList
+				if err := conn.RequestInto(ctx, "v1", "get_identities", GetIdentitiesRequest{Account: account, Address: *address}, &list); err != nil {
+					return err
+				}
+				newest := newestIdentity(list.Identities)
+				if newest == nil {
+					return fmt.Errorf("signald has no identity keys for %s", args[0])
+				}
+				req.SafetyNumber = newest.SafetyNumber
+			}
+			pending = append(pending, req)
+		}
+
+		if trustAllNew || (trustSafetyNumber == "" && trustQRCodeData == "") {
+			rows := [][]string{}
+			for _, req := range pending {
+				rows = append(rows, []string{req.Address.String(), req.SafetyNumber})
+			}
+			if err := printTable([]string{"ADDRESS", "SAFETY NUMBER"}, rows); err != nil {
+				return err
+			}
+			if !trustYes {
+				ok, err := confirm(fmt.Sprintf("mark %d identity key(s) as %s?", len(pending), trustLevel))
+				if err != nil {
+					return err
+				}
+				if !ok {
+					return errAborted
+				}
+			}
+		}
+
+		for _, req := range pending {
+			if err := conn.RequestInto(ctx, "v1", "trust", req, nil); err != nil {
+				return fmt.Errorf("error trusting %s: %w", req.Address.String(), err)
+			}
+			fmt.Println("trusted", req.Address.String())
+		}
+		return nil
+	},
+}
+
+var identityVerifyCmd = &cobra.Command{
+	Use:   "verify --safety-number <number> <phone number or UUID>",
+	Short: "mark a user's identity key as verified after comparing safety numbers",
+	Long: `mark a user's identity key as TRUSTED_VERIFIED. Only do this after comparing the safety number with
+the other user through a channel you trust, for example in person.`,
+	Args: cobra.ExactArgs(1),
+	PreRunE: func(_ *cobra.Command, _ []string) error {
+		if err := requireAccount(); err != nil {
+			return err
+		}
+		if trustSafetyNumber == "" && trustQRCodeData == "" {
+			return errors.New("one of --safety-number or --qr-code-data is required")
+		}
+		if trustSafetyNumber != "" && trustQRCodeData != "" {
+			return errors.New("--safety-number and --qr-code-data cannot be used together")
+		}
+		return nil
+	},
+	RunE: func(_ *cobra.Command, args []string) error {
+		address, err := parseAddress(args[0])
+		if err != nil {
+			return err
+		}
+
+		conn, ctx, cancel, err := connect()
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		defer cancel()
+
+		req := TrustRequest{Account: account, Address: *address, SafetyNumber: normalizeSafetyNumber(trustSafetyNumber), QRCodeData: trustQRCodeData, TrustLevel: trustedVerified}
+		if err := conn.RequestInto(ctx, "v1", "trust", req, nil); err != nil {
+			return err
+		}
+		fmt.Println("verified", args[0])
+		return nil
+	},
+}
+
+var identityResetCmd = &cobra.Command{
+	Use:   "reset <phone number or UUID>",
+	Short: "reset the encrypted session with a user",
+	Long: `end the current encrypted session with a user, so the next message starts a new one. This can help when
+messages fail to decrypt on either side.`,
+	Args: cobra.ExactArgs(1),
+	PreRunE: func(_ *cobra.Command, _ []string) error {
+		if err := requireAccount(); err != nil {
+			return err
+		}
+		return checkOutputFormat(outputTable, outputJSON)
+	},
+	RunE: func(_ *cobra.Command, args []string) error {
+		address, err := parseAddress(args[0])
+		if err != nil {
+			return err
+		}
+
+		conn, ctx, cancel, err := connect()
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		defer cancel()
+
+		var resp SendResponse
+		if err := conn.RequestInto(ctx, "v1", "reset_session", ResetSessionRequest{Account: account, Address: *address}, &resp); err != nil {
+			return err
+		}
+
+		if outputFormat == outputJSON {
+			return printJSON(resp)
+		}
+
+		timestamp := strconv.FormatInt(resp.Timestamp, 10)
+		rows := [][]string{}
+		for _, result := range resp.Results {
+			rows = append(rows, []string{result.Address.String(), result.Status(), timestamp})
+		}
+		return printTable([]string{"RECIPIENT", "RESULT", "TIMESTAMP"}, rows)
+	},
+}
+
+func checkTrustLevel(level string) error {
+	switch level {
+	case trustedVerified, trustedUnverified, untrusted:
+		return nil
+	}
+	return fmt.Errorf("unknown trust level %q (expected one of: %s, %s, %s)", level, trustedVerified, trustedUnverified, untrusted)
+}
+
+// normalizeSafetyNumber strips the spaces and line breaks people copy along with a safety number
+func normalizeSafetyNumber(s string) string {
+	return strings.Join(strings.Fields(s), "")
+}
+
+func newestIdentity(keys []IdentityKey) *IdentityKey {
+	var newest *IdentityKey
+	for i := range keys {
+		if newest == nil || keys[i].Added > newest.Added {
+			newest = &keys[i]
+		}
+	}
+	return newest
+}
+
+func init() {
+	addAccountFlag(identityListCmd)
+	identityListCmd.Flags().StringVar(&identityListAddress, "address", "", "only list keys for this phone number or UUID")
+	identityListCmd.Flags().BoolVar(&identityListUntrustedOnly, "untrusted-only", false, "only list keys that are not trusted")
+
+	for _, cmd := range []*cobra.Command{identityTrustCmd, identityVerifyCmd} {
+		cmd.Flags().StringVar(&trustSafetyNumber, "safety-number", "", "the safety number to trust, as shown by \"signaldctl identity list\" or the Signal app")
+		cmd.Flags().StringVar(&trustQRCodeData, "qr-code-data", "", "base64 encoded safety number QR code data, instead of --safety-number")
+	}
+	addAccountFlag(identityTrustCmd)
+	identityTrustCmd.Flags().StringVar(&trustLevel, "level", trustedUnverified, "trust level to set: TRUSTED_UNVERIFIED, TRUSTED_VERIFIED or UNTRUSTED")
+	identityTrustCmd.RegisterFlagCompletionFunc("level", func(_ *cobra.Command, _ []string, _ string) ([]string, cobra.ShellCompDirective) {
+		return []string{trustedUnverified, trustedVerified, untrusted}, cobra.ShellCompDirectiveNoFileComp
+	})
+	identityTrustCmd.Flags().BoolVar(&trustAllNew, "trust-all-new", false, "trust every identity key that is not trusted yet")
+	identityTrustCmd.Flags().BoolVarP(&trustYes, "yes", "y", false, "do not ask for confirmation")
+	addAccountFlag(identityVerifyCmd)
+	addAccountFlag(identityResetCmd)
+
+	identityCmd.AddCommand(identityListCmd, identityTrustCmd, identityVerifyCmd, identityResetCmd)
+	rootCmd.AddCommand(identityCmd)
+}
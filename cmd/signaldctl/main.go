@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"gitlab.com/signald/signald/internal/socket"
+)
+
+var (
+	socketPath   string
+	outputFormat string
+	timeout      time.Duration
+	account      string
+	noPrompt     bool
+)
+
+var rootCmd = &cobra.Command{
+	Use:           "signaldctl",
+	Short:         "interact with a running signald instance",
+	Long:          "interact with a running signald instance\n\n" + exitCodeHelp,
+	SilenceUsage:  true,
+	SilenceErrors: true,
+}
+
+func init() {
+	defaultSocket := os.Getenv("SIGNALD_SOCKET")
+	if defaultSocket == "" {
+		defaultSocket = socket.DefaultPath
+	}
+	rootCmd.PersistentFlags().StringVarP(&socketPath, "socket", "s", defaultSocket, "path to the signald socket (env SIGNALD_SOCKET)")
+	rootCmd.PersistentFlags().StringVarP(&outputFormat, "output", "o", "table", "output format: table, json or csv (csv is only supported by list commands)")
+	rootCmd.PersistentFlags().DurationVar(&timeout, "timeout", time.Minute, "how long to wait for signald to respond")
+	rootCmd.PersistentFlags().BoolVar(&noPrompt, "no-prompt", false, "fail instead of asking for confirmation, for unattended use")
+	rootCmd.SetFlagErrorFunc(func(_ *cobra.Command, err error) error {
+		return usageError{err}
+	})
+}
+
+// addAccountFlag registers --account on commands that act on behalf of a local account
+func addAccountFlag(cmd *cobra.Command) {
+	cmd.Flags().StringVarP(&account, "account", "a", "", "local account to use")
+	cmd.RegisterFlagCompletionFunc("account", completeAccounts)
+}
+
+func requireAccount() error {
+	if account == "" {
+		return errors.New("--account is required")
+	}
+	return nil
+}
+
+func dial() (*socket.Conn, error) {
+	conn, err := socket.Dial(socketPath)
+	if err != nil {
+		return nil, connectError{path: socketPath, err: err}
+	}
+	return conn, nil
+}
+
+// connect opens the signald socket and returns a context bounded by --timeout
+func connect() (*socket.Conn, context.Context, context.CancelFunc, error) {
+	conn, err := dial()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	return conn, ctx, cancel, nil
+}
+
+func main() {
+	markUsageErrors(rootCmd)
+	if err := rootCmd.Execute(); err != nil {
+		os.Exit(reportError(err))
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+var messageCmd = &cobra.Command{
+	Use:   "message",
+	Short: "send and manage messages",
+}
+
+var (
+	sendTo             string
+	sendGroup          string
+	sendAttachments    []string
+	sendQuoteTimestamp int64
+	sendQuoteAuthor    string
+	sendQuoteBody      string
+)
+
+var messageSendCmd = &cobra.Command{
+	Use:   "send [flags] [message body]",
+	Short: "send a message to a user or group",
+	Long: `send a message to a user or group.
+
+Attachments are read by signald, not by signaldctl, so they must be paths that the signald process can access.`,
+	Example: `  signaldctl message send --account +12024561414 --to +12024561111 "hello"
+  signaldctl message send --account +12024561414 --group EdSqI90cS0UomDpgUXOlCoObWvQOXlH5G3Z2d3f4ayE= --attachment ./cat.jpg`,
+	PreRunE: func(_ *cobra.Command, _ []string) error {
+		return checkOutputFormat(outputTable, outputJSON)
+	},
+	RunE: func(_ *cobra.Command, args []string) error {
+		if err := requireAccount(); err != nil {
+			return err
+		}
+		req := SendRequest{
+			Username:    account,
+			MessageBody: strings.Join(args, " "),
+		}
+
+		switch {
+		case sendTo != "" && sendGroup != "":
+			return errors.New("--to and --group cannot be used together")
+		case sendTo != "":
+			address, err := parseAddress(sendTo)
+			if err != nil {
+				return err
+			}
+			req.RecipientAddress = address
+		case sendGroup != "":
+			req.RecipientGroupID = sendGroup
+		default:
+			return errors.New("one of --to or --group is required")
+		}
+
+		for _, a := range sendAttachments {
+			path, err := filepath.Abs(a)
+			if err != nil {
+				return err
+			}
+			req.Attachments = append(req.Attachments, JsonAttachment{Filename: path})
+		}
+
+		if req.MessageBody == "" && len(req.Attachments) == 0 {
+			return errors.New("a message body or at least one attachment is required")
+		}
+
+		if sendQuoteTimestamp != 0 {
+			if sendQuoteAuthor == "" {
+				return errors.New("--quote-author is required when quoting a message")
+			}
+			author, err := parseAddress(sendQuoteAuthor)
+			if err != nil {
+				return err
+			}
+			req.Quote = &JsonQuote{ID: sendQuoteTimestamp, Author: author, Text: sendQuoteBody}
+		}
+
+		conn, ctx, cancel, err := connect()
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		defer cancel()
+
+		var resp SendResponse
+		if err := conn.RequestInto(ctx, "v1", "send", req, &resp); err != nil {
+			return err
+		}
+
+		if outputFormat == outputJSON {
+			return printJSON(resp)
+		}
+
+		timestamp := strconv.FormatInt(resp.Timestamp, 10)
+		rows := [][]string{}
+		for _, result := range resp.Results {
+			rows = append(rows, []string{result.Address.String(), result.Status(), timestamp})
+		}
+		return printTable([]string{"RECIPIENT", "RESULT", "TIMESTAMP"}, rows)
+	},
+}
+
+func init() {
+	addAccountFlag(messageSendCmd)
+	messageSendCmd.Flags().StringVarP(&sendTo, "to", "t", "", "phone number or UUID to send to")
+	messageSendCmd.Flags().StringVarP(&sendGroup, "group", "g", "", "group ID to send to")
+	messageSendCmd.RegisterFlagCompletionFunc("group", completeGroups)
+	messageSendCmd.Flags().StringArrayVar(&sendAttachments, "attachment", nil, "path to a file to attach, may be repeated")
+	messageSendCmd.Flags().Int64Var(&sendQuoteTimestamp, "quote-timestamp", 0, "timestamp of the message to quote")
+	messageSendCmd.Flags().StringVar(&sendQuoteAuthor, "quote-author", "", "phone number or UUID of the author of the quoted message")
+	messageSendCmd.Flags().StringVar(&sendQuoteBody, "quote-body", "", "body of the quoted message")
+
+	messageCmd.AddCommand(messageSendCmd)
+	rootCmd.AddCommand(messageCmd)
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+)
+
+const (
+	outputTable = "table"
+	outputJSON  = "json"
+	outputCSV   = "csv"
+)
+
+func checkOutputFormat(formats ...string) error {
+	for _, f := range formats {
+		if f == outputFormat {
+			return nil
+		}
+	}
+	return fmt.Errorf("unsupported output format %q (expected one of: %s)", outputFormat, strings.Join(formats, ", "))
+}
+
+func printJSON(v interface{}) error {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+func printTable(header []string, rows [][]string) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, strings.Join(header, "\t"))
+	for _, row := range rows {
+		fmt.Fprintln(w, strings.Join(row, "\t"))
+	}
+	return w.Flush()
+}
+
+func printCSV(header []string, rows [][]string) error {
+	w := csv.NewWriter(os.Stdout)
+	if err := w.Write(header); err != nil {
+		return err
+	}
+	if err := w.WriteAll(rows); err != nil {
+		return err
+	}
+	return w.Error()
+}
+
+// printRows prints tabular data as a table or as CSV depending on --output
+func printRows(header []string, rows [][]string) error {
+	if outputFormat == outputCSV {
+		return printCSV(header, rows)
+	}
+	return printTable(header, rows)
+}
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// confirm asks a yes/no question on the terminal, defaulting to no. With --no-prompt it fails without asking.
+func confirm(question string) (bool, error) {
+	if noPrompt {
+		return false, fmt.Errorf("%w (%s)", errNoPrompt, question)
+	}
+	fmt.Fprintf(os.Stderr, "%s [y/N] ", question)
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		return false, err
+	}
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes", nil
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"rsc.io/qr"
+)
+
+const (
+	qrAuto    = "auto"
+	qrUnicode = "unicode"
+	qrASCII   = "ascii"
+	qrNone    = "none"
+
+	// the QR spec asks for a 4 module wide light border around the code
+	qrQuietZone = 4
+)
+
+// printQR renders text as a QR code on w. unicode mode uses half blocks with explicit black and white ANSI colors,
+// so it scans on both light and dark terminals. ascii mode draws dark modules as ## and needs a light background.
+func printQR(w io.Writer, text string, mode string) error {
+	if mode == qrAuto {
+		mode = detectQRMode()
+	}
+	if mode == qrNone {
+		return nil
+	}
+
+	code, err := qr.Encode(text, qr.L)
+	if err != nil {
+		return err
+	}
+
+	var b strings.Builder
+	start, end := -qrQuietZone, code.Size+qrQuietZone
+	switch mode {
+	case qrUnicode:
+		for y := start; y < end; y += 2 {
+			for x := start; x < end; x++ {
+				// the upper half block is drawn in the foreground color, the lower half in the background color
+				fg, bg := 37, 47
+				if code.Black(x, y) {
+					fg = 30
+				}
+				switch {
+				case y+1 >= end:
+					bg = 49
+				case code.Black(x, y+1):
+					bg = 40
+				}
+				fmt.Fprintf(&b, "\x1b[%d;%dm▀", fg, bg)
+			}
+			b.WriteString("\x1b[0m\n")
+		}
+	case qrASCII:
+		for y := start; y < end; y++ {
+			for x := start; x < end; x++ {
+				if code.Black(x, y) {
+					b.WriteString("##")
+				} else {
+					b.WriteString("  ")
+				}
+			}
+			b.WriteString("\n")
+		}
+	default:
+		return fmt.Errorf("unknown QR code mode %q (expected one of: auto, unicode, ascii, none)", mode)
+	}
+	_, err = io.WriteString(w, b.String())
+	return err
+}
+
+// detectQRMode picks unicode rendering for UTF-8 capable terminals, ascii for other terminals,
+// and nothing at all when stderr is not a terminal
+func detectQRMode() string {
+	info, err := os.Stderr.Stat()
+	if err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		return qrNone
+	}
+	if os.Getenv("TERM") == "dumb" {
+		return qrASCII
+	}
+	for _, env := range []string{"LC_ALL", "LC_CTYPE", "LANG"} {
+		if value := os.Getenv(env); value != "" {
+			value = strings.ToUpper(value)
+			if strings.Contains(value, "UTF-8") || strings.Contains(value, "UTF8") {
+				return qrUnicode
+			}
+			return qrASCII
+		}
+	}
+	return qrASCII
+}
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/spf13/cobra"
+
+	"gitlab.com/signald/signald/internal/socket"
+)
+
+const replHelp = `commands:
+  /to <phone number or UUID>   send to a user
+  /group <group ID or title>   send to a group
+  /reply                       send to whoever sent the last incoming message
+  /groups                      list groups
+  /who                         show the current conversation
+  /help                        show this help
+  /quit                        exit
+any other line is sent as a message to the current conversation`
+
+var replCmd = &cobra.Command{
+	Use:   "repl",
+	Short: "interactive chat session for an account",
+	Long: `subscribe to an account and chat from the terminal. Incoming messages are printed as they arrive,
+and lines typed at the prompt are sent to the selected conversation.
+
+` + replHelp,
+	Args: cobra.NoArgs,
+	PreRunE: func(_ *cobra.Command, _ []string) error {
+		return requireAccount()
+	},
+	RunE: func(_ *cobra.Command, _ []string) error {
+		conn, err := dial()
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+
+		r := &repl{conn: conn}
+		incoming, stop := conn.Listen()
+		defer stop()
+
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		err = subscribe(ctx, conn, account)
+		cancel()
+		if err != nil {
+			return err
+		}
+
+		closed := make(chan struct{})
+		go func() {
+			r.printIncoming(incoming)
+			close(closed)
+		}()
+
+		r.println("subscribed to " + account + ", type /help for commands")
+		lines := make(chan string)
+		go func() {
+			scanner := bufio.NewScanner(os.Stdin)
+			for scanner.Scan() {
+				lines <- scanner.Text()
+			}
+			close(lines)
+		}()
+
+		for {
+			r.prompt()
+			select {
+			case <-closed:
+				return socket.ErrClosed
+			case line, ok := <-lines:
+				if !ok {
+					return nil
+				}
+				if quit := r.handleLine(strings.TrimSpace(line)); quit {
+					return nil
+				}
+			}
+		}
+	},
+}
+
+type repl struct {
+	conn *socket.Conn
+
+	lock       sync.Mutex
+	target     conversation
+	lastSender conversation
+}
+
+// conversation is either a user or a group
+type conversation struct {
+	address *JsonAddress
+	groupID string
+	label   string
+}
+
+func (c conversation) empty() bool {
+	return c.address == nil && c.groupID == ""
+}
+
+func (r *repl) println(s string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	fmt.Println(s)
+}
+
+func (r *repl) prompt() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.target.empty() {
+		fmt.Print("> ")
+	} else {
+		fmt.Printf("%s> ", r.target.label)
+	}
+}
+
+func (r *repl) printIncoming(incoming <-chan socket.Response) {
+	for msg := range incoming {
+		switch msg.Type {
+		case "message":
+			var env JsonMessageEnvelope
+			if err := json.Unmarshal(msg.Data, &env); err != nil {
+				r.println("error decoding incoming message: " + err.Error())
+				continue
+			}
+			if env.DataMessage != nil && env.Source != nil {
+				from := conversation{address: env.Source, label: env.Source.String()}
+				if id := env.DataMessage.GroupID(); id != "" {
+					from = conversation{groupID: id, label: strings.TrimPrefix(groupSuffix(env.DataMessage), " in ")}
+				}
+				r.lock.Lock()
+				r.lastSender = from
+				r.lock.Unlock()
+			}
+			r.println("\n" + formatEnvelope(env))
+		case "listen_started", "listen_stopped":
+			r.println("\n[" + strings.Replace(msg.Type, "_", " ", 1) + "]")
+		}
+	}
+}
+
+// handleLine processes one line of input, returning true if the session should end
+func (r *repl) handleLine(line string) bool {
+	if line == "" {
+		return false
+	}
+	if !strings.HasPrefix(line, "/") {
+		r.send(line)
+		return false
+	}
+
+	fields := strings.Fields(line)
+	arg := strings.TrimSpace(strings.TrimPrefix(line, fields[0]))
+	switch fields[0] {
+	case "/quit", "/exit":
+		return true
+	case "/help":
+		r.println(replHelp)
+	case "/who":
+		r.lock.Lock()
+		target := r.target
+		r.lock.Unlock()
+		if target.empty() {
+			r.println("no conversation selected")
+		} else {
+			r.println("sending to " + target.label)
+		}
+	case "/to":
+		address, err := parseAddress(arg)
+		if err != nil {
+			r.println(err.Error())
+			return false
+		}
+		r.setTarget(conversation{address: address, label: address.String()})
+	case "/reply":
+		r.lock.Lock()
+		last := r.lastSender
+		r.lock.Unlock()
+		if last.empty() {
+			r.println("no messages received yet")
+			return false
+		}
+		r.setTarget(last)
+	case "/group":
+		group, err := r.findGroup(arg)
+		if err != nil {
+			r.println(err.Error())
+			return false
+		}
+		r.setTarget(group)
+	case "/groups":
+		groups, err := r.listGroups()
+		if err != nil {
+			r.println(describeError(err).Error())
+			return false
+		}
+		lines := []string{}
+		for _, g := range groups {
+			lines = append(lines, g.groupID+"  "+g.label)
+		}
+		r.println(strings.Join(lines, "\n"))
+	default:
+		r.println("unknown command " + fields[0] + ", type /help for commands")
+	}
+	return false
+}
+
+func (r *repl) setTarget(c conversation) {
+	r.lock.Lock()
+	r.target = c
+	r.lock.Unlock()
+}
+
+func (r *repl) send(body string) {
+	r.lock.Lock()
+	target := r.target
+	r.lock.Unlock()
+	if target.empty() {
+		r.println("no conversation selected, use /to or /group first")
+		return
+	}
+
+	req := SendRequest{Username: account, MessageBody: body, RecipientAddress: target.address, RecipientGroupID: target.groupID}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	var resp SendResponse
+	if err := r.conn.RequestInto(ctx, "v1", "send", req, &resp); err != nil {
+		r.println("error sending: " + describeError(err).Error())
+		return
+	}
+	for _, result := range resp.Results {
+		if result.Success == nil {
+			r.println("  " + result.Address.String() + ": " + result.Status())
+		}
+	}
+}
+
+func (r *repl) listGroups() ([]conversation, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	var list GroupList
+	if err := r.conn.RequestInto(ctx, "v1", "list_groups", ListGroupsRequest{Account: account}, &list); err != nil {
+		return nil, err
+	}
+	groups := []conversation{}
+	for _, g := range list.Groups {
+		groups = append(groups, conversation{groupID: g.ID, label: g.Title})
+	}
+	for _, g := range list.LegacyGroups {
+		groups = append(groups, conversation{groupID: g.GroupID, label: g.Name})
+	}
+	return groups, nil
+}
+
+// findGroup matches query against group IDs, then titles, then title substrings
+func (r *repl) findGroup(query string) (conversation, error) {
+	if query == "" {
+		return conversation{}, errors.New("usage: /group <group ID or title>")
+	}
+	groups, err := r.listGroups()
+	if err != nil {
+		return conversation{}, describeError(err)
+	}
+	lower := strings.ToLower(query)
+	matchers := []func(conversation) bool{
+		func(g conversation) bool { return g.groupID == query },
+		func(g conversation) bool { return strings.ToLower(g.label) == lower },
+		func(g conversation) bool { return strings.Contains(strings.ToLower(g.label), lower) },
+	}
+	for _, matches := range matchers {
+		found := []conversation{}
+		for _, g := range groups {
+			if matches(g) {
+				found = append(found, g)
+			}
+		}
+		if len(found) == 1 {
+			return found[0], nil
+		}
+		if len(found) > 1 {
+			return conversation{}, fmt.Errorf("%d groups match %q, use the group ID instead", len(found), query)
+		}
+	}
+	return conversation{}, fmt.Errorf("no group matches %q", query)
+}
+
+func init() {
+	addAccountFlag(replCmd)
+	rootCmd.AddCommand(replCmd)
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"gitlab.com/signald/signald/internal/socket"
+)
+
+var envelopeKinds = []string{"data", "sync", "receipt", "typing", "call", "other"}
+
+var (
+	tailTypes  []string
+	tailSender string
+	tailGroup  string
+)
+
+var tailCmd = &cobra.Command{
+	Use:   "tail",
+	Short: "print incoming messages for an account as they arrive",
+	Long: `subscribe to an account and print every incoming envelope until interrupted.
+
+With --output json each envelope is printed exactly as signald sent it, one per line, which makes the
+output suitable for piping into jq or another program. The default table output prints one compact,
+human readable line per envelope.
+
+--type limits output to envelopes carrying one of: ` + strings.Join(envelopeKinds, ", ") + `.`,
+	Args: cobra.NoArgs,
+	PreRunE: func(_ *cobra.Command, _ []string) error {
+		if err := requireAccount(); err != nil {
+			return err
+		}
+		for _, t := range tailTypes {
+			if !stringInSlice(t, envelopeKinds) {
+				return fmt.Errorf("unknown envelope type %q (expected one of: %s)", t, strings.Join(envelopeKinds, ", "))
+			}
+		}
+		return checkOutputFormat(outputTable, outputJSON)
+	},
+	RunE: func(_ *cobra.Command, _ []string) error {
+		var sender *JsonAddress
+		if tailSender != "" {
+			var err error
+			if sender, err = parseAddress(tailSender); err != nil {
+				return err
+			}
+		}
+
+		conn, err := dial()
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+
+		incoming, stop := conn.Listen()
+		defer stop()
+
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		err = subscribe(ctx, conn, account)
+		cancel()
+		if err != nil {
+			return err
+		}
+
+		for msg := range incoming {
+			switch msg.Type {
+			case "message":
+				var env JsonMessageEnvelope
+				if err := json.Unmarshal(msg.Data, &env); err != nil {
+					fmt.Fprintln(os.Stderr, "error decoding incoming message:", err)
+					continue
+				}
+				if !tailMatches(env, sender) {
+					continue
+				}
+				if outputFormat == outputJSON {
+					var line bytes.Buffer
+					if err := json.Compact(&line, msg.Data); err != nil {
+						return err
+					}
+					line.WriteByte('\n')
+					if _, err := line.WriteTo(os.Stdout); err != nil {
+						return err
+					}
+				} else {
+					fmt.Println(formatEnvelope(env))
+				}
+			case "listen_started", "listen_stopped":
+				// keep stdout a clean stream of envelopes, connection state goes to stderr
+				fmt.Fprintln(os.Stderr, "["+strings.Replace(msg.Type, "_", " ", 1)+"]")
+			}
+		}
+		return socket.ErrClosed
+	},
+}
+
+func init() {
+	addAccountFlag(tailCmd)
+	tailCmd.Flags().StringSliceVar(&tailTypes, "type", nil, "only print envelopes of these types (repeatable)")
+	tailCmd.Flags().StringVar(&tailSender, "sender", "", "only print envelopes from this phone number or UUID")
+	tailCmd.Flags().StringVarP(&tailGroup, "group", "g", "", "only print messages in this group")
+	tailCmd.RegisterFlagCompletionFunc("type", func(_ *cobra.Command, _ []string, _ string) ([]string, cobra.ShellCompDirective) {
+		return envelopeKinds, cobra.ShellCompDirectiveNoFileComp
+	})
+	tailCmd.RegisterFlagCompletionFunc("group", completeGroups)
+	rootCmd.AddCommand(tailCmd)
+}
+
+// tailMatches applies the --type, --sender and --group filters to an envelope
+func tailMatches(env JsonMessageEnvelope, sender *JsonAddress) bool {
+	if len(tailTypes) > 0 && !stringInSlice(envelopeKind(env), tailTypes) {
+		return false
+	}
+	if sender != nil && (env.Source == nil || !sameAddress(sender, env.Source)) {
+		return false
+	}
+	if tailGroup != "" {
+		message := env.DataMessage
+		if env.SyncMessage != nil && env.SyncMessage.Sent != nil {
+			message = env.SyncMessage.Sent.Message
+		}
+		if message == nil || message.GroupID() != tailGroup {
+			return false
+		}
+	}
+	return true
+}
+
+func stringInSlice(s string, list []string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,346 @@
+package main
+
+import "encoding/json"
+
+// the subset of the v1 protocol structures that signaldctl uses. See https://signald.org/protocol/ for full documentation
+
+type JsonAddress struct {
+	Number string `json:"number,omitempty"`
+	UUID   string `json:"uuid,omitempty"`
+	Relay  string `json:"relay,omitempty"`
+}
+
+func (a JsonAddress) String() string {
+	switch {
+	case a.Number != "" && a.UUID != "":
+		return a.Number + " (" + a.UUID + ")"
+	case a.Number != "":
+		return a.Number
+	default:
+		return a.UUID
+	}
+}
+
+type JsonAttachment struct {
+	ContentType    string `json:"contentType,omitempty"`
+	ID             string `json:"id,omitempty"`
+	Size           int    `json:"size,omitempty"`
+	StoredFilename string `json:"storedFilename,omitempty"`
+	Filename       string `json:"filename,omitempty"`
+	CustomFilename string `json:"customFilename,omitempty"`
+	Caption        string `json:"caption,omitempty"`
+}
+
+type JsonMention struct {
+	UUID   string `json:"uuid"`
+	Start  int    `json:"start,omitempty"`
+	Length int    `json:"length"`
+}
+
+type JsonQuote struct {
+	ID     int64        `json:"id"`
+	Author *JsonAddress `json:"author,omitempty"`
+	Text   string       `json:"text,omitempty"`
+}
+
+type SendRequest struct {
+	Username         string           `json:"username"`
+	RecipientAddress *JsonAddress     `json:"recipientAddress,omitempty"`
+	RecipientGroupID string           `json:"recipientGroupId,omitempty"`
+	MessageBody      string           `json:"messageBody,omitempty"`
+	Attachments      []JsonAttachment `json:"attachments,omitempty"`
+	Quote            *JsonQuote       `json:"quote,omitempty"`
+	Mentions         []JsonMention    `json:"mentions,omitempty"`
+}
+
+type SendResponse struct {
+	Results   []JsonSendMessageResult `json:"results"`
+	Timestamp int64                   `json:"timestamp"`
+}
+
+type JsonSendMessageResult struct {
+	Address             JsonAddress `json:"address"`
+	Success             interface{} `json:"success,omitempty"`
+	NetworkFailure      bool        `json:"networkFailure,omitempty"`
+	UnregisteredFailure bool        `json:"unregisteredFailure,omitempty"`
+	IdentityFailure     string      `json:"identityFailure,omitempty"`
+}
+
+func (r JsonSendMessageResult) Status() string {
+	switch {
+	case r.Success != nil:
+		return "success"
+	case r.NetworkFailure:
+		return "network failure"
+	case r.UnregisteredFailure:
+		return "unregistered"
+	case r.IdentityFailure != "":
+		return "identity failure: " + r.IdentityFailure
+	default:
+		return "unknown"
+	}
+}
+
+type Account struct {
+	DeviceID  int          `json:"device_id"`
+	AccountID string       `json:"account_id"`
+	Address   *JsonAddress `json:"address,omitempty"`
+}
+
+type AccountList struct {
+	Accounts []Account `json:"accounts"`
+}
+
+type RegisterRequest struct {
+	Account string `json:"account"`
+	Voice   bool   `json:"voice,omitempty"`
+	Captcha string `json:"captcha,omitempty"`
+}
+
+type VerifyRequest struct {
+	Account string `json:"account"`
+	Code    string `json:"code"`
+}
+
+type LinkingURI struct {
+	URI       string `json:"uri"`
+	SessionID string `json:"session_id"`
+}
+
+type FinishLinkRequest struct {
+	DeviceName string `json:"device_name,omitempty"`
+	SessionID  string `json:"session_id"`
+}
+
+type DeleteAccountRequest struct {
+	Account string `json:"account"`
+	Server  bool   `json:"server,omitempty"`
+}
+
+type JsonGroupV2Info struct {
+	ID                  string              `json:"id"`
+	MasterKey           string              `json:"masterKey,omitempty"`
+	Revision            int                 `json:"revision"`
+	Title               string              `json:"title,omitempty"`
+	Description         string              `json:"description,omitempty"`
+	Avatar              string              `json:"avatar,omitempty"`
+	Timer               int                 `json:"timer,omitempty"`
+	Members             []JsonAddress       `json:"members,omitempty"`
+	PendingMembers      []JsonAddress       `json:"pendingMembers,omitempty"`
+	RequestingMembers   []JsonAddress       `json:"requestingMembers,omitempty"`
+	InviteLink          string              `json:"inviteLink,omitempty"`
+	AccessControl       *GroupAccessControl `json:"accessControl,omitempty"`
+	MemberDetail        []GroupMember       `json:"memberDetail,omitempty"`
+	PendingMemberDetail []GroupMember       `json:"pendingMemberDetail,omitempty"`
+}
+
+type JsonGroupInfo struct {
+	GroupID  string        `json:"groupId"`
+	Members  []JsonAddress `json:"members,omitempty"`
+	Name     string        `json:"name,omitempty"`
+	Type     string        `json:"type,omitempty"`
+	AvatarID int64         `json:"avatarId,omitempty"`
+}
+
+type GroupList struct {
+	Groups       []JsonGroupV2Info `json:"groups"`
+	LegacyGroups []JsonGroupInfo   `json:"legacyGroups,omitempty"`
+}
+
+// GroupInfo is returned by group modification requests, only one of V1 and V2 is set
+type GroupInfo struct {
+	V1 *JsonGroupInfo   `json:"v1,omitempty"`
+	V2 *JsonGroupV2Info `json:"v2,omitempty"`
+}
+
+type GroupMember struct {
+	UUID           string `json:"uuid"`
+	Role           string `json:"role,omitempty"`
+	JoinedRevision int    `json:"joined_revision,omitempty"`
+}
+
+type GroupAccessControl struct {
+	Link       string `json:"link,omitempty"`
+	Attributes string `json:"attributes,omitempty"`
+	Members    string `json:"members,omitempty"`
+}
+
+type ListGroupsRequest struct {
+	Account string `json:"account"`
+}
+
+type CreateGroupRequest struct {
+	Account    string        `json:"account"`
+	Title      string        `json:"title"`
+	Avatar     string        `json:"avatar,omitempty"`
+	Members    []JsonAddress `json:"members"`
+	MemberRole string        `json:"member_role,omitempty"`
+	Timer      int           `json:"timer,omitempty"`
+}
+
+// UpdateGroupRequest may only carry one modification at a time
+type UpdateGroupRequest struct {
+	Account             string              `json:"account"`
+	GroupID             string              `json:"groupID"`
+	Title               string              `json:"title,omitempty"`
+	Avatar              string              `json:"avatar,omitempty"`
+	UpdateTimer         *int                `json:"updateTimer,omitempty"`
+	AddMembers          []JsonAddress       `json:"addMembers,omitempty"`
+	RemoveMembers       []JsonAddress       `json:"removeMembers,omitempty"`
+	UpdateRole          *GroupMember        `json:"updateRole,omitempty"`
+	UpdateAccessControl *GroupAccessControl `json:"updateAccessControl,omitempty"`
+	ResetLink           bool                `json:"resetLink,omitempty"`
+}
+
+type LeaveGroupRequest struct {
+	Account string `json:"account"`
+	GroupID string `json:"groupID"`
+}
+
+type Profile struct {
+	Name           string        `json:"name,omitempty"`
+	ProfileName    string        `json:"profile_name,omitempty"`
+	Avatar         string        `json:"avatar,omitempty"`
+	Address        *JsonAddress  `json:"address,omitempty"`
+	Capabilities   *Capabilities `json:"capabilities,omitempty"`
+	Color          string        `json:"color,omitempty"`
+	InboxPosition  *int          `json:"inbox_position,omitempty"`
+	ExpirationTime int           `json:"expiration_time,omitempty"`
+	About          string        `json:"about,omitempty"`
+	Emoji          string        `json:"emoji,omitempty"`
+}
+
+type Capabilities struct {
+	GV2          bool `json:"gv2"`
+	Storage      bool `json:"storage"`
+	GV1Migration bool `json:"gv1-migration"`
+}
+
+type ProfileList struct {
+	Profiles []Profile `json:"profiles"`
+}
+
+type ListContactsRequest struct {
+	Account string `json:"account"`
+	Async   bool   `json:"async,omitempty"`
+}
+
+type GetProfileRequest struct {
+	Account string      `json:"account"`
+	Address JsonAddress `json:"address"`
+	Async   bool        `json:"async,omitempty"`
+}
+
+type IdentityKey struct {
+	SafetyNumber string `json:"safety_number"`
+	QRCodeData   string `json:"qr_code_data,omitempty"`
+	TrustLevel   string `json:"trust_level"`
+	Added        int64  `json:"added"`
+}
+
+type IdentityKeyList struct {
+	Address    JsonAddress   `json:"address"`
+	Identities []IdentityKey `json:"identities"`
+}
+
+type AllIdentityKeyList struct {
+	IdentityKeys []IdentityKeyList `json:"identity_keys"`
+}
+
+type GetAllIdentitiesRequest struct {
+	Account string `json:"account"`
+}
+
+type GetIdentitiesRequest struct {
+	Account string      `json:"account"`
+	Address JsonAddress `json:"address"`
+}
+
+type TrustRequest struct {
+	Account      string      `json:"account"`
+	Address      JsonAddress `json:"address"`
+	SafetyNumber string      `json:"safety_number,omitempty"`
+	QRCodeData   string      `json:"qr_code_data,omitempty"`
+	TrustLevel   string      `json:"trust_level,omitempty"`
+}
+
+type ResetSessionRequest struct {
+	Account string      `json:"account"`
+	Address JsonAddress `json:"address"`
+}
+
+type JsonMessageEnvelope struct {
+	Username             string              `json:"username"`
+	UUID                 string              `json:"uuid,omitempty"`
+	Source               *JsonAddress        `json:"source,omitempty"`
+	SourceDevice         int                 `json:"sourceDevice,omitempty"`
+	Type                 string              `json:"type,omitempty"`
+	Timestamp            int64               `json:"timestamp,omitempty"`
+	ServerTimestamp      int64               `json:"serverTimestamp,omitempty"`
+	IsUnidentifiedSender bool                `json:"isUnidentifiedSender,omitempty"`
+	DataMessage          *JsonDataMessage    `json:"dataMessage,omitempty"`
+	SyncMessage          *JsonSyncMessage    `json:"syncMessage,omitempty"`
+	Receipt              *JsonReceiptMessage `json:"receipt,omitempty"`
+	Typing               *JsonTypingMessage  `json:"typing,omitempty"`
+	CallMessage          json.RawMessage     `json:"callMessage,omitempty"`
+}
+
+type JsonDataMessage struct {
+	Timestamp        int64            `json:"timestamp"`
+	Attachments      []JsonAttachment `json:"attachments,omitempty"`
+	Body             string           `json:"body,omitempty"`
+	Group            *JsonGroupInfo   `json:"group,omitempty"`
+	GroupV2          *JsonGroupV2Info `json:"groupV2,omitempty"`
+	EndSession       bool             `json:"endSession,omitempty"`
+	ExpiresInSeconds int              `json:"expiresInSeconds,omitempty"`
+	Quote            *JsonQuote       `json:"quote,omitempty"`
+	Reaction         *JsonReaction    `json:"reaction,omitempty"`
+	Mentions         []JsonMention    `json:"mentions,omitempty"`
+}
+
+// GroupID returns the ID of the group the message was sent to, or an empty string for direct messages
+func (m *JsonDataMessage) GroupID() string {
+	switch {
+	case m == nil:
+		return ""
+	case m.GroupV2 != nil:
+		return m.GroupV2.ID
+	case m.Group != nil:
+		return m.Group.GroupID
+	default:
+		return ""
+	}
+}
+
+type JsonReaction struct {
+	Emoji               string       `json:"emoji"`
+	Remove              bool         `json:"remove,omitempty"`
+	TargetAuthor        *JsonAddress `json:"targetAuthor,omitempty"`
+	TargetSentTimestamp int64        `json:"targetSentTimestamp"`
+}
+
+type JsonSyncMessage struct {
+	Sent *JsonSentTranscriptMessage `json:"sent,omitempty"`
+}
+
+type JsonSentTranscriptMessage struct {
+	Destination *JsonAddress     `json:"destination,omitempty"`
+	Timestamp   int64            `json:"timestamp"`
+	Message     *JsonDataMessage `json:"message,omitempty"`
+}
+
+type JsonReceiptMessage struct {
+	Type       string  `json:"type"`
+	Timestamps []int64 `json:"timestamps"`
+	When       int64   `json:"when"`
+}
+
+type JsonTypingMessage struct {
+	Action    string `json:"action"`
+	Timestamp int64  `json:"timestamp"`
+	GroupID   string `json:"groupId,omitempty"`
+}
+
+type SubscribeRequest struct {
+	Username string `json:"username"`
+}
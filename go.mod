@@ -2,4 +2,9 @@ module gitlab.com/signald/signald
 
 go 1.15
 
-require github.com/logrusorgru/aurora/v3 v3.0.0
+require (
+	github.com/logrusorgru/aurora/v3 v3.0.0
+	github.com/spf13/cobra v1.8.1
+	gopkg.in/yaml.v3 v3.0.1
+	rsc.io/qr v0.2.0
+)
@@ -0,0 +1,126 @@
+// Package capture reads and writes recordings of signald socket traffic. A capture is newline delimited JSON,
+// one Record per line read from or written to the socket, in the order they were seen.
+package capture
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	FromClient  = "client"
+	FromSignald = "signald"
+)
+
+type Record struct {
+	Time time.Time `json:"time"`
+	// Session numbers the client connections in a capture, starting at 1
+	Session int `json:"session"`
+	// From is FromClient for requests and FromSignald for everything signald sent
+	From    string          `json:"from"`
+	Message json.RawMessage `json:"message,omitempty"`
+	// Text holds lines that were not valid JSON, exactly as they were sent
+	Text string `json:"text,omitempty"`
+}
+
+// NewRecord wraps a line seen on the socket, without its trailing newline
+func NewRecord(session int, from string, line []byte) Record {
+	r := Record{Time: time.Now().UTC(), Session: session, From: from}
+	if json.Valid(line) {
+		r.Message = append(json.RawMessage{}, line...)
+	} else {
+		r.Text = string(line)
+	}
+	return r
+}
+
+// Line returns the record as it was sent on the socket, without a trailing newline
+func (r Record) Line() []byte {
+	if len(r.Message) > 0 {
+		return r.Message
+	}
+	return []byte(r.Text)
+}
+
+// Envelope decodes the fields every signald message has. ok is false for lines that are not JSON objects.
+func (r Record) Envelope() (e Envelope, ok bool) {
+	if len(r.Message) == 0 {
+		return e, false
+	}
+	return e, json.Unmarshal(r.Message, &e) == nil
+}
+
+// Envelope holds the routing fields of a request or reply
+type Envelope struct {
+	ID        string `json:"id"`
+	Type      string `json:"type"`
+	Version   string `json:"version"`
+	ErrorType string `json:"error_type"`
+}
+
+// Writer appends records to a capture, it is safe for concurrent use
+type Writer struct {
+	lock    sync.Mutex
+	encoder *json.Encoder
+	redact  *Redactor
+}
+
+// NewWriter writes records to w. If redact is not nil, messages are redacted before they are written.
+func NewWriter(w io.Writer, redact *Redactor) *Writer {
+	return &Writer{encoder: json.NewEncoder(w), redact: redact}
+}
+
+func (w *Writer) Write(r Record) error {
+	if w.redact != nil {
+		r = w.redact.Record(r)
+	}
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return w.encoder.Encode(r)
+}
+
+// Read calls fn for every record in a capture
+func Read(in io.Reader, fn func(Record) error) error {
+	reader := bufio.NewReader(in)
+	for n := 1; ; n++ {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 && string(line) != "\n" {
+			var r Record
+			if jsonErr := json.Unmarshal(line, &r); jsonErr != nil {
+				return fmt.Errorf("line %d: %v", n, jsonErr)
+			}
+			if fnErr := fn(r); fnErr != nil {
+				return fnErr
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// ReadFile loads a whole capture
+func ReadFile(path string) ([]Record, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	records := []Record{}
+	err = Read(f, func(r Record) error {
+		records = append(records, r)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %v", path, err)
+	}
+	return records, nil
+}
@@ -0,0 +1,93 @@
+package capture
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+)
+
+// DefaultRedactFields are the fields that identify people, or carry message content or key material
+var DefaultRedactFields = []string{
+	// addresses and accounts
+	"number", "uuid", "account", "username", "account_id", "e164", "source", "destination",
+	// groups
+	"groupId", "group", "group_id", "recipientGroupId", "title", "description", "groupInviteLink",
+	// content
+	"messageBody", "body", "text", "caption", "emoji",
+	// profiles
+	"name", "profile_name", "given_name", "family_name", "about", "about_emoji", "avatar", "color",
+	// attachments
+	"filename", "storedFilename", "customFilename", "blurhash", "preview",
+	// secrets
+	"safety_number", "qr_code_data", "key", "digest", "profileKey", "profile_key", "masterKey", "uri",
+	"captcha", "code", "pin", "session_id",
+}
+
+// Redactor replaces the string values of sensitive fields with a keyed hash. Equal values redact to equal
+// placeholders, so a redacted capture still shows which requests talk about the same user or group.
+type Redactor struct {
+	fields map[string]bool
+	key    []byte
+}
+
+// NewRedactor redacts the named fields anywhere in a message. The key makes placeholders unguessable,
+// without it anyone could hash a phone number and look for it in the capture.
+func NewRedactor(fields []string, key []byte) *Redactor {
+	r := &Redactor{fields: map[string]bool{}, key: key}
+	for _, f := range fields {
+		r.fields[f] = true
+	}
+	return r
+}
+
+// Record returns a copy of record with its message redacted. Lines that are not JSON are replaced as a whole.
+func (r *Redactor) Record(record Record) Record {
+	if record.Text != "" {
+		record.Text = r.placeholder(record.Text)
+	}
+	if len(record.Message) > 0 {
+		record.Message = r.Message(record.Message)
+	}
+	return record
+}
+
+// Message redacts a single JSON message
+func (r *Redactor) Message(message json.RawMessage) json.RawMessage {
+	decoder := json.NewDecoder(bytes.NewReader(message))
+	decoder.UseNumber()
+	var v interface{}
+	if err := decoder.Decode(&v); err != nil {
+		return json.RawMessage(`"` + r.placeholder(string(message)) + `"`)
+	}
+	b, err := json.Marshal(r.walk(v, false))
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+func (r *Redactor) walk(v interface{}, redact bool) interface{} {
+	switch value := v.(type) {
+	case map[string]interface{}:
+		for k, child := range value {
+			value[k] = r.walk(child, redact || r.fields[k])
+		}
+	case []interface{}:
+		for i, child := range value {
+			value[i] = r.walk(child, redact)
+		}
+	case string:
+		if redact {
+			return r.placeholder(value)
+		}
+	}
+	return v
+}
+
+func (r *Redactor) placeholder(s string) string {
+	mac := hmac.New(sha256.New, r.key)
+	mac.Write([]byte(s))
+	return "redacted-" + hex.EncodeToString(mac.Sum(nil))[:12]
+}
@@ -0,0 +1,88 @@
+package capture
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestRedactorMessage(t *testing.T) {
+	r := NewRedactor(DefaultRedactFields, []byte("key"))
+	number := r.placeholder("+12024561414")
+	body := r.placeholder("hello")
+	tests := []struct {
+		name    string
+		message string
+		want    string
+	}{
+		{
+			"top level and nested fields",
+			`{"id":"1","type":"send","username":"+12024561414","recipientAddress":{"number":"+12024561414"},"messageBody":"hello"}`,
+			`{"id":"1","messageBody":"` + body + `","recipientAddress":{"number":"` + number + `"},"type":"send","username":"` + number + `"}`,
+		},
+		{
+			"everything under a redacted field",
+			`{"source":{"number":"+12024561414","relay":"x"}}`,
+			`{"source":{"number":"` + number + `","relay":"` + r.placeholder("x") + `"}}`,
+		},
+		{
+			"lists",
+			`{"members":[{"uuid":"u1"},{"uuid":"u2"}],"tags":["kept"]}`,
+			`{"members":[{"uuid":"` + r.placeholder("u1") + `"},{"uuid":"` + r.placeholder("u2") + `"}],"tags":["kept"]}`,
+		},
+		{
+			"numbers and booleans are kept exactly",
+			`{"timestamp":1600000000000123456,"number":5,"code":true,"size":1.50}`,
+			`{"code":true,"number":5,"size":1.50,"timestamp":1600000000000123456}`,
+		},
+		{
+			"not JSON",
+			`not json`,
+			`"` + r.placeholder("not json") + `"`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := string(r.Message(json.RawMessage(test.message))); got != test.want {
+				t.Errorf("got      %s\nexpected %s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestRedactorPlaceholders(t *testing.T) {
+	a := NewRedactor([]string{"number"}, []byte("one key"))
+	b := NewRedactor([]string{"number"}, []byte("another key"))
+	if a.placeholder("+12024561414") != a.placeholder("+12024561414") {
+		t.Error("equal values should redact to equal placeholders")
+	}
+	if a.placeholder("+12024561414") == a.placeholder("+12024561111") {
+		t.Error("different values should redact to different placeholders")
+	}
+	if a.placeholder("+12024561414") == b.placeholder("+12024561414") {
+		t.Error("placeholders should depend on the key")
+	}
+	if p := a.placeholder("+12024561414"); !strings.HasPrefix(p, "redacted-") || strings.Contains(p, "2024561414") {
+		t.Errorf("unexpected placeholder %q", p)
+	}
+}
+
+func TestRedactorRecord(t *testing.T) {
+	r := NewRedactor([]string{"number"}, []byte("key"))
+	text := r.Record(NewRecord(1, FromClient, []byte("+12024561414 not json")))
+	if text.Text != r.placeholder("+12024561414 not json") || len(text.Message) != 0 {
+		t.Errorf("non-JSON line was not replaced: %+v", text)
+	}
+
+	original := NewRecord(2, FromSignald, []byte(`{"number":"+12024561414"}`))
+	redacted := r.Record(original)
+	if string(original.Message) != `{"number":"+12024561414"}` {
+		t.Errorf("Record modified its argument: %s", original.Message)
+	}
+	if redacted.Session != 2 || redacted.From != FromSignald || !redacted.Time.Equal(original.Time) {
+		t.Errorf("Record changed more than the message: %+v", redacted)
+	}
+	if strings.Contains(string(redacted.Message), "2024561414") {
+		t.Errorf("number was not redacted: %s", redacted.Message)
+	}
+}
@@ -0,0 +1,160 @@
+package protocol
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Decode reads a protocol document, or several concatenated ones which are merged in order. Types and actions
+// are decoded one at a time rather than the document as a whole, and the strings that repeat throughout it (versions,
+// type names, docs) are interned, so large documents are held in memory once.
+func Decode(r io.Reader) (*Protocol, error) {
+	decoder := json.NewDecoder(bufio.NewReaderSize(r, 64*1024))
+	p := &Protocol{Types: map[string]map[string]*Type{}, Actions: map[string]map[string]*Action{}}
+	in := interner{}
+
+	for {
+		if err := expectDelim(decoder, '{'); err == io.EOF {
+			return p, nil
+		} else if err != nil {
+			return nil, err
+		}
+		for decoder.More() {
+			key, err := decoder.Token()
+			if err != nil {
+				return nil, err
+			}
+			if err := decodeTopLevel(decoder, in, p, fmt.Sprint(key)); err != nil {
+				return nil, fmt.Errorf("error decoding %v: %v", key, err)
+			}
+		}
+		if err := expectDelim(decoder, '}'); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// decodeTopLevel decodes the value of one key of a protocol document into p. Keys are matched the way encoding/json
+// matches them to struct fields.
+func decodeTopLevel(decoder *json.Decoder, in interner, p *Protocol, key string) error {
+	switch {
+	case strings.EqualFold(key, "doc_version"):
+		return decoder.Decode(&p.DocVersion)
+	case strings.EqualFold(key, "version"):
+		return decoder.Decode(&p.Version)
+	case strings.EqualFold(key, "info"):
+		return decoder.Decode(&p.Info)
+	case strings.EqualFold(key, "types"):
+		return decodeVersioned(decoder, in, func(version, name string) error {
+			var t Type
+			if err := decoder.Decode(&t); err != nil {
+				return err
+			}
+			in.internType(&t)
+			if p.Types[version] == nil {
+				p.Types[version] = map[string]*Type{}
+			}
+			p.Types[version][name] = &t
+			return nil
+		})
+	case strings.EqualFold(key, "actions"):
+		return decodeVersioned(decoder, in, func(version, name string) error {
+			var a Action
+			if err := decoder.Decode(&a); err != nil {
+				return err
+			}
+			in.internAction(&a)
+			if p.Actions[version] == nil {
+				p.Actions[version] = map[string]*Action{}
+			}
+			p.Actions[version][name] = &a
+			return nil
+		})
+	default:
+		var skip json.RawMessage
+		return decoder.Decode(&skip)
+	}
+}
+
+// decodeVersioned walks an object of versions holding objects of named values, calling decode for each value
+func decodeVersioned(decoder *json.Decoder, in interner, decode func(version, name string) error) error {
+	if err := expectDelim(decoder, '{'); err != nil {
+		return err
+	}
+	for decoder.More() {
+		version, err := decoder.Token()
+		if err != nil {
+			return err
+		}
+		if err := expectDelim(decoder, '{'); err != nil {
+			return err
+		}
+		for decoder.More() {
+			name, err := decoder.Token()
+			if err != nil {
+				return err
+			}
+			if err := decode(in.intern(fmt.Sprint(version)), in.intern(fmt.Sprint(name))); err != nil {
+				return err
+			}
+		}
+		if err := expectDelim(decoder, '}'); err != nil {
+			return err
+		}
+	}
+	return expectDelim(decoder, '}')
+}
+
+func expectDelim(decoder *json.Decoder, delim json.Delim) error {
+	token, err := decoder.Token()
+	if err != nil {
+		return err
+	}
+	if d, ok := token.(json.Delim); !ok || d != delim {
+		return fmt.Errorf("expected %s, got %v", delim, token)
+	}
+	return nil
+}
+
+type interner map[string]string
+
+func (in interner) intern(s string) string {
+	if interned, ok := in[s]; ok {
+		return interned
+	}
+	in[s] = s
+	return s
+}
+
+func (in interner) internType(t *Type) {
+	t.Doc = in.intern(t.Doc)
+	fields := make(map[string]*DataType, len(t.Fields))
+	for name, f := range t.Fields {
+		in.internDataType(f)
+		fields[in.intern(name)] = f
+	}
+	t.Fields = fields
+}
+
+func (in interner) internAction(a *Action) {
+	a.FnName = in.intern(a.FnName)
+	a.Request = in.intern(a.Request)
+	a.Response = in.intern(a.Response)
+	a.Doc = in.intern(a.Doc)
+	for _, f := range a.RequestFields {
+		in.internDataType(f)
+	}
+}
+
+func (in interner) internDataType(f *DataType) {
+	if f == nil {
+		return
+	}
+	f.Type = in.intern(f.Type)
+	f.Version = in.intern(f.Version)
+	f.Doc = in.intern(f.Doc)
+	f.Example = in.intern(f.Example)
+}
@@ -0,0 +1,54 @@
+package protocol
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDecode(t *testing.T) {
+	p, err := Decode(strings.NewReader(`{
+		"Doc_Version": "v1",
+		"version": {"name": "signald", "version": "0.15.0"},
+		"unknown": [1, {"nested": true}],
+		"types": {"v1": {"A": {"fields": {"x": {"type": "String", "list": true}}, "doc": "a"}}},
+		"actions": {"v1": {"send": {"request": "A", "response": "B"}}}
+	}
+	{
+		"types": {"v1": {"B": {"fields": {}}}, "v0": {"C": {}}},
+		"actions": {"v1": {"send": {"request": "A", "response": "A"}}}
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if p.DocVersion != "v1" || p.Version.Version != "0.15.0" {
+		t.Errorf("top level fields not decoded: %q %+v", p.DocVersion, p.Version)
+	}
+	if f := p.Types["v1"]["A"].Fields["x"]; f == nil || f.Type != "String" || !f.List {
+		t.Errorf("v1.A.x decoded as %+v", f)
+	}
+	if p.Types["v1"]["B"] == nil || p.Types["v0"]["C"] == nil {
+		t.Error("types from the second document were not merged in")
+	}
+	if p.Actions["v1"]["send"].Response != "A" {
+		t.Errorf("later documents should replace actions, got response %q", p.Actions["v1"]["send"].Response)
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		doc  string
+	}{
+		{"not an object", `[]`},
+		{"truncated", `{"types": {"v1": {"A": {`},
+		{"types not an object", `{"types": []}`},
+		{"version not an object", `{"types": {"v1": []}}`},
+		{"bad type", `{"types": {"v1": {"A": {"fields": []}}}}`},
+	}
+	for _, tt := range tests {
+		if _, err := Decode(strings.NewReader(tt.doc)); err == nil {
+			t.Errorf("%s: expected an error", tt.name)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+// Package protocol reads the protocol documentation signald generates with --dump-protocol (or the protocol request)
+// and checks JSON values against it.
+package protocol
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"gitlab.com/signald/signald/internal/socket"
+)
+
+type Protocol struct {
+	DocVersion string `json:"doc_version"`
+	Version    struct {
+		Name    string
+		Version string
+		Branch  string
+		Commit  string
+	}
+	Info    string
+	Types   map[string]map[string]*Type
+	Actions map[string]map[string]*Action
+}
+
+type Type struct {
+	Fields     map[string]*DataType
+	Doc        string
+	Deprecated bool
+}
+
+type DataType struct {
+	List     bool
+	Type     string
+	Version  string
+	Doc      string
+	Example  string
+	Required bool
+}
+
+type Action struct {
+	FnName        string
+	Request       string
+	RequestFields map[string]*DataType
+	Response      string
+	Doc           string
+	Deprecated    bool
+}
+
+// Load reads a protocol.json file
+func Load(path string) (*Protocol, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	p, err := Decode(f)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing %s: %v", path, err)
+	}
+	return p, nil
+}
+
+// Fetch asks a running signald for its protocol documentation
+func Fetch(ctx context.Context, conn *socket.Conn) (*Protocol, error) {
+	var p Protocol
+	if err := conn.RequestInto(ctx, "v1", "protocol", nil, &p); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// Action looks up an action, returning nil if the protocol does not document it
+func (p *Protocol) Action(version, name string) *Action {
+	return p.Actions[version][name]
+}
+
+// defaultVersions mirrors Request.getDefaultVersions() on the Java side: the version of requests that don't have one
+var defaultVersions = map[string]string{
+	"version":              "v1",
+	"protocol":             "v1",
+	"get_linked_devices":   "v1",
+	"remove_linked_device": "v1",
+	"accept_invitation":    "v1",
+	"approve_membership":   "v1",
+	"get_group":            "v1",
+	"join_group":           "v1",
+	"resolve_address":      "v1",
+	"create_group":         "v1",
+	"generate_linking_uri": "v1",
+	"finish_link":          "v1",
+	"delete_account":       "v1",
+	"typing":               "v1",
+	"reset_session":        "v1",
+	"request_sync":         "v1",
+}
+
+// RequestVersion is the version signald handles a request as, given the version field of the request
+func RequestVersion(version, action string) string {
+	if version != "" {
+		return version
+	}
+	if v, ok := defaultVersions[action]; ok {
+		return v
+	}
+	return "v0"
+}
+
+// Type looks up a type, returning nil if the protocol does not document it
+func (p *Protocol) Type(version, name string) *Type {
+	return p.Types[version][name]
+}
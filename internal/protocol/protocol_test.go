@@ -0,0 +1,54 @@
+package protocol
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestRequestVersion(t *testing.T) {
+	tests := []struct {
+		version string
+		action  string
+		want    string
+	}{
+		{"v1", "send", "v1"},
+		{"v0", "version", "v0"},
+		{"", "send", "v0"},
+		{"", "subscribe", "v0"},
+		{"", "version", "v1"},
+		{"", "generate_linking_uri", "v1"},
+		{"", "not_an_action", "v0"},
+	}
+	for _, test := range tests {
+		if got := RequestVersion(test.version, test.action); got != test.want {
+			t.Errorf("RequestVersion(%q, %q) = %q, expected %q", test.version, test.action, got, test.want)
+		}
+	}
+}
+
+func TestVisit(t *testing.T) {
+	p := loadTestProtocol(t)
+	value := `{
+		"username": "+12024561414",
+		"recipientAddress": {"number": "+12024561111"},
+		"mentions": [{"uuid": "a"}, {"number": "+1"}, "not an object"],
+		"timestamp": null,
+		"messageBody": "undocumented"
+	}`
+	var got []string
+	p.Visit("v1", "SendRequest", json.RawMessage(value), func(version, typeName, field string) {
+		got = append(got, version+"."+typeName+"."+field)
+	})
+	want := []string{
+		"v1.SendRequest.mentions",
+		"v1.JsonAddress.uuid",
+		"v1.JsonAddress.number",
+		"v1.SendRequest.recipientAddress",
+		"v1.JsonAddress.number",
+		"v1.SendRequest.username",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("visited %v\nexpected %v", got, want)
+	}
+}
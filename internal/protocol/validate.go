@@ -0,0 +1,167 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Problem is one way a JSON value does not match the protocol documentation
+type Problem struct {
+	Path    string
+	Message string
+}
+
+func (p Problem) String() string {
+	return p.Path + ": " + p.Message
+}
+
+// Java types that signald serializes as JSON primitives. Anything else without a version (Map, JsonNode, ...)
+// is not described by the documentation and accepted as is.
+var (
+	stringTypes  = map[string]bool{"String": true, "UUID": true, "char": true, "Character": true}
+	integerTypes = map[string]bool{"int": true, "Integer": true, "long": true, "Long": true, "short": true, "Short": true, "byte": true, "Byte": true}
+	floatTypes   = map[string]bool{"float": true, "Float": true, "double": true, "Double": true}
+	booleanTypes = map[string]bool{"boolean": true, "Boolean": true}
+)
+
+// Validate checks a JSON value against the named type. It reports values of the wrong JSON type, missing required
+// fields and fields that are not documented at all. Null is accepted anywhere.
+func (p *Protocol) Validate(version, typeName string, value json.RawMessage) []Problem {
+	v, err := decode(value)
+	if err != nil {
+		return []Problem{{Path: typeName, Message: "invalid JSON: " + err.Error()}}
+	}
+	return p.validateType(typeName, version, typeName, v)
+}
+
+func decode(value json.RawMessage) (interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader(value))
+	decoder.UseNumber()
+	var v interface{}
+	if err := decoder.Decode(&v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+func (p *Protocol) validateType(path, version, typeName string, v interface{}) []Problem {
+	if v == nil {
+		return nil
+	}
+	t := p.Type(version, typeName)
+	if t == nil {
+		return []Problem{{Path: path, Message: fmt.Sprintf("type %s.%s is not documented", version, typeName)}}
+	}
+	object, ok := v.(map[string]interface{})
+	if !ok {
+		return []Problem{{Path: path, Message: fmt.Sprintf("expected an object (%s.%s), got %s", version, typeName, jsonKind(v))}}
+	}
+
+	problems := []Problem{}
+	for _, name := range sortedKeys(object) {
+		field, ok := t.Fields[name]
+		if !ok {
+			problems = append(problems, Problem{Path: path + "." + name, Message: fmt.Sprintf("field is not documented in %s.%s", version, typeName)})
+			continue
+		}
+		problems = append(problems, p.validateField(path+"."+name, field, object[name])...)
+	}
+	for _, name := range sortedFieldNames(t.Fields) {
+		if _, present := object[name]; t.Fields[name].Required && !present {
+			problems = append(problems, Problem{Path: path + "." + name, Message: "required field is missing"})
+		}
+	}
+	return problems
+}
+
+func (p *Protocol) validateField(path string, field *DataType, v interface{}) []Problem {
+	if v == nil {
+		return nil
+	}
+	if field.List {
+		list, ok := v.([]interface{})
+		if !ok {
+			return []Problem{{Path: path, Message: fmt.Sprintf("expected a list of %s, got %s", field.Type, jsonKind(v))}}
+		}
+		problems := []Problem{}
+		for i, item := range list {
+			single := *field
+			single.List = false
+			problems = append(problems, p.validateField(fmt.Sprintf("%s[%d]", path, i), &single, item)...)
+		}
+		return problems
+	}
+
+	if field.Version != "" {
+		return p.validateType(path, field.Version, field.Type, v)
+	}
+
+	expected := ""
+	switch {
+	case stringTypes[field.Type]:
+		if _, ok := v.(string); !ok {
+			expected = "a string"
+		}
+	case integerTypes[field.Type]:
+		if n, ok := v.(json.Number); !ok {
+			expected = "an integer"
+		} else if _, err := n.Int64(); err != nil {
+			expected = "an integer"
+		}
+	case floatTypes[field.Type]:
+		if _, ok := v.(json.Number); !ok {
+			expected = "a number"
+		}
+	case booleanTypes[field.Type]:
+		if _, ok := v.(bool); !ok {
+			expected = "a boolean"
+		}
+	}
+	if expected != "" {
+		return []Problem{{Path: path, Message: fmt.Sprintf("expected %s (%s), got %s", expected, field.Type, jsonKind(v))}}
+	}
+	return nil
+}
+
+func jsonKind(v interface{}) string {
+	switch value := v.(type) {
+	case nil:
+		return "null"
+	case string:
+		return "a string"
+	case json.Number:
+		if strings.ContainsAny(value.String(), ".eE") {
+			return "a number"
+		}
+		return "an integer"
+	case bool:
+		return "a boolean"
+	case []interface{}:
+		return "a list"
+	case map[string]interface{}:
+		return "an object"
+	default:
+		return fmt.Sprintf("%T", v)
+	}
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func sortedFieldNames(m map[string]*DataType) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
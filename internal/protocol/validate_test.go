@@ -0,0 +1,105 @@
+package protocol
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+const testProtocol = `{
+	"doc_version": "v1",
+	"types": {
+		"v1": {
+			"JsonAddress": {"fields": {
+				"number": {"type": "String"},
+				"uuid": {"type": "UUID"}
+			}},
+			"SendRequest": {"fields": {
+				"username": {"type": "String", "required": true},
+				"recipientAddress": {"type": "JsonAddress", "version": "v1"},
+				"mentions": {"type": "JsonAddress", "version": "v1", "list": true},
+				"timestamp": {"type": "long"},
+				"ratio": {"type": "double"},
+				"isViewOnce": {"type": "Boolean"},
+				"extra": {"type": "Map"},
+				"tags": {"type": "String", "list": true}
+			}},
+			"Broken": {"fields": {
+				"missing": {"type": "Nowhere", "version": "v1"}
+			}}
+		}
+	}
+}`
+
+func loadTestProtocol(t *testing.T) *Protocol {
+	var p Protocol
+	if err := json.Unmarshal([]byte(testProtocol), &p); err != nil {
+		t.Fatal(err)
+	}
+	return &p
+}
+
+func TestValidate(t *testing.T) {
+	p := loadTestProtocol(t)
+	tests := []struct {
+		name     string
+		typeName string
+		value    string
+		want     []Problem
+	}{
+		{"valid", "SendRequest", `{"username": "+12024561414", "recipientAddress": {"number": "+12024561111"}, "timestamp": 1600000000000, "ratio": 0.5, "isViewOnce": false}`, nil},
+		{"null anywhere", "SendRequest", `{"username": "+12024561414", "recipientAddress": null, "timestamp": null}`, nil},
+		{"null value", "SendRequest", `null`, nil},
+		{"undocumented java types accepted", "SendRequest", `{"username": "a", "extra": {"anything": [1, "two"]}}`, nil},
+		{"integer for a double", "SendRequest", `{"username": "a", "ratio": 1}`, nil},
+		{"missing required", "SendRequest", `{"timestamp": 1}`, []Problem{
+			{Path: "SendRequest.username", Message: "required field is missing"},
+		}},
+		{"wrong primitives", "SendRequest", `{"username": 1, "timestamp": "now", "isViewOnce": "yes", "ratio": "half"}`, []Problem{
+			{Path: "SendRequest.isViewOnce", Message: "expected a boolean (Boolean), got a string"},
+			{Path: "SendRequest.ratio", Message: "expected a number (double), got a string"},
+			{Path: "SendRequest.timestamp", Message: "expected an integer (long), got a string"},
+			{Path: "SendRequest.username", Message: "expected a string (String), got an integer"},
+		}},
+		{"fraction for an integer", "SendRequest", `{"username": "a", "timestamp": 1.5}`, []Problem{
+			{Path: "SendRequest.timestamp", Message: "expected an integer (long), got a number"},
+		}},
+		{"undocumented field", "SendRequest", `{"username": "a", "messageBody": "hi"}`, []Problem{
+			{Path: "SendRequest.messageBody", Message: "field is not documented in v1.SendRequest"},
+		}},
+		{"nested type", "SendRequest", `{"username": "a", "recipientAddress": {"number": 5, "relay": "x"}}`, []Problem{
+			{Path: "SendRequest.recipientAddress.number", Message: "expected a string (String), got an integer"},
+			{Path: "SendRequest.recipientAddress.relay", Message: "field is not documented in v1.JsonAddress"},
+		}},
+		{"object where a type is expected", "SendRequest", `{"username": "a", "recipientAddress": "+12024561111"}`, []Problem{
+			{Path: "SendRequest.recipientAddress", Message: "expected an object (v1.JsonAddress), got a string"},
+		}},
+		{"lists", "SendRequest", `{"username": "a", "tags": ["ok", 2], "mentions": [{"uuid": "x"}, {"uuid": false}]}`, []Problem{
+			{Path: "SendRequest.mentions[1].uuid", Message: "expected a string (UUID), got a boolean"},
+			{Path: "SendRequest.tags[1]", Message: "expected a string (String), got an integer"},
+		}},
+		{"not a list", "SendRequest", `{"username": "a", "tags": "ok"}`, []Problem{
+			{Path: "SendRequest.tags", Message: "expected a list of String, got a string"},
+		}},
+		{"undocumented type", "Nope", `{}`, []Problem{
+			{Path: "Nope", Message: "type v1.Nope is not documented"},
+		}},
+		{"field of an undocumented type", "Broken", `{"missing": {}}`, []Problem{
+			{Path: "Broken.missing", Message: "type v1.Nowhere is not documented"},
+		}},
+		{"invalid JSON", "SendRequest", `{"username":`, []Problem{
+			{Path: "SendRequest", Message: "invalid JSON: unexpected EOF"},
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := p.Validate("v1", test.typeName, json.RawMessage(test.value))
+			if len(got) == 0 && len(test.want) == 0 {
+				return
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %v\nexpected %v", got, test.want)
+			}
+		})
+	}
+}
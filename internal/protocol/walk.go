@@ -0,0 +1,41 @@
+package protocol
+
+import "encoding/json"
+
+// Visit calls visit for every documented field that has a non-null value in a JSON value of the named type,
+// including the fields of nested types. Undocumented fields and values of the wrong type are skipped, see Validate.
+func (p *Protocol) Visit(version, typeName string, value json.RawMessage, visit func(version, typeName, field string)) {
+	v, err := decode(value)
+	if err != nil {
+		return
+	}
+	p.visitType(version, typeName, v, visit, 0)
+}
+
+// maxVisitDepth stops runaway recursion on values that nest without end
+const maxVisitDepth = 32
+
+func (p *Protocol) visitType(version, typeName string, v interface{}, visit func(version, typeName, field string), depth int) {
+	t := p.Type(version, typeName)
+	object, ok := v.(map[string]interface{})
+	if t == nil || !ok || depth > maxVisitDepth {
+		return
+	}
+	for _, name := range sortedKeys(object) {
+		field, ok := t.Fields[name]
+		if !ok || object[name] == nil {
+			continue
+		}
+		visit(version, typeName, name)
+		if field.Version == "" {
+			continue
+		}
+		if list, ok := object[name].([]interface{}); ok && field.List {
+			for _, item := range list {
+				p.visitType(field.Version, field.Type, item, visit, depth+1)
+			}
+		} else {
+			p.visitType(field.Version, field.Type, object[name], visit, depth+1)
+		}
+	}
+}
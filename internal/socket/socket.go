@@ -0,0 +1,295 @@
+package socket
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+)
+
+// DefaultPath is where signald creates its control socket unless told otherwise
+const DefaultPath = "/var/run/signald/signald.sock"
+
+// ErrClosed is returned for requests that were still waiting on a reply when the connection went away
+var ErrClosed = errors.New("signald socket closed")
+
+// Response is a single line received from signald, either a reply to a request or an unsolicited message
+type Response struct {
+	ID        string          `json:"id,omitempty"`
+	Type      string          `json:"type"`
+	Data      json.RawMessage `json:"data,omitempty"`
+	Error     json.RawMessage `json:"error,omitempty"`
+	ErrorType string          `json:"error_type,omitempty"`
+	Exception string          `json:"exception,omitempty"`
+}
+
+// Error is a request failure reported by signald
+type Error struct {
+	Type    string
+	Message string
+	Raw     json.RawMessage
+}
+
+func (e *Error) Error() string {
+	if e.Type == "" {
+		return e.Message
+	}
+	return e.Type + ": " + e.Message
+}
+
+// Err returns the error signald reported in r, or nil if r is not an error.
+// v1 requests report errors in the error field, legacy v0 requests reply with an unexpected_error message.
+func (r Response) Err() error {
+	if len(r.Error) > 0 && string(r.Error) != "null" {
+		e := &Error{Type: r.ErrorType, Raw: r.Error}
+		var detail struct {
+			Message string `json:"message"`
+		}
+		if err := json.Unmarshal(r.Error, &detail); err == nil && detail.Message != "" {
+			e.Message = detail.Message
+		} else {
+			e.Message = string(r.Error)
+		}
+		return e
+	}
+	if r.Type == "unexpected_error" {
+		var status struct {
+			Message string `json:"message"`
+		}
+		_ = json.Unmarshal(r.Data, &status)
+		return &Error{Type: r.Type, Message: status.Message, Raw: r.Data}
+	}
+	return nil
+}
+
+type listener struct {
+	ch   chan Response
+	done chan struct{}
+}
+
+// Conn is a connection to the signald control socket. Replies are matched to requests by id,
+// everything else is handed to listeners registered with Listen.
+type Conn struct {
+	conn  net.Conn
+	trace func(sent bool, line []byte)
+
+	writeLock sync.Mutex
+
+	lock      sync.Mutex
+	nextID    int
+	pending   map[string]chan Response
+	listeners map[int]*listener
+	nextL     int
+	closed    bool
+}
+
+// Dial connects to the signald socket at path
+func Dial(path string) (*Conn, error) {
+	return DialWithTrace(path, nil)
+}
+
+// DialWithTrace is Dial, calling trace with every line written to or read from the socket.
+// trace runs on the goroutine doing the reading or writing and must not block.
+func DialWithTrace(path string, trace func(sent bool, line []byte)) (*Conn, error) {
+	if path == "" {
+		path = DefaultPath
+	}
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	c := &Conn{
+		conn:      conn,
+		trace:     trace,
+		pending:   map[string]chan Response{},
+		listeners: map[int]*listener{},
+	}
+	go c.readLoop()
+	return c, nil
+}
+
+func (c *Conn) readLoop() {
+	reader := bufio.NewReader(c.conn)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 {
+			if c.trace != nil {
+				c.trace(false, bytes.TrimRight(line, "\n"))
+			}
+			var r Response
+			if jsonErr := json.Unmarshal(line, &r); jsonErr == nil {
+				c.dispatch(r)
+			}
+		}
+		if err != nil {
+			c.shutdown()
+			return
+		}
+	}
+}
+
+func (c *Conn) dispatch(r Response) {
+	c.lock.Lock()
+	if ch, ok := c.pending[r.ID]; ok && r.ID != "" {
+		delete(c.pending, r.ID)
+		c.lock.Unlock()
+		ch <- r
+		return
+	}
+	listeners := make([]*listener, 0, len(c.listeners))
+	for _, l := range c.listeners {
+		listeners = append(listeners, l)
+	}
+	c.lock.Unlock()
+
+	for _, l := range listeners {
+		select {
+		case l.ch <- r:
+		case <-l.done:
+		}
+	}
+}
+
+// shutdown is only called from the read loop, so nothing else can be sending on the channels it closes
+func (c *Conn) shutdown() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.closed {
+		return
+	}
+	c.closed = true
+	for id, ch := range c.pending {
+		close(ch)
+		delete(c.pending, id)
+	}
+	for id, l := range c.listeners {
+		close(l.ch)
+		delete(c.listeners, id)
+	}
+}
+
+// Listen returns a channel that receives every message that is not a reply to a request made on this connection,
+// such as incoming messages for subscribed accounts. The channel is closed when the connection closes.
+// Calling the returned function stops delivery. Listeners must keep reading, a full channel blocks the connection.
+func (c *Conn) Listen() (<-chan Response, func()) {
+	l := &listener{ch: make(chan Response, 100), done: make(chan struct{})}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.closed {
+		close(l.ch)
+		return l.ch, func() {}
+	}
+	id := c.nextL
+	c.nextL++
+	c.listeners[id] = l
+	var once sync.Once
+	return l.ch, func() {
+		once.Do(func() {
+			c.lock.Lock()
+			delete(c.listeners, id)
+			c.lock.Unlock()
+			close(l.done)
+		})
+	}
+}
+
+// Request sends a request of the given version and type and waits for the reply.
+// payload must marshal to a JSON object, its fields are sent alongside type, version and id.
+func (c *Conn) Request(ctx context.Context, version, requestType string, payload interface{}) (Response, error) {
+	body := map[string]json.RawMessage{}
+	if payload != nil {
+		b, err := json.Marshal(payload)
+		if err != nil {
+			return Response{}, err
+		}
+		if err := json.Unmarshal(b, &body); err != nil {
+			return Response{}, fmt.Errorf("request payload must be a JSON object: %v", err)
+		}
+	}
+
+	ch := make(chan Response, 1)
+	c.lock.Lock()
+	if c.closed {
+		c.lock.Unlock()
+		return Response{}, ErrClosed
+	}
+	c.nextID++
+	id := strconv.Itoa(c.nextID)
+	c.pending[id] = ch
+	c.lock.Unlock()
+
+	body["id"], _ = json.Marshal(id)
+	body["type"], _ = json.Marshal(requestType)
+	if version != "" {
+		body["version"], _ = json.Marshal(version)
+	}
+
+	if err := c.write(body); err != nil {
+		c.forget(id)
+		return Response{}, err
+	}
+
+	select {
+	case r, ok := <-ch:
+		if !ok {
+			return Response{}, ErrClosed
+		}
+		return r, r.Err()
+	case <-ctx.Done():
+		c.forget(id)
+		return Response{}, ctx.Err()
+	}
+}
+
+// RequestInto is Request followed by decoding the reply's data field into out
+func (c *Conn) RequestInto(ctx context.Context, version, requestType string, payload interface{}, out interface{}) error {
+	r, err := c.Request(ctx, version, requestType, payload)
+	if err != nil {
+		return err
+	}
+	if out == nil || len(r.Data) == 0 {
+		return nil
+	}
+	return json.Unmarshal(r.Data, out)
+}
+
+// WriteRaw sends an already-encoded request line without waiting for a reply
+func (c *Conn) WriteRaw(line []byte) error {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	if c.trace != nil {
+		c.trace(true, bytes.TrimRight(line, "\n"))
+	}
+	if _, err := c.conn.Write(line); err != nil {
+		return err
+	}
+	if len(line) == 0 || line[len(line)-1] != '\n' {
+		_, err := c.conn.Write([]byte{'\n'})
+		return err
+	}
+	return nil
+}
+
+func (c *Conn) write(body interface{}) error {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	return c.WriteRaw(b)
+}
+
+func (c *Conn) forget(id string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	delete(c.pending, id)
+}
+
+// Close closes the underlying socket. Outstanding requests fail with ErrClosed.
+func (c *Conn) Close() error {
+	return c.conn.Close()
+}
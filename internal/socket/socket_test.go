@@ -0,0 +1,233 @@
+package socket
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// serve starts a fake signald that hands its first connection to handle, returning the socket path
+func serve(t *testing.T, handle func(conn net.Conn, requests *bufio.Scanner)) string {
+	path := filepath.Join(t.TempDir(), "signald.sock")
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		handle(conn, bufio.NewScanner(conn))
+	}()
+	return path
+}
+
+func readRequest(t *testing.T, requests *bufio.Scanner) map[string]interface{} {
+	if !requests.Scan() {
+		t.Errorf("expected a request: %v", requests.Err())
+		return nil
+	}
+	var r map[string]interface{}
+	if err := json.Unmarshal(requests.Bytes(), &r); err != nil {
+		t.Errorf("request is not JSON: %v", err)
+	}
+	return r
+}
+
+func reply(conn net.Conn, r map[string]interface{}) {
+	b, _ := json.Marshal(r)
+	conn.Write(append(b, '\n'))
+}
+
+func TestRequestMatchesRepliesByID(t *testing.T) {
+	path := serve(t, func(conn net.Conn, requests *bufio.Scanner) {
+		first := readRequest(t, requests)
+		second := readRequest(t, requests)
+		// unsolicited messages and replies out of order
+		reply(conn, map[string]interface{}{"type": "version", "data": map[string]string{"version": "1"}})
+		reply(conn, map[string]interface{}{"id": second["id"], "type": second["type"], "data": "second"})
+		reply(conn, map[string]interface{}{"type": "message", "data": "incoming"})
+		reply(conn, map[string]interface{}{"id": first["id"], "type": first["type"], "data": "first"})
+		requests.Scan()
+	})
+	c, err := Dial(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	incoming, stop := c.Listen()
+	defer stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	type result struct {
+		data string
+		err  error
+	}
+	firstDone := make(chan result, 1)
+	go func() {
+		var data string
+		err := c.RequestInto(ctx, "v1", "one", nil, &data)
+		firstDone <- result{data, err}
+	}()
+	// make sure the first request is written before the second
+	time.Sleep(50 * time.Millisecond)
+	var second string
+	if err := c.RequestInto(ctx, "v1", "two", nil, &second); err != nil {
+		t.Fatal(err)
+	}
+	first := <-firstDone
+	if first.err != nil {
+		t.Fatal(first.err)
+	}
+	if first.data != "first" || second != "second" {
+		t.Errorf("replies were mixed up: first got %q, second got %q", first.data, second)
+	}
+
+	for _, want := range []string{"version", "message"} {
+		select {
+		case r := <-incoming:
+			if r.Type != want {
+				t.Errorf("listener got %q, expected %q", r.Type, want)
+			}
+		case <-ctx.Done():
+			t.Fatalf("listener did not get %q", want)
+		}
+	}
+}
+
+func TestRequestFields(t *testing.T) {
+	got := make(chan map[string]interface{}, 1)
+	path := serve(t, func(conn net.Conn, requests *bufio.Scanner) {
+		r := readRequest(t, requests)
+		got <- r
+		reply(conn, map[string]interface{}{"id": r["id"], "type": r["type"]})
+	})
+	c, err := Dial(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	payload := map[string]string{"account": "+12024561414", "type": "ignored", "id": "ignored"}
+	if _, err := c.Request(ctx, "v1", "get_profile", payload); err != nil {
+		t.Fatal(err)
+	}
+	r := <-got
+	if r["type"] != "get_profile" || r["version"] != "v1" || r["account"] != "+12024561414" {
+		t.Errorf("unexpected request %v", r)
+	}
+	if r["id"] == "ignored" || r["id"] == "" {
+		t.Errorf("payload id was not replaced: %v", r["id"])
+	}
+
+	if _, err := c.Request(ctx, "v1", "version", []string{"not", "an", "object"}); err == nil {
+		t.Error("expected an error for a payload that isn't an object")
+	}
+}
+
+func TestRequestTimeout(t *testing.T) {
+	path := serve(t, func(conn net.Conn, requests *bufio.Scanner) {
+		for requests.Scan() {
+		}
+	})
+	c, err := Dial(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := c.Request(ctx, "v1", "version", nil); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected a deadline error, got %v", err)
+	}
+	c.lock.Lock()
+	pending := len(c.pending)
+	c.lock.Unlock()
+	if pending != 0 {
+		t.Errorf("%d requests still pending after timing out", pending)
+	}
+}
+
+func TestShutdownFailsPendingRequests(t *testing.T) {
+	path := serve(t, func(conn net.Conn, requests *bufio.Scanner) {
+		readRequest(t, requests)
+		// hang up without replying
+	})
+	c, err := Dial(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	incoming, _ := c.Listen()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := c.Request(ctx, "v1", "version", nil); err != ErrClosed {
+		t.Errorf("expected ErrClosed for the pending request, got %v", err)
+	}
+	select {
+	case _, ok := <-incoming:
+		if ok {
+			t.Error("expected the listener channel to be closed")
+		}
+	case <-ctx.Done():
+		t.Fatal("listener channel was not closed")
+	}
+	if _, err := c.Request(ctx, "v1", "version", nil); err != ErrClosed {
+		t.Errorf("expected ErrClosed after shutdown, got %v", err)
+	}
+	if ch, _ := c.Listen(); ch != nil {
+		if _, ok := <-ch; ok {
+			t.Error("expected Listen after shutdown to return a closed channel")
+		}
+	}
+}
+
+func TestResponseErr(t *testing.T) {
+	tests := []struct {
+		name     string
+		response string
+		errType  string
+		message  string
+	}{
+		{"success", `{"type":"version","data":{"version":"1"}}`, "", ""},
+		{"null error", `{"type":"version","error":null}`, "", ""},
+		{"v1 error", `{"type":"send","error_type":"NoSuchAccountError","error":{"message":"no such account"}}`, "NoSuchAccountError", "no such account"},
+		{"error without message", `{"type":"send","error_type":"InternalError","error":{"exceptions":["x"]}}`, "InternalError", `{"exceptions":["x"]}`},
+		{"v0 unexpected_error", `{"type":"unexpected_error","data":{"message":"account not found"}}`, "unexpected_error", "account not found"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var r Response
+			if err := json.Unmarshal([]byte(test.response), &r); err != nil {
+				t.Fatal(err)
+			}
+			err := r.Err()
+			if test.errType == "" {
+				if err != nil {
+					t.Errorf("expected no error, got %v", err)
+				}
+				return
+			}
+			var signaldErr *Error
+			if !errors.As(err, &signaldErr) {
+				t.Fatalf("expected *Error, got %v", err)
+			}
+			if signaldErr.Type != test.errType || signaldErr.Message != test.message {
+				t.Errorf("got type %q message %q, expected %q %q", signaldErr.Type, signaldErr.Message, test.errType, test.message)
+			}
+		})
+	}
+}
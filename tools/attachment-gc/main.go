@@ -0,0 +1,199 @@
+// attachment-gc deletes attachments signald has downloaded once they're older than a retention window, and with
+// -references also the ones no known message refers to. signald never deletes attachments itself.
+//
+// signald keeps no message history, so what counts as known is up to whoever runs it: -references takes files and
+// directories of JSON or newline delimited JSON that hold messages, like the output of "signaldctl tail -o json",
+// captures or an archive export. An attachment is referenced if its file name appears anywhere in them, as the
+// attachment id or as a storedFilename. Orphans are only deleted once they're older than -orphan-grace, so
+// attachments for messages that haven't made it into the references yet are left alone. If -references turns up no
+// files or no strings at all it refuses to run rather than treat every attachment as an orphan.
+//
+//	go run ./tools/attachment-gc -older-than 2160h -dry-run
+//	go run ./tools/attachment-gc -older-than 2160h -references /var/lib/signal-archive
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	aurora "github.com/logrusorgru/aurora/v3"
+)
+
+func main() {
+	home, _ := os.UserHomeDir()
+	dataPath := flag.String("data", filepath.Join(home, ".config", "signald"), "signald's data directory, as passed to signald --data")
+	attachmentsPath := flag.String("attachments", "", "attachment directory (default: attachments in -data)")
+	olderThan := flag.Duration("older-than", 0, "delete attachments older than this, 0 keeps them regardless of age")
+	references := flag.String("references", "", "comma separated files or directories of messages, attachments none of them mention are deleted")
+	orphanGrace := flag.Duration("orphan-grace", 24*time.Hour, "how old an unreferenced attachment must be before it is deleted")
+	dryRun := flag.Bool("dry-run", false, "only print what would be deleted")
+	flag.Parse()
+	if *olderThan == 0 && *references == "" {
+		fmt.Println("usage: attachment-gc [-data dir] [-attachments dir] [-older-than duration] [-references path,...] [-orphan-grace duration] [-dry-run]")
+		fmt.Println("at least one of -older-than and -references is required")
+		os.Exit(2)
+	}
+	if *attachmentsPath == "" {
+		*attachmentsPath = filepath.Join(*dataPath, "attachments")
+	}
+
+	var referenced map[string]bool
+	if *references != "" {
+		var err error
+		var loaded int
+		referenced, loaded, err = loadReferences(strings.Split(*references, ","))
+		if err != nil {
+			fmt.Println(aurora.Red(err.Error()))
+			os.Exit(1)
+		}
+		// with nothing to go on every attachment would look like an orphan, most likely the path is wrong
+		if loaded == 0 || len(referenced) == 0 {
+			fmt.Println(aurora.Red(fmt.Sprintf("found %d references in %d files under %s, refusing to treat every attachment as unreferenced", len(referenced), loaded, *references)))
+			os.Exit(1)
+		}
+		fmt.Printf("found %d references in %d files\n", len(referenced), loaded)
+	}
+
+	files, err := ioutil.ReadDir(*attachmentsPath)
+	if err != nil {
+		fmt.Println(aurora.Red(err.Error()))
+		os.Exit(1)
+	}
+	now := time.Now()
+	var deleted, kept int
+	var freed int64
+	failed := false
+	for _, f := range files {
+		if !f.Mode().IsRegular() {
+			continue
+		}
+		age := now.Sub(f.ModTime())
+		var reason string
+		switch {
+		case *olderThan > 0 && age > *olderThan:
+			reason = "older than " + olderThan.String()
+		case referenced != nil && !referenced[f.Name()] && age > *orphanGrace:
+			reason = "not referenced"
+		default:
+			kept++
+			continue
+		}
+
+		path := filepath.Join(*attachmentsPath, f.Name())
+		if *dryRun {
+			fmt.Printf("%s %s (%s, %s)\n", aurora.Yellow("would delete"), path, reason, humanSize(f.Size()))
+		} else if err := os.Remove(path); err != nil {
+			fmt.Printf("%s %s: %v\n", aurora.Red("error deleting"), path, err)
+			failed = true
+			continue
+		} else {
+			fmt.Printf("%s %s (%s, %s)\n", aurora.Red("deleted"), path, reason, humanSize(f.Size()))
+		}
+		deleted++
+		freed += f.Size()
+	}
+
+	verb := "deleted"
+	if *dryRun {
+		verb = "would delete"
+	}
+	fmt.Printf("%s %d attachments (%s), kept %d\n", verb, deleted, humanSize(freed), kept)
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// loadReferences collects every string in the JSON found at paths, along with the base name of each, since
+// storedFilename holds a full path. It also returns how many files it read.
+func loadReferences(paths []string) (map[string]bool, int, error) {
+	referenced := map[string]bool{}
+	loaded := 0
+	for _, root := range paths {
+		root = strings.TrimSpace(root)
+		if root == "" {
+			continue
+		}
+		err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.IsDir() || (path != root && !isJSONFile(path)) {
+				return nil
+			}
+			b, err := ioutil.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			if err := collectStrings(b, referenced); err != nil {
+				return fmt.Errorf("error reading %s: %v", path, err)
+			}
+			loaded++
+			return nil
+		})
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+	return referenced, loaded, nil
+}
+
+func isJSONFile(path string) bool {
+	switch filepath.Ext(path) {
+	case ".json", ".ndjson", ".jsonl":
+		return true
+	}
+	return false
+}
+
+// collectStrings reads one or more concatenated JSON values
+func collectStrings(b []byte, into map[string]bool) error {
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	decoder.UseNumber()
+	for decoder.More() {
+		var v interface{}
+		if err := decoder.Decode(&v); err != nil {
+			return err
+		}
+		walkStrings(v, into)
+	}
+	return nil
+}
+
+func walkStrings(v interface{}, into map[string]bool) {
+	switch v := v.(type) {
+	case string:
+		into[v] = true
+		into[filepath.Base(v)] = true
+	case json.Number:
+		// v0 attachment ids are numbers in some places
+		into[v.String()] = true
+	case []interface{}:
+		for _, item := range v {
+			walkStrings(item, into)
+		}
+	case map[string]interface{}:
+		for _, item := range v {
+			walkStrings(item, into)
+		}
+	}
+}
+
+func humanSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,285 @@
+// conformance exercises a running signald and checks every reply against the protocol documentation,
+// to catch changes to the Java side that break wire compatibility for clients.
+//
+//	go run ./tools/conformance -account +12024561414 -send-to +12024561111
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	aurora "github.com/logrusorgru/aurora/v3"
+
+	"gitlab.com/signald/signald/internal/capture"
+	"gitlab.com/signald/signald/internal/protocol"
+	"gitlab.com/signald/signald/internal/socket"
+)
+
+type config struct {
+	account string
+	sendTo  string
+}
+
+// testCase is one request to make. request returns the payload, or an empty string explaining why the case is skipped.
+type testCase struct {
+	action  string
+	request func(config) (interface{}, string)
+}
+
+func needsAccount(build func(c config) interface{}) func(config) (interface{}, string) {
+	return func(c config) (interface{}, string) {
+		if c.account == "" {
+			return nil, "needs -account"
+		}
+		return build(c), ""
+	}
+}
+
+func needsRecipient(build func(c config) interface{}) func(config) (interface{}, string) {
+	return func(c config) (interface{}, string) {
+		if c.account == "" || c.sendTo == "" {
+			return nil, "needs -account and -send-to"
+		}
+		return build(c), ""
+	}
+}
+
+func address(s string) map[string]string {
+	if strings.HasPrefix(s, "+") {
+		return map[string]string{"number": s}
+	}
+	return map[string]string{"uuid": s}
+}
+
+var testCases = []testCase{
+	{"version", func(config) (interface{}, string) { return nil, "" }},
+	{"list_accounts", func(config) (interface{}, string) { return nil, "" }},
+	{"get_profile", needsAccount(func(c config) interface{} {
+		return map[string]interface{}{"account": c.account, "address": address(c.account)}
+	})},
+	{"list_groups", needsAccount(func(c config) interface{} { return map[string]interface{}{"account": c.account} })},
+	{"list_contacts", needsAccount(func(c config) interface{} { return map[string]interface{}{"account": c.account} })},
+	{"get_linked_devices", needsAccount(func(c config) interface{} { return map[string]interface{}{"account": c.account} })},
+	{"get_all_identities", needsAccount(func(c config) interface{} { return map[string]interface{}{"account": c.account} })},
+	{"get_identities", needsRecipient(func(c config) interface{} {
+		return map[string]interface{}{"account": c.account, "address": address(c.sendTo)}
+	})},
+	{"resolve_address", needsRecipient(func(c config) interface{} {
+		return map[string]interface{}{"account": c.account, "partial": address(c.sendTo)}
+	})},
+	{"typing", needsRecipient(func(c config) interface{} {
+		return map[string]interface{}{"account": c.account, "address": address(c.sendTo), "typing": false}
+	})},
+	{"send", needsRecipient(func(c config) interface{} {
+		return map[string]interface{}{"username": c.account, "recipientAddress": address(c.sendTo), "messageBody": "signald conformance test " + time.Now().UTC().Format(time.RFC3339)}
+	})},
+}
+
+const (
+	statusPass = "PASS"
+	statusFail = "FAIL"
+	statusSkip = "SKIP"
+)
+
+type result struct {
+	Action   string   `json:"action"`
+	Status   string   `json:"status"`
+	Duration string   `json:"duration,omitempty"`
+	Reason   string   `json:"reason,omitempty"`
+	Problems []string `json:"problems,omitempty"`
+}
+
+func main() {
+	socketPath := flag.String("socket", socket.DefaultPath, "path to the signald socket")
+	protocolPath := flag.String("protocol", "", "protocol.json to validate against (default: ask signald for its own)")
+	actions := flag.String("actions", "", "comma separated actions to run (default: all)")
+	timeout := flag.Duration("timeout", 30*time.Second, "how long to wait for each reply")
+	jsonReport := flag.Bool("json", false, "print the report as JSON")
+	capturePath := flag.String("capture", "", "also record all traffic to this capture file, for tools/protocol-coverage")
+	var c config
+	flag.StringVar(&c.account, "account", "", "local account to run account specific actions as")
+	flag.StringVar(&c.sendTo, "send-to", "", "phone number or UUID of a sandbox account that receives test messages")
+	flag.Parse()
+
+	var trace func(bool, []byte)
+	if *capturePath != "" {
+		f, err := os.OpenFile(*capturePath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+		if err != nil {
+			panic(err)
+		}
+		defer f.Close()
+		w := capture.NewWriter(f, nil)
+		trace = func(sent bool, line []byte) {
+			from := capture.FromSignald
+			if sent {
+				from = capture.FromClient
+			}
+			if err := w.Write(capture.NewRecord(1, from, line)); err != nil {
+				panic(err)
+			}
+		}
+	}
+
+	conn, err := socket.DialWithTrace(*socketPath, trace)
+	if err != nil {
+		fmt.Println(aurora.Red("error connecting to signald"))
+		panic(err)
+	}
+	defer conn.Close()
+
+	var p *protocol.Protocol
+	if *protocolPath != "" {
+		p, err = protocol.Load(*protocolPath)
+	} else {
+		ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+		p, err = protocol.Fetch(ctx, conn)
+		cancel()
+	}
+	if err != nil {
+		fmt.Println(aurora.Red("error loading protocol documentation"))
+		panic(err)
+	}
+
+	selected := map[string]bool{}
+	for _, a := range strings.Split(*actions, ",") {
+		if a = strings.TrimSpace(a); a != "" {
+			selected[a] = true
+		}
+	}
+	for a := range selected {
+		if !knownTestCase(a) {
+			fmt.Println(aurora.Red("no test case for action " + a))
+			os.Exit(2)
+		}
+	}
+
+	results := []result{}
+	failed := false
+	for _, tc := range testCases {
+		if len(selected) > 0 && !selected[tc.action] {
+			continue
+		}
+		r := run(conn, p, c, tc, *timeout)
+		failed = failed || r.Status == statusFail
+		results = append(results, r)
+	}
+
+	if *jsonReport {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(results); err != nil {
+			panic(err)
+		}
+	} else {
+		printReport(results)
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+}
+
+func knownTestCase(action string) bool {
+	for _, tc := range testCases {
+		if tc.action == action {
+			return true
+		}
+	}
+	return false
+}
+
+func run(conn *socket.Conn, p *protocol.Protocol, c config, tc testCase, timeout time.Duration) result {
+	r := result{Action: tc.action}
+	payload, skip := tc.request(c)
+	if skip != "" {
+		r.Status = statusSkip
+		r.Reason = skip
+		return r
+	}
+
+	action := p.Action("v1", tc.action)
+	if action == nil {
+		r.Status = statusFail
+		r.Reason = "action is not documented in the protocol"
+		return r
+	}
+
+	if payload != nil {
+		b, err := json.Marshal(payload)
+		if err != nil {
+			panic(err)
+		}
+		for _, problem := range p.Validate("v1", action.Request, b) {
+			r.Problems = append(r.Problems, "request "+problem.String())
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	start := time.Now()
+	resp, err := conn.Request(ctx, "v1", tc.action, payload)
+	r.Duration = time.Since(start).Round(time.Millisecond).String()
+	if err != nil {
+		r.Status = statusFail
+		r.Reason = err.Error()
+		return r
+	}
+
+	if resp.Type != tc.action {
+		r.Problems = append(r.Problems, fmt.Sprintf("reply has type %q, expected %q", resp.Type, tc.action))
+	}
+	switch {
+	case action.Response == "":
+		// actions without a documented response reply with an empty object
+		if len(resp.Data) > 0 && string(resp.Data) != "{}" && string(resp.Data) != "null" {
+			r.Problems = append(r.Problems, "reply has data but the protocol documents no response: "+string(resp.Data))
+		}
+	case len(resp.Data) == 0:
+		r.Problems = append(r.Problems, "reply has no data, expected "+action.Response)
+	default:
+		for _, problem := range p.Validate("v1", action.Response, resp.Data) {
+			r.Problems = append(r.Problems, "response "+problem.String())
+		}
+	}
+
+	r.Status = statusPass
+	if len(r.Problems) > 0 {
+		r.Status = statusFail
+	}
+	return r
+}
+
+func printReport(results []result) {
+	passed, failed, skipped := 0, 0, 0
+	for _, r := range results {
+		var status aurora.Value
+		switch r.Status {
+		case statusPass:
+			passed++
+			status = aurora.Green(r.Status)
+		case statusFail:
+			failed++
+			status = aurora.Bold(aurora.Red(r.Status))
+		default:
+			skipped++
+			status = aurora.Yellow(r.Status)
+		}
+		line := fmt.Sprintf("%s %s", status, r.Action)
+		if r.Duration != "" {
+			line += " (" + r.Duration + ")"
+		}
+		if r.Reason != "" {
+			line += ": " + r.Reason
+		}
+		fmt.Println(line)
+		for _, problem := range r.Problems {
+			fmt.Println("    " + problem)
+		}
+	}
+	fmt.Printf("\n%d passed, %d failed, %d skipped\n", passed, failed, skipped)
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// dockerAPIVersion is old enough for any daemon still in use and has everything the harness needs
+const dockerAPIVersion = "v1.40"
+
+// docker talks to the Docker Engine API over its unix socket
+type docker struct {
+	client *http.Client
+}
+
+// newDocker connects to the daemon at host, a unix:// URL or a socket path. Empty means DOCKER_HOST or the default
+// socket.
+func newDocker(host string) (*docker, error) {
+	if host == "" {
+		host = os.Getenv("DOCKER_HOST")
+	}
+	if host == "" {
+		host = "unix:///var/run/docker.sock"
+	}
+	path := host
+	if strings.Contains(host, "://") {
+		u, err := url.Parse(host)
+		if err != nil {
+			return nil, err
+		}
+		if u.Scheme != "unix" {
+			return nil, fmt.Errorf("only unix sockets are supported, not %s", host)
+		}
+		path = u.Path
+	}
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", path)
+		},
+	}
+	return &docker{client: &http.Client{Transport: transport}}, nil
+}
+
+// request calls the API. body is sent as JSON if not nil, and the response decoded into out if not nil.
+func (d *docker) request(ctx context.Context, method, path string, query url.Values, body interface{}, out interface{}) error {
+	resp, err := d.do(ctx, method, path, query, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if out == nil {
+		io.Copy(ioutil.Discard, resp.Body)
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func (d *docker) do(ctx context.Context, method, path string, query url.Values, body interface{}) (*http.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(b)
+	}
+	u := "http://docker/" + dockerAPIVersion + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, reader)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		defer resp.Body.Close()
+		var apiErr struct {
+			Message string `json:"message"`
+		}
+		json.NewDecoder(resp.Body).Decode(&apiErr)
+		return nil, fmt.Errorf("docker %s %s: %s: %s", method, path, resp.Status, apiErr.Message)
+	}
+	return resp, nil
+}
+
+// pull fetches an image, waiting until the daemon has it
+func (d *docker) pull(ctx context.Context, image string) error {
+	name, tag := image, "latest"
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		name, tag = image[:i], image[i+1:]
+	}
+	resp, err := d.do(ctx, http.MethodPost, "/images/create", url.Values{"fromImage": {name}, "tag": {tag}}, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	// progress is streamed as JSON objects, errors included
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		var progress struct {
+			Error string `json:"error"`
+		}
+		if err := decoder.Decode(&progress); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if progress.Error != "" {
+			return fmt.Errorf("error pulling %s: %s", image, progress.Error)
+		}
+	}
+}
+
+type containerConfig struct {
+	Image      string            `json:"Image"`
+	User       string            `json:"User,omitempty"`
+	Labels     map[string]string `json:"Labels,omitempty"`
+	HostConfig struct {
+		Binds []string `json:"Binds,omitempty"`
+	} `json:"HostConfig"`
+}
+
+func (d *docker) create(ctx context.Context, name string, config containerConfig) (string, error) {
+	var created struct {
+		ID string `json:"Id"`
+	}
+	if err := d.request(ctx, http.MethodPost, "/containers/create", url.Values{"name": {name}}, config, &created); err != nil {
+		return "", err
+	}
+	return created.ID, nil
+}
+
+func (d *docker) start(ctx context.Context, id string) error {
+	return d.request(ctx, http.MethodPost, "/containers/"+id+"/start", nil, nil, nil)
+}
+
+func (d *docker) running(ctx context.Context, id string) (bool, error) {
+	var inspect struct {
+		State struct {
+			Running bool `json:"Running"`
+		} `json:"State"`
+	}
+	if err := d.request(ctx, http.MethodGet, "/containers/"+id+"/json", nil, nil, &inspect); err != nil {
+		return false, err
+	}
+	return inspect.State.Running, nil
+}
+
+// remove stops and deletes a container along with its anonymous volumes
+func (d *docker) remove(ctx context.Context, id string) error {
+	return d.request(ctx, http.MethodDelete, "/containers/"+id, url.Values{"force": {"true"}, "v": {"true"}}, nil, nil)
+}
+
+// logs returns the last lines a container wrote to stdout and stderr
+func (d *docker) logs(ctx context.Context, id string, lines int) (string, error) {
+	resp, err := d.do(ctx, http.MethodGet, "/containers/"+id+"/logs", url.Values{"stdout": {"true"}, "stderr": {"true"}, "tail": {fmt.Sprint(lines)}}, nil)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	// without a TTY both streams are multiplexed into frames with an 8 byte header: stream, 3 bytes padding, size
+	var out bytes.Buffer
+	header := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(resp.Body, header); err != nil {
+			break
+		}
+		if _, err := io.CopyN(&out, resp.Body, int64(binary.BigEndian.Uint32(header[4:]))); err != nil {
+			break
+		}
+	}
+	return out.String(), nil
+}
+
+// waitFor polls check until it returns true, an error, or the timeout runs out
+func waitFor(timeout time.Duration, check func() (bool, error)) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		ok, err := check()
+		if err != nil {
+			return err
+		}
+		if ok {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("gave up after %s", timeout)
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+}
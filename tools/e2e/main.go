@@ -0,0 +1,460 @@
+// e2e starts signald in a throwaway Docker container and runs end to end flows against it through the socket:
+// startup, version and protocol, generating a linking URI, registration, and messages sent between two accounts
+// in both directions. The container and its data are removed afterwards unless -keep is given.
+//
+// The data directory is a bind mount from a temporary directory on this machine, so the Docker daemon must share
+// its filesystem (a local daemon, not docker:dind), and signald runs as the current user so the socket is reachable.
+//
+// Flows that need real accounts are skipped unless configured. Registering needs a number, usually a captcha, and
+// -code-command: a shell command that prints the verification code, run with E2E_ACCOUNT set to the number. Sending
+// needs a data directory with two registered accounts to start from, passed as -seed. Run it against an image built
+// with SIGNAL_URL and friends pointing at Signal's staging servers to keep test traffic off production.
+//
+//	go run ./tools/e2e -image registry.gitlab.com/signald/signald:main -pull
+//	go run ./tools/e2e -image signald:staging -seed testdata/staging -account-a +12024561414 -account-b +12024561111
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	aurora "github.com/logrusorgru/aurora/v3"
+
+	"gitlab.com/signald/signald/internal/protocol"
+	"gitlab.com/signald/signald/internal/socket"
+)
+
+type config struct {
+	link        bool
+	register    string
+	captcha     string
+	codeCommand string
+	accountA    string
+	accountB    string
+}
+
+const (
+	statusPass = "PASS"
+	statusFail = "FAIL"
+	statusSkip = "SKIP"
+)
+
+type result struct {
+	Step     string `json:"step"`
+	Status   string `json:"status"`
+	Duration string `json:"duration,omitempty"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+// step is one flow. run returns a reason to skip it, or an error if it failed.
+type step struct {
+	name string
+	run  func(h *harness) (string, error)
+}
+
+var steps = []step{
+	{"version", checkVersion},
+	{"protocol", checkProtocol},
+	{"link", checkLink},
+	{"register", checkRegister},
+	{"list accounts", checkAccounts},
+	{"send a to b", func(h *harness) (string, error) { return checkSend(h, h.config.accountA, h.config.accountB) }},
+	{"send b to a", func(h *harness) (string, error) { return checkSend(h, h.config.accountB, h.config.accountA) }},
+}
+
+func main() {
+	dockerHost := flag.String("docker", "", "Docker daemon socket (default: DOCKER_HOST or /var/run/docker.sock)")
+	image := flag.String("image", "registry.gitlab.com/signald/signald:latest", "signald image to test")
+	pull := flag.Bool("pull", false, "pull the image before starting")
+	seed := flag.String("seed", "", "data directory to copy into the container's before starting, with accounts to test with")
+	keep := flag.Bool("keep", false, "leave the container and its data directory behind for debugging")
+	startupTimeout := flag.Duration("startup-timeout", 2*time.Minute, "how long signald may take to open its socket")
+	timeout := flag.Duration("timeout", time.Minute, "how long each step may take")
+	jsonReport := flag.Bool("json", false, "print the report as JSON")
+	var c config
+	flag.BoolVar(&c.link, "link", false, "generate a linking URI")
+	flag.StringVar(&c.register, "register", "", "phone number to register")
+	flag.StringVar(&c.captcha, "captcha", "", "captcha token for -register")
+	flag.StringVar(&c.codeCommand, "code-command", "", "shell command printing the verification code for -register")
+	flag.StringVar(&c.accountA, "account-a", "", "account in -seed to send from and to")
+	flag.StringVar(&c.accountB, "account-b", "", "second account in -seed to send from and to")
+	flag.Parse()
+
+	d, err := newDocker(*dockerHost)
+	if err != nil {
+		fmt.Println(aurora.Red(err.Error()))
+		os.Exit(2)
+	}
+	h := &harness{docker: d, config: c, timeout: *timeout}
+	start := time.Now()
+	startErr := h.start(*image, *pull, *seed, *startupTimeout)
+	results := []result{{Step: "start", Status: statusPass, Duration: time.Since(start).Round(time.Millisecond).String()}}
+	if startErr != nil {
+		results[0].Status = statusFail
+		results[0].Reason = startErr.Error()
+	} else {
+		for _, s := range steps {
+			results = append(results, h.run(s))
+		}
+	}
+
+	failed := false
+	for _, r := range results {
+		failed = failed || r.Status == statusFail
+	}
+	if failed && h.container != "" {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		logs, err := d.logs(ctx, h.container, 100)
+		cancel()
+		if err == nil {
+			fmt.Fprintln(os.Stderr, aurora.Bold("signald logs:"))
+			fmt.Fprintln(os.Stderr, logs)
+		}
+	}
+	h.stop(*keep)
+
+	if *jsonReport {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(results); err != nil {
+			panic(err)
+		}
+	} else {
+		printReport(results)
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+type harness struct {
+	docker  *docker
+	config  config
+	timeout time.Duration
+
+	dataDir   string
+	container string
+	conn      *socket.Conn
+	incoming  <-chan socket.Response
+}
+
+func (h *harness) start(image string, pull bool, seed string, startupTimeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), startupTimeout)
+	defer cancel()
+	if pull {
+		if err := h.docker.pull(ctx, image); err != nil {
+			return err
+		}
+	}
+
+	var err error
+	if h.dataDir, err = ioutil.TempDir("", "signald-e2e-"); err != nil {
+		return err
+	}
+	if seed != "" {
+		if err := copyDir(seed, h.dataDir); err != nil {
+			return fmt.Errorf("error copying -seed: %v", err)
+		}
+	}
+
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	var config containerConfig
+	config.Image = image
+	config.User = fmt.Sprintf("%d:%d", os.Getuid(), os.Getgid())
+	config.Labels = map[string]string{"org.signald.e2e": "true"}
+	// the image's default command serves /signald/signald.sock with its data in /signald
+	config.HostConfig.Binds = []string{h.dataDir + ":/signald"}
+	if h.container, err = h.docker.create(ctx, "signald-e2e-"+hex.EncodeToString(suffix), config); err != nil {
+		return err
+	}
+	if err := h.docker.start(ctx, h.container); err != nil {
+		return err
+	}
+
+	socketPath := filepath.Join(h.dataDir, "signald.sock")
+	err = waitFor(startupTimeout, func() (bool, error) {
+		if running, err := h.docker.running(ctx, h.container); err != nil || !running {
+			return false, fmt.Errorf("signald exited during startup")
+		}
+		conn, err := socket.Dial(socketPath)
+		if err != nil {
+			return false, nil
+		}
+		h.conn = conn
+		return true, nil
+	})
+	if err != nil {
+		return err
+	}
+	h.incoming, _ = h.conn.Listen()
+	return nil
+}
+
+func (h *harness) stop(keep bool) {
+	if h.conn != nil {
+		h.conn.Close()
+	}
+	if keep {
+		fmt.Fprintf(os.Stderr, "kept container %s with data in %s\n", h.container, h.dataDir)
+		return
+	}
+	if h.container != "" {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		if err := h.docker.remove(ctx, h.container); err != nil {
+			fmt.Fprintln(os.Stderr, aurora.Red("error removing container: "+err.Error()))
+		}
+		cancel()
+	}
+	if h.dataDir != "" {
+		os.RemoveAll(h.dataDir)
+	}
+}
+
+func (h *harness) run(s step) result {
+	r := result{Step: s.name}
+	start := time.Now()
+	skip, err := s.run(h)
+	r.Duration = time.Since(start).Round(time.Millisecond).String()
+	switch {
+	case skip != "":
+		r.Status = statusSkip
+		r.Reason = skip
+		r.Duration = ""
+	case err != nil:
+		r.Status = statusFail
+		r.Reason = err.Error()
+	default:
+		r.Status = statusPass
+	}
+	return r
+}
+
+func (h *harness) request(action string, payload interface{}, out interface{}) error {
+	ctx, cancel := context.WithTimeout(context.Background(), h.timeout)
+	defer cancel()
+	return h.conn.RequestInto(ctx, "v1", action, payload, out)
+}
+
+func checkVersion(h *harness) (string, error) {
+	var version struct {
+		Name    string `json:"name"`
+		Version string `json:"version"`
+	}
+	if err := h.request("version", nil, &version); err != nil {
+		return "", err
+	}
+	if version.Version == "" {
+		return "", fmt.Errorf("version reply has no version")
+	}
+	return "", nil
+}
+
+func checkProtocol(h *harness) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), h.timeout)
+	defer cancel()
+	p, err := protocol.Fetch(ctx, h.conn)
+	if err != nil {
+		return "", err
+	}
+	if p.Action("v1", "send") == nil {
+		return "", fmt.Errorf("protocol does not document v1 send")
+	}
+	return "", nil
+}
+
+func checkLink(h *harness) (string, error) {
+	if !h.config.link {
+		return "needs -link", nil
+	}
+	var uri struct {
+		URI       string `json:"uri"`
+		SessionID string `json:"session_id"`
+	}
+	if err := h.request("generate_linking_uri", nil, &uri); err != nil {
+		return "", err
+	}
+	if uri.URI == "" || uri.SessionID == "" {
+		return "", fmt.Errorf("linking URI reply is missing uri or session_id")
+	}
+	return "", nil
+}
+
+func checkRegister(h *harness) (string, error) {
+	if h.config.register == "" || h.config.codeCommand == "" {
+		return "needs -register and -code-command", nil
+	}
+	register := map[string]interface{}{"account": h.config.register}
+	if h.config.captcha != "" {
+		register["captcha"] = h.config.captcha
+	}
+	if err := h.request("register", register, nil); err != nil {
+		return "", err
+	}
+
+	cmd := exec.Command("sh", "-c", h.config.codeCommand)
+	cmd.Env = append(os.Environ(), "E2E_ACCOUNT="+h.config.register)
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("-code-command failed: %v", err)
+	}
+	code := strings.TrimSpace(string(out))
+	return "", h.request("verify", map[string]string{"account": h.config.register, "code": code}, nil)
+}
+
+func checkAccounts(h *harness) (string, error) {
+	if h.config.accountA == "" || h.config.accountB == "" {
+		return "needs -account-a and -account-b", nil
+	}
+	var list struct {
+		Accounts []struct {
+			AccountID string `json:"account_id"`
+		} `json:"accounts"`
+	}
+	if err := h.request("list_accounts", nil, &list); err != nil {
+		return "", err
+	}
+	for _, want := range []string{h.config.accountA, h.config.accountB} {
+		found := false
+		for _, a := range list.Accounts {
+			found = found || a.AccountID == want
+		}
+		if !found {
+			return "", fmt.Errorf("%s is not in the seed data", want)
+		}
+	}
+	return "", nil
+}
+
+// checkSend sends a message with a unique body and waits for the recipient to receive it
+func checkSend(h *harness, from, to string) (string, error) {
+	if from == "" || to == "" {
+		return "needs -account-a and -account-b", nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), h.timeout)
+	defer cancel()
+	if _, err := h.conn.Request(ctx, "v0", "subscribe", map[string]string{"username": to}); err != nil {
+		return "", fmt.Errorf("error subscribing %s: %v", to, err)
+	}
+
+	nonce := make([]byte, 8)
+	rand.Read(nonce)
+	body := "signald e2e " + hex.EncodeToString(nonce)
+	var sent struct {
+		Results []struct {
+			NetworkFailure      bool   `json:"networkFailure"`
+			UnregisteredFailure bool   `json:"unregisteredFailure"`
+			IdentityFailure     string `json:"identityFailure"`
+		} `json:"results"`
+	}
+	send := map[string]interface{}{"username": from, "recipientAddress": map[string]string{"number": to}, "messageBody": body}
+	if err := h.conn.RequestInto(ctx, "v1", "send", send, &sent); err != nil {
+		return "", err
+	}
+	for _, r := range sent.Results {
+		switch {
+		case r.NetworkFailure:
+			return "", fmt.Errorf("send reported a network failure")
+		case r.UnregisteredFailure:
+			return "", fmt.Errorf("send reported %s as unregistered", to)
+		case r.IdentityFailure != "":
+			return "", fmt.Errorf("send reported an identity failure")
+		}
+	}
+
+	for {
+		select {
+		case m, ok := <-h.incoming:
+			if !ok {
+				return "", socket.ErrClosed
+			}
+			if m.Type != "message" {
+				continue
+			}
+			var envelope struct {
+				Username    string `json:"username"`
+				DataMessage *struct {
+					Body string `json:"body"`
+				} `json:"dataMessage"`
+			}
+			if json.Unmarshal(m.Data, &envelope) == nil && envelope.Username == to && envelope.DataMessage != nil && envelope.DataMessage.Body == body {
+				return "", nil
+			}
+		case <-ctx.Done():
+			return "", fmt.Errorf("%s did not receive the message within %s", to, h.timeout)
+		}
+	}
+}
+
+func printReport(results []result) {
+	passed, failed, skipped := 0, 0, 0
+	for _, r := range results {
+		var status aurora.Value
+		switch r.Status {
+		case statusPass:
+			passed++
+			status = aurora.Green(r.Status)
+		case statusFail:
+			failed++
+			status = aurora.Bold(aurora.Red(r.Status))
+		default:
+			skipped++
+			status = aurora.Yellow(r.Status)
+		}
+		line := fmt.Sprintf("%s %s", status, r.Step)
+		if r.Duration != "" {
+			line += " (" + r.Duration + ")"
+		}
+		if r.Reason != "" {
+			line += ": " + r.Reason
+		}
+		fmt.Println(line)
+	}
+	fmt.Printf("\n%d passed, %d failed, %d skipped\n", passed, failed, skipped)
+}
+
+// copyDir copies the contents of src into dst, which must exist
+func copyDir(src, dst string) error {
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil || rel == "." {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		if info.IsDir() {
+			return os.MkdirAll(target, info.Mode().Perm()|0700)
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		in, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+		out, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm())
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(out, in); err != nil {
+			out.Close()
+			return err
+		}
+		return out.Close()
+	})
+}
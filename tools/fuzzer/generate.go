@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"math/rand"
+	"sort"
+	"strings"
+
+	"gitlab.com/signald/signald/internal/protocol"
+)
+
+// maximum nesting depth for generated objects, the protocol has a few self referencing types
+const maxDepth = 4
+
+type generator struct {
+	rand     *rand.Rand
+	protocol *protocol.Protocol
+	account  string
+
+	// length of strings sent by the oversized_string mutation
+	maxString int
+}
+
+// request builds a valid request for a documented action: every required field and a random selection of the others
+func (g *generator) request(version, action string) map[string]interface{} {
+	a := g.protocol.Action(version, action)
+	body := map[string]interface{}{}
+	if a != nil && a.Request != "" {
+		if object, ok := g.object(version, a.Request, 0).(map[string]interface{}); ok {
+			body = object
+		}
+	}
+	body["type"] = action
+	body["version"] = version
+	return body
+}
+
+func (g *generator) object(version, typeName string, depth int) interface{} {
+	t := g.protocol.Type(version, typeName)
+	if t == nil || depth > maxDepth {
+		return map[string]interface{}{}
+	}
+	object := map[string]interface{}{}
+	for _, name := range fieldNames(t) {
+		field := t.Fields[name]
+		if !field.Required && g.rand.Intn(2) == 0 {
+			continue
+		}
+		object[name] = g.field(name, field, depth)
+	}
+	return object
+}
+
+func (g *generator) field(name string, field *protocol.DataType, depth int) interface{} {
+	if field.List {
+		single := *field
+		single.List = false
+		list := []interface{}{}
+		for i := g.rand.Intn(4); i > 0; i-- {
+			list = append(list, g.field(name, &single, depth))
+		}
+		return list
+	}
+
+	// prefer the configured account and documented examples, so more requests get past the first lookup
+	if g.account != "" && (name == "account" || name == "username") && field.Type == "String" {
+		return g.account
+	}
+	if field.Example != "" && g.rand.Intn(2) == 0 {
+		var example interface{}
+		if json.Unmarshal([]byte(field.Example), &example) == nil {
+			return example
+		}
+	}
+
+	if field.Version != "" {
+		return g.object(field.Version, field.Type, depth+1)
+	}
+	return g.primitive(field.Type)
+}
+
+func (g *generator) primitive(javaType string) interface{} {
+	switch javaType {
+	case "String", "char", "Character":
+		return g.string(g.rand.Intn(32))
+	case "UUID":
+		return g.uuid()
+	case "int", "Integer", "short", "Short", "byte", "Byte":
+		return g.rand.Int31() - math.MaxInt32/2
+	case "long", "Long":
+		return g.rand.Int63() - math.MaxInt64/2
+	case "float", "Float", "double", "Double":
+		return g.rand.NormFloat64() * 1000
+	case "boolean", "Boolean":
+		return g.rand.Intn(2) == 0
+	default:
+		return map[string]interface{}{}
+	}
+}
+
+const stringAlphabet = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789+-_=/ .:\"\\\n\té中\U0001F600"
+
+func (g *generator) string(length int) string {
+	alphabet := []rune(stringAlphabet)
+	var b strings.Builder
+	for i := 0; i < length; i++ {
+		b.WriteRune(alphabet[g.rand.Intn(len(alphabet))])
+	}
+	return b.String()
+}
+
+func (g *generator) uuid() string {
+	const hex = "0123456789abcdef"
+	b := []byte("xxxxxxxx-xxxx-4xxx-8xxx-xxxxxxxxxxxx")
+	for i, c := range b {
+		if c == 'x' {
+			b[i] = hex[g.rand.Intn(len(hex))]
+		}
+	}
+	return string(b)
+}
+
+func fieldNames(t *protocol.Type) []string {
+	names := make([]string, 0, len(t.Fields))
+	for name := range t.Fields {
+		names = append(names, name)
+	}
+	// map order is random, sort so a seed always produces the same requests
+	sort.Strings(names)
+	return names
+}
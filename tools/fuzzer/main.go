@@ -0,0 +1,281 @@
+// fuzzer sends random valid and deliberately malformed requests, generated from the protocol documentation,
+// to a signald socket and records the ones that crash it, hang, or fail in ways the protocol does not describe.
+//
+// Only point it at a signald you don't mind breaking. By default it only uses actions that don't change any state.
+//
+//	go run ./tools/fuzzer -socket /tmp/signald.sock -account +12024561414 -n 5000
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math/rand"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	aurora "github.com/logrusorgru/aurora/v3"
+
+	"gitlab.com/signald/signald/internal/protocol"
+	"gitlab.com/signald/signald/internal/socket"
+)
+
+// actions that only read state, fuzzing anything else can send messages, leave groups or delete accounts
+var readOnlyActions = []string{
+	"version", "protocol", "list_accounts", "list_groups", "list_contacts", "get_profile", "get_group",
+	"get_identities", "get_all_identities", "get_linked_devices", "resolve_address",
+}
+
+// messages signald sends without being asked, which never answer a request
+var unsolicited = map[string]bool{"version": true, "message": true, "listen_started": true, "listen_stopped": true}
+
+const (
+	findingCrash       = "crash"               // signald closed the connection
+	findingHang        = "hang"                // no reply within -timeout
+	findingUnhandled   = "unhandled_exception" // the request handler threw something it doesn't declare
+	findingUntyped     = "untyped_error"       // an error without an error_type a client could act on
+	findingUnexpected  = "unexpected_error"    // the legacy catch-all error for a request that was valid JSON
+	findingBadResponse = "invalid_response"    // a successful reply that does not match the documented response type
+)
+
+type finding struct {
+	Time      time.Time       `json:"time"`
+	Seed      int64           `json:"seed"`
+	Iteration int             `json:"iteration"`
+	Kind      string          `json:"kind"`
+	Action    string          `json:"action"`
+	Mutation  string          `json:"mutation"`
+	Request   string          `json:"request"`
+	Response  json.RawMessage `json:"response,omitempty"`
+	Problems  []string        `json:"problems,omitempty"`
+}
+
+// requests are cut down to this many bytes in findings, oversized strings are not interesting to read back
+const maxRecordedRequest = 4096
+
+func main() {
+	socketPath := flag.String("socket", socket.DefaultPath, "path to the signald socket")
+	protocolPath := flag.String("protocol", "", "protocol.json to generate requests from (default: ask signald for its own)")
+	account := flag.String("account", "", "local account to use in account fields, so requests get past the account lookup")
+	seed := flag.Int64("seed", time.Now().UnixNano(), "random seed, reuse it to repeat a run")
+	iterations := flag.Int("n", 1000, "number of requests to send")
+	timeout := flag.Duration("timeout", 10*time.Second, "how long to wait for a reply before recording a hang")
+	actionList := flag.String("actions", strings.Join(readOnlyActions, ","), "comma separated actions to fuzz, or \"all\" for every documented action")
+	mutationList := flag.String("mutations", "", "comma separated mutations to use (default: all)")
+	maxString := flag.Int("max-string", 1<<20, "length of strings sent by the oversized_string mutation")
+	out := flag.String("out", "fuzz-findings.ndjson", "file to append findings to")
+	flag.Parse()
+
+	conn, err := socket.Dial(*socketPath)
+	if err != nil {
+		fmt.Println(aurora.Red("error connecting to signald"))
+		panic(err)
+	}
+
+	var p *protocol.Protocol
+	if *protocolPath != "" {
+		p, err = protocol.Load(*protocolPath)
+	} else {
+		ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+		p, err = protocol.Fetch(ctx, conn)
+		cancel()
+	}
+	if err != nil {
+		fmt.Println(aurora.Red("error loading protocol documentation"))
+		panic(err)
+	}
+
+	actions := selectActions(p, *actionList)
+	selectedMutations := selectMutations(*mutationList)
+
+	findings, err := os.OpenFile(*out, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		panic(err)
+	}
+	defer findings.Close()
+	encoder := json.NewEncoder(findings)
+
+	fmt.Printf("fuzzing %d actions with seed %d\n", len(actions), *seed)
+	g := &generator{rand: rand.New(rand.NewSource(*seed)), protocol: p, account: *account, maxString: *maxString}
+	incoming, stop := conn.Listen()
+	counts := map[string]int{}
+
+	for i := 0; i < *iterations; i++ {
+		action := actions[g.rand.Intn(len(actions))]
+		m := selectedMutations[g.rand.Intn(len(selectedMutations))]
+		id := fmt.Sprintf("fuzz-%d", i)
+		body := g.request("v1", action)
+		body["id"] = id
+		line, hasID := m.apply(g, "v1", action, body)
+
+		f := finding{Time: time.Now().UTC(), Seed: *seed, Iteration: i, Action: action, Mutation: m.name, Request: string(line)}
+		if len(f.Request) > maxRecordedRequest {
+			f.Request = f.Request[:maxRecordedRequest] + "..."
+		}
+
+		if err := conn.WriteRaw(line); err != nil {
+			f.Kind = findingCrash
+		} else {
+			resp, raw, ok := awaitReply(incoming, id, hasID, *timeout)
+			switch {
+			case !ok && raw == nil:
+				f.Kind = findingCrash
+			case !ok:
+				f.Kind = findingHang
+			default:
+				f.Response = raw
+				f.Kind, f.Problems = classify(p, action, m.name, hasID, resp)
+			}
+		}
+
+		if f.Kind == "" {
+			continue
+		}
+		counts[f.Kind]++
+		if err := encoder.Encode(f); err != nil {
+			panic(err)
+		}
+		fmt.Println(aurora.Yellow(fmt.Sprintf("#%d %s: %s (%s)", i, f.Kind, action, m.name)))
+
+		if f.Kind == findingCrash || f.Kind == findingHang {
+			// start over with a fresh connection, a hung request would otherwise answer a later one
+			stop()
+			conn.Close()
+			next, err := socket.Dial(*socketPath)
+			if err != nil {
+				fmt.Println(aurora.Bold(aurora.Red("signald is no longer accepting connections, stopping")))
+				conn = nil
+				break
+			}
+			conn = next
+			incoming, stop = conn.Listen()
+		}
+	}
+	if conn != nil {
+		stop()
+		conn.Close()
+	}
+
+	total := 0
+	kinds := []string{}
+	for kind, n := range counts {
+		kinds = append(kinds, kind)
+		total += n
+	}
+	sort.Strings(kinds)
+	for _, kind := range kinds {
+		fmt.Printf("%s: %d\n", kind, counts[kind])
+	}
+	fmt.Printf("%d findings written to %s (seed %d)\n", total, *out, *seed)
+	if total > 0 {
+		os.Exit(1)
+	}
+}
+
+// awaitReply waits for the reply to id. Malformed requests have no usable id, signald answers those with an id-less error.
+// ok is false on timeout, and raw is also nil if the connection closed.
+func awaitReply(incoming <-chan socket.Response, id string, hasID bool, timeout time.Duration) (resp socket.Response, raw json.RawMessage, ok bool) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		select {
+		case r, open := <-incoming:
+			if !open {
+				return r, nil, false
+			}
+			matches := r.ID == id
+			if !hasID {
+				matches = r.ID == "" && !unsolicited[r.Type]
+			}
+			if !matches {
+				continue
+			}
+			b, err := json.Marshal(r)
+			if err != nil {
+				panic(err)
+			}
+			return r, b, true
+		case <-timer.C:
+			return resp, json.RawMessage{}, false
+		}
+	}
+}
+
+func classify(p *protocol.Protocol, action, mutation string, hasID bool, resp socket.Response) (string, []string) {
+	hasError := len(resp.Error) > 0 && string(resp.Error) != "null"
+	switch {
+	case resp.ErrorType == "RequestProcessingError":
+		return findingUnhandled, nil
+	case hasError && resp.ErrorType == "":
+		return findingUntyped, nil
+	case resp.Type == "unexpected_error":
+		if hasID {
+			return findingUnexpected, nil
+		}
+		// unparsable requests can't be answered any other way
+		return "", nil
+	case mutation == "valid" && !hasError:
+		a := p.Action("v1", action)
+		if a == nil || a.Response == "" || len(resp.Data) == 0 {
+			return "", nil
+		}
+		problems := []string{}
+		for _, problem := range p.Validate("v1", a.Response, resp.Data) {
+			problems = append(problems, problem.String())
+		}
+		if len(problems) > 0 {
+			return findingBadResponse, problems
+		}
+	}
+	return "", nil
+}
+
+func selectActions(p *protocol.Protocol, list string) []string {
+	actions := []string{}
+	if list == "all" {
+		for name := range p.Actions["v1"] {
+			actions = append(actions, name)
+		}
+		sort.Strings(actions)
+		return actions
+	}
+	for _, name := range strings.Split(list, ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		if p.Action("v1", name) == nil {
+			fmt.Println(aurora.Yellow("skipping " + name + ", it is not documented in the protocol"))
+			continue
+		}
+		actions = append(actions, name)
+	}
+	if len(actions) == 0 {
+		fmt.Println(aurora.Red("no actions to fuzz"))
+		os.Exit(2)
+	}
+	return actions
+}
+
+func selectMutations(list string) []mutation {
+	if list == "" {
+		return mutations
+	}
+	selected := []mutation{}
+	for _, name := range strings.Split(list, ",") {
+		found := false
+		for _, m := range mutations {
+			if m.name == strings.TrimSpace(name) {
+				selected = append(selected, m)
+				found = true
+			}
+		}
+		if !found {
+			fmt.Println(aurora.Red("unknown mutation " + name))
+			os.Exit(2)
+		}
+	}
+	return selected
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// a mutation turns a valid request into a (usually) invalid one. It returns the line to send and whether the line
+// still contains a usable id, malformed JSON can't be matched to its reply.
+type mutation struct {
+	name  string
+	apply func(g *generator, version, action string, body map[string]interface{}) (line []byte, hasID bool)
+}
+
+var mutations = []mutation{
+	{"valid", func(_ *generator, _, _ string, body map[string]interface{}) ([]byte, bool) {
+		return marshal(body), true
+	}},
+	{"missing_required", func(g *generator, version, action string, body map[string]interface{}) ([]byte, bool) {
+		if required := g.requiredFields(version, action); len(required) > 0 {
+			delete(body, required[g.rand.Intn(len(required))])
+		}
+		return marshal(body), true
+	}},
+	{"wrong_type", func(g *generator, _, _ string, body map[string]interface{}) ([]byte, bool) {
+		if name := g.pickField(body); name != "" {
+			body[name] = g.differentKind(body[name])
+		}
+		return marshal(body), true
+	}},
+	{"null_field", func(g *generator, _, _ string, body map[string]interface{}) ([]byte, bool) {
+		if name := g.pickField(body); name != "" {
+			body[name] = nil
+		}
+		return marshal(body), true
+	}},
+	{"oversized_string", func(g *generator, _, _ string, body map[string]interface{}) ([]byte, bool) {
+		name := g.pickField(body)
+		if _, ok := body[name].(string); !ok {
+			name = "oversized"
+		}
+		body[name] = strings.Repeat("A", g.maxString)
+		return marshal(body), true
+	}},
+	{"unknown_field", func(g *generator, _, _ string, body map[string]interface{}) ([]byte, bool) {
+		body["fuzz_"+g.string(8)] = g.primitive("String")
+		return marshal(body), true
+	}},
+	{"wrong_version", func(g *generator, _, _ string, body map[string]interface{}) ([]byte, bool) {
+		body["version"] = fmt.Sprintf("v%d", 2+g.rand.Intn(100))
+		return marshal(body), true
+	}},
+	{"unknown_action", func(g *generator, _, _ string, body map[string]interface{}) ([]byte, bool) {
+		body["type"] = "fuzz_" + g.string(8)
+		return marshal(body), true
+	}},
+	{"truncated_json", func(g *generator, _, _ string, body map[string]interface{}) ([]byte, bool) {
+		line := marshal(body)
+		return line[:1+g.rand.Intn(len(line)-1)], false
+	}},
+	{"not_an_object", func(g *generator, _, _ string, _ map[string]interface{}) ([]byte, bool) {
+		values := []string{`[]`, `"request"`, `42`, `null`, `true`, `[{"type":"version"}]`}
+		return []byte(values[g.rand.Intn(len(values))]), false
+	}},
+}
+
+func marshal(body map[string]interface{}) []byte {
+	b, err := json.Marshal(body)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+func (g *generator) requiredFields(version, action string) []string {
+	a := g.protocol.Action(version, action)
+	if a == nil {
+		return nil
+	}
+	t := g.protocol.Type(version, a.Request)
+	if t == nil {
+		return nil
+	}
+	required := []string{}
+	for _, name := range fieldNames(t) {
+		if t.Fields[name].Required {
+			required = append(required, name)
+		}
+	}
+	return required
+}
+
+// pickField chooses one of the request's own fields, never the envelope fields that route it
+func (g *generator) pickField(body map[string]interface{}) string {
+	names := []string{}
+	for name := range body {
+		if name != "type" && name != "version" && name != "id" {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return ""
+	}
+	sort.Strings(names)
+	return names[g.rand.Intn(len(names))]
+}
+
+func (g *generator) differentKind(v interface{}) interface{} {
+	switch v.(type) {
+	case string:
+		return g.rand.Int63()
+	case bool, int32, int64, float64:
+		return g.string(8)
+	case []interface{}:
+		return map[string]interface{}{"fuzz": g.string(4)}
+	default:
+		return []interface{}{g.string(4), g.rand.Intn(10)}
+	}
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	aurora "github.com/logrusorgru/aurora/v3"
+
+	"gitlab.com/signald/signald/internal/capture"
+	"gitlab.com/signald/signald/internal/protocol"
+)
+
+// section is the shape of one reply. key identifies the reply independently of where it is in the capture.
+type section struct {
+	key   string
+	lines []string
+}
+
+func goldenPath(goldenDir, capturePath string) string {
+	return filepath.Join(goldenDir, strings.TrimSuffix(filepath.Base(capturePath), filepath.Ext(capturePath))+".golden")
+}
+
+// checkCapture validates a capture and compares it to its golden file, or rewrites the golden file with update
+func checkCapture(p *protocol.Protocol, capturePath, goldenDir string, update bool) ([]string, error) {
+	records, err := capture.ReadFile(capturePath)
+	if err != nil {
+		return nil, err
+	}
+	sections, problems := check(p, records)
+
+	path := goldenPath(goldenDir, capturePath)
+	if update {
+		if err := os.MkdirAll(goldenDir, 0755); err != nil {
+			return nil, err
+		}
+		return problems, ioutil.WriteFile(path, format(sections), 0644)
+	}
+	golden, err := ioutil.ReadFile(path)
+	if err != nil {
+		return append(problems, fmt.Sprintf("error reading golden file, run with -update to create it: %v", err)), nil
+	}
+	return append(problems, compare(parse(golden), sections)...), nil
+}
+
+// check pairs replies with their requests, validates them against the protocol and returns the shape of each
+func check(p *protocol.Protocol, records []capture.Record) ([]section, []string) {
+	type request struct{ version, action string }
+	pending := map[int]map[string]request{}
+	unsolicitedCounts := map[string]int{}
+	seen := map[string]int{}
+	sections := []section{}
+	problems := []string{}
+
+	for _, r := range records {
+		envelope, ok := r.Envelope()
+		if !ok {
+			continue
+		}
+		if pending[r.Session] == nil {
+			pending[r.Session] = map[string]request{}
+		}
+		if r.From == capture.FromClient {
+			if envelope.ID != "" {
+				pending[r.Session][envelope.ID] = request{protocol.RequestVersion(envelope.Version, envelope.Type), envelope.Type}
+			}
+			continue
+		}
+
+		var body struct {
+			Data  json.RawMessage `json:"data"`
+			Error json.RawMessage `json:"error"`
+		}
+		json.Unmarshal(r.Message, &body)
+		hasError := len(body.Error) > 0 && string(body.Error) != "null"
+
+		var key string
+		version, typeName := "", ""
+		if req, isReply := pending[r.Session][envelope.ID]; isReply && envelope.ID != "" {
+			delete(pending[r.Session], envelope.ID)
+			key = fmt.Sprintf("session %d id %s: %s %s", r.Session, envelope.ID, req.version, req.action)
+			if a := p.Action(req.version, req.action); a != nil {
+				version, typeName = req.version, a.Response
+			}
+		} else {
+			unsolicited := fmt.Sprintf("session %d: unsolicited %s", r.Session, envelope.Type)
+			unsolicitedCounts[unsolicited]++
+			key = fmt.Sprintf("%s #%d", unsolicited, unsolicitedCounts[unsolicited])
+			if envelope.Type == "message" {
+				version, typeName = "v1", "JsonMessageEnvelope"
+			}
+		}
+		// clients may reuse ids within a session
+		if seen[key]++; seen[key] > 1 {
+			key = fmt.Sprintf("%s (%d)", key, seen[key])
+		}
+
+		if typeName != "" && !hasError && len(body.Data) > 0 && string(body.Data) != "null" {
+			for _, problem := range p.Validate(version, typeName, body.Data) {
+				problems = append(problems, key+": "+problem.String())
+			}
+		}
+		sections = append(sections, section{key: key, lines: shape(r.Message)})
+	}
+	return sections, problems
+}
+
+// shape lists every field path in a JSON message with the kind of value it holds. List items are merged under path[].
+func shape(message json.RawMessage) []string {
+	decoder := json.NewDecoder(bytes.NewReader(message))
+	decoder.UseNumber()
+	var v interface{}
+	if err := decoder.Decode(&v); err != nil {
+		return []string{"not json"}
+	}
+	seen := map[string]bool{}
+	walk("", v, seen)
+	lines := make([]string, 0, len(seen))
+	for line := range seen {
+		lines = append(lines, line)
+	}
+	sort.Strings(lines)
+	return lines
+}
+
+func walk(path string, v interface{}, seen map[string]bool) {
+	kind := ""
+	switch value := v.(type) {
+	case nil:
+		kind = "null"
+	case string:
+		kind = "string"
+	case bool:
+		kind = "boolean"
+	case json.Number:
+		kind = "integer"
+		if strings.ContainsAny(value.String(), ".eE") {
+			kind = "number"
+		}
+	case []interface{}:
+		kind = "list"
+		for _, item := range value {
+			walk(path+"[]", item, seen)
+		}
+	case map[string]interface{}:
+		kind = "object"
+		for k, child := range value {
+			if path == "" {
+				walk(k, child, seen)
+			} else {
+				walk(path+"."+k, child, seen)
+			}
+		}
+	}
+	if path != "" {
+		seen[path+": "+kind] = true
+	}
+}
+
+func format(sections []section) []byte {
+	var b bytes.Buffer
+	for i, s := range sections {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		b.WriteString("# " + s.key + "\n")
+		for _, line := range s.lines {
+			b.WriteString(line + "\n")
+		}
+	}
+	return b.Bytes()
+}
+
+func parse(golden []byte) []section {
+	sections := []section{}
+	for _, line := range strings.Split(string(golden), "\n") {
+		switch {
+		case line == "":
+		case strings.HasPrefix(line, "# "):
+			sections = append(sections, section{key: strings.TrimPrefix(line, "# ")})
+		case len(sections) > 0:
+			sections[len(sections)-1].lines = append(sections[len(sections)-1].lines, line)
+		}
+	}
+	return sections
+}
+
+// compare lists the differences between the golden and current shapes, matching replies up by key
+func compare(golden, current []section) []string {
+	currentByKey := map[string]section{}
+	for _, s := range current {
+		currentByKey[s.key] = s
+	}
+	goldenKeys := map[string]bool{}
+	problems := []string{}
+	for _, g := range golden {
+		goldenKeys[g.key] = true
+		c, ok := currentByKey[g.key]
+		if !ok {
+			problems = append(problems, "reply missing from capture: "+g.key)
+			continue
+		}
+		for _, line := range difference(g.lines, c.lines) {
+			problems = append(problems, fmt.Sprintf("%s: %s", g.key, aurora.Red("- "+line)))
+		}
+		for _, line := range difference(c.lines, g.lines) {
+			problems = append(problems, fmt.Sprintf("%s: %s", g.key, aurora.Green("+ "+line)))
+		}
+	}
+	for _, c := range current {
+		if !goldenKeys[c.key] {
+			problems = append(problems, "reply not in golden file: "+c.key)
+		}
+	}
+	return problems
+}
+
+// difference returns the lines in a that are not in b
+func difference(a, b []string) []string {
+	inB := map[string]bool{}
+	for _, line := range b {
+		inB[line] = true
+	}
+	out := []string{}
+	for _, line := range a {
+		if !inB[line] {
+			out = append(out, line)
+		}
+	}
+	return out
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"gitlab.com/signald/signald/internal/capture"
+	"gitlab.com/signald/signald/internal/protocol"
+)
+
+var (
+	protocolFlag = flag.String("protocol", "testdata/protocol.json", "protocol.json to validate the captures against")
+	updateFlag   = flag.Bool("update", false, "rewrite the golden files from the captures")
+)
+
+// TestCaptures checks every capture in testdata/captures against the protocol and its golden file
+func TestCaptures(t *testing.T) {
+	p, err := protocol.Load(*protocolFlag)
+	if err != nil {
+		t.Fatal(err)
+	}
+	captures, err := filepath.Glob("testdata/captures/*.ndjson")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(captures) == 0 {
+		t.Fatal("no captures in testdata/captures")
+	}
+	for _, path := range captures {
+		t.Run(filepath.Base(path), func(t *testing.T) {
+			problems, err := checkCapture(p, path, "testdata", *updateFlag)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, problem := range problems {
+				t.Error(problem)
+			}
+		})
+	}
+}
+
+func record(session int, from, message string) capture.Record {
+	return capture.Record{Session: session, From: from, Message: json.RawMessage(message)}
+}
+
+func keys(sections []section) []string {
+	out := []string{}
+	for _, s := range sections {
+		out = append(out, s.key)
+	}
+	return out
+}
+
+func TestCheckKeys(t *testing.T) {
+	p := &protocol.Protocol{}
+	records := []capture.Record{
+		record(1, capture.FromSignald, `{"type":"version","data":{}}`),
+		record(1, capture.FromClient, `{"id":"a","type":"version","version":"v1"}`),
+		record(1, capture.FromClient, `{"id":"b","type":"send"}`),
+		record(1, capture.FromSignald, `{"id":"b","type":"send","data":{}}`),
+		record(1, capture.FromSignald, `{"type":"message","data":{}}`),
+		record(1, capture.FromSignald, `{"id":"a","type":"version","data":{}}`),
+		record(1, capture.FromSignald, `{"type":"message","data":{}}`),
+		record(2, capture.FromClient, `{"id":"a","type":"list_accounts","version":"v1"}`),
+		record(2, capture.FromSignald, `{"id":"a","type":"list_accounts","data":{}}`),
+		record(2, capture.FromClient, `{"id":"a","type":"list_accounts","version":"v1"}`),
+		record(2, capture.FromSignald, `{"id":"a","type":"list_accounts","data":{}}`),
+	}
+	sections, _ := check(p, records)
+	want := []string{
+		"session 1: unsolicited version #1",
+		"session 1 id b: v0 send",
+		"session 1: unsolicited message #1",
+		"session 1 id a: v1 version",
+		"session 1: unsolicited message #2",
+		"session 2 id a: v1 list_accounts",
+		"session 2 id a: v1 list_accounts (2)",
+	}
+	if got := keys(sections); !reflect.DeepEqual(got, want) {
+		t.Errorf("got keys %q\nexpected %q", got, want)
+	}
+}
+
+func TestCompare(t *testing.T) {
+	golden := []section{
+		{key: "session 1 id 1: v1 version", lines: []string{"data: object", "data.version: string"}},
+		{key: "session 1 id 2: v1 list_accounts", lines: []string{"data: object"}},
+		{key: "session 1 id 3: v1 send", lines: []string{"data: object"}},
+	}
+
+	// a reply inserted before the others only shows up as itself
+	inserted := []section{
+		{key: "session 1 id 0: v1 get_profile", lines: []string{"data: object"}},
+		golden[0], golden[1], golden[2],
+	}
+	if got := compare(golden, inserted); len(got) != 1 || !strings.Contains(got[0], "not in golden file: session 1 id 0") {
+		t.Errorf("expected only the inserted reply to be reported, got %q", got)
+	}
+
+	changed := []section{
+		{key: "session 1 id 1: v1 version", lines: []string{"data: object", "data.version: integer"}},
+		golden[2],
+	}
+	got := compare(golden, changed)
+	if len(got) != 3 {
+		t.Fatalf("expected 3 problems, got %q", got)
+	}
+	for i, want := range []string{"- data.version: string", "+ data.version: integer", "missing from capture: session 1 id 2"} {
+		if !strings.Contains(got[i], want) {
+			t.Errorf("problem %d is %q, expected it to mention %q", i, got[i], want)
+		}
+	}
+}
+
+func TestFormatParse(t *testing.T) {
+	sections := []section{
+		{key: "session 1 id 1: v1 version", lines: []string{"data: object", "type: string"}},
+		{key: "session 1: unsolicited message #1", lines: []string{"type: string"}},
+	}
+	if got := parse(format(sections)); !reflect.DeepEqual(got, sections) {
+		t.Errorf("round trip changed the sections: %v", got)
+	}
+}
@@ -0,0 +1,73 @@
+// golden checks recorded signald replies against the protocol documentation and against committed golden files,
+// to catch changes in how the Java side serializes its responses.
+//
+// A golden file holds the shape of every reply in a capture: each field path with the kind of JSON value it had.
+// Values are left out, so a capture can be re-recorded without touching the golden files as long as the
+// serialization stays the same. Replies are keyed by session and request id, unsolicited messages by their type and
+// how many of that type came before, so a reply added to a capture doesn't shift every reply after it. Captures
+// should be redacted with signald-proxy before they're committed.
+//
+// The captures in testdata/captures are checked by go test, against testdata/protocol.json or, in CI, against the
+// protocol dumped from the current build:
+//
+//	go test ./tools/golden
+//	go test ./tools/golden -args -protocol $PWD/protocol.json
+//	go test ./tools/golden -args -update
+//
+// The command does the same for captures kept elsewhere:
+//
+//	go run ./tools/golden -protocol protocol.json captures/*.ndjson
+//	go run ./tools/golden -protocol protocol.json -update captures/*.ndjson
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	aurora "github.com/logrusorgru/aurora/v3"
+
+	"gitlab.com/signald/signald/internal/protocol"
+)
+
+func main() {
+	protocolPath := flag.String("protocol", "", "protocol.json to validate replies against")
+	goldenDir := flag.String("golden", "tools/golden/testdata", "directory holding a .golden file for each capture")
+	update := flag.Bool("update", false, "rewrite the golden files from the captures instead of comparing")
+	flag.Parse()
+	if *protocolPath == "" || flag.NArg() == 0 {
+		fmt.Println("usage: golden -protocol protocol.json [-golden dir] [-update] capture.ndjson [capture.ndjson ...]")
+		os.Exit(2)
+	}
+
+	p, err := protocol.Load(*protocolPath)
+	if err != nil {
+		fmt.Println(aurora.Red("error loading protocol documentation"))
+		panic(err)
+	}
+
+	failed := false
+	for _, path := range flag.Args() {
+		problems, err := checkCapture(p, path, *goldenDir, *update)
+		if err != nil {
+			fmt.Println(aurora.Red(err.Error()))
+			os.Exit(1)
+		}
+		if *update {
+			fmt.Println("updated " + goldenPath(*goldenDir, path))
+		}
+
+		if len(problems) == 0 {
+			fmt.Printf("%s %s\n", aurora.Green("ok"), path)
+			continue
+		}
+		failed = true
+		fmt.Printf("%s %s\n", aurora.Bold(aurora.Red("FAIL")), path)
+		for _, problem := range problems {
+			fmt.Println("    " + problem)
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,275 @@
+// grpc-proto generates a gRPC service definition from the protocol documentation, for a gateway that exposes signald
+// actions as gRPC methods. Every documented action becomes a method named after its version and action
+// (v1 list_accounts is V1ListAccounts), every documented type a message, and incoming messages a server-streaming
+// Receive method.
+//
+// Protobuf identifies fields by number rather than name, so the numbers are kept in a file next to the generated
+// definition. Fields that are new to the documentation get the next free number, fields that were removed stay
+// reserved. Commit the numbers file along with signald.proto, regenerating without it renumbers everything.
+//
+//	go run ./tools/grpc-proto -protocol protocol.json -numbers signald.numbers.json > signald.proto
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	aurora "github.com/logrusorgru/aurora/v3"
+
+	"gitlab.com/signald/signald/internal/protocol"
+)
+
+// Java types that signald serializes as JSON primitives, anything else without a version becomes a google.protobuf.Value
+var scalarTypes = map[string]string{
+	"String": "string", "UUID": "string", "char": "string", "Character": "string",
+	"int": "int32", "Integer": "int32", "short": "int32", "Short": "int32", "byte": "int32", "Byte": "int32",
+	"long": "int64", "Long": "int64",
+	"float": "float", "Float": "float",
+	"double": "double", "Double": "double",
+	"boolean": "bool", "Boolean": "bool",
+}
+
+// numbering is the field numbers by message and field name, as stored in the -numbers file
+type numbering map[string]map[string]int
+
+func main() {
+	protocolPath := flag.String("protocol", "", "protocol.json to generate the service from")
+	numbersPath := flag.String("numbers", "", "file to keep field numbers in, read if it exists and rewritten after generating")
+	packageName := flag.String("package", "signald", "protobuf package of the generated definition")
+	flag.Parse()
+	if *protocolPath == "" || flag.NArg() != 0 {
+		fmt.Fprintln(os.Stderr, "usage: grpc-proto -protocol protocol.json [-numbers signald.numbers.json] [-package signald] > signald.proto")
+		os.Exit(2)
+	}
+
+	p, err := protocol.Load(*protocolPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, aurora.Red("error loading protocol documentation"))
+		panic(err)
+	}
+
+	numbers := numbering{}
+	if *numbersPath != "" {
+		b, err := ioutil.ReadFile(*numbersPath)
+		if err == nil {
+			err = json.Unmarshal(b, &numbers)
+		}
+		if err != nil && !os.IsNotExist(err) {
+			fmt.Fprintln(os.Stderr, aurora.Red(fmt.Sprintf("error reading %s: %v", *numbersPath, err)))
+			os.Exit(1)
+		}
+	}
+
+	fmt.Print(generate(p, *packageName, numbers))
+
+	if *numbersPath != "" {
+		b, err := json.MarshalIndent(numbers, "", "  ")
+		if err != nil {
+			panic(err)
+		}
+		if err := ioutil.WriteFile(*numbersPath, append(b, '\n'), 0644); err != nil {
+			fmt.Fprintln(os.Stderr, aurora.Red(err.Error()))
+			os.Exit(1)
+		}
+	}
+}
+
+func generate(p *protocol.Protocol, packageName string, numbers numbering) string {
+	var b strings.Builder
+	source := strings.TrimSpace(p.Version.Name + " " + p.Version.Version)
+	if p.Version.Commit != "" {
+		source += " (" + p.Version.Commit + ")"
+	}
+	if source == "" {
+		source = "protocol.json"
+	}
+	fmt.Fprintf(&b, "// Code generated by tools/grpc-proto from %s. DO NOT EDIT.\n\n", source)
+	b.WriteString("syntax = \"proto3\";\n\n")
+	fmt.Fprintf(&b, "package %s;\n\n", packageName)
+	b.WriteString("import \"google/protobuf/empty.proto\";\nimport \"google/protobuf/struct.proto\";\n\n")
+
+	b.WriteString("service Signald {\n")
+	b.WriteString("  // Receive streams incoming messages and other unsolicited events for an account, like subscribe does on the socket\n")
+	b.WriteString("  rpc Receive(ReceiveRequest) returns (stream IncomingMessage);\n")
+	for _, version := range sortedActionVersions(p.Actions) {
+		for _, name := range sortedActionNames(p.Actions[version]) {
+			a := p.Actions[version][name]
+			b.WriteString("\n")
+			writeDoc(&b, "  ", a.Doc)
+			request, response := "google.protobuf.Empty", "google.protobuf.Empty"
+			if a.Request != "" {
+				request = messageName(version, a.Request)
+			}
+			if a.Response != "" {
+				response = messageName(version, a.Response)
+			}
+			fmt.Fprintf(&b, "  rpc %s(%s) returns (%s)", methodName(version, name), request, response)
+			if a.Deprecated {
+				b.WriteString(" {\n    option deprecated = true;\n  }\n")
+			} else {
+				b.WriteString(";\n")
+			}
+		}
+	}
+	b.WriteString("}\n\n")
+
+	b.WriteString("message ReceiveRequest {\n  string account = 1;\n}\n\n")
+	b.WriteString("// IncomingMessage is one unsolicited message from signald, data is as documented for its type\n")
+	b.WriteString("message IncomingMessage {\n  string type = 1;\n  google.protobuf.Value data = 2;\n}\n")
+
+	for _, version := range sortedTypeVersions(p.Types) {
+		for _, name := range sortedTypeNames(p.Types[version]) {
+			b.WriteString("\n")
+			writeMessage(&b, version, name, p.Types[version][name], numbers)
+		}
+	}
+	return b.String()
+}
+
+func writeMessage(b *strings.Builder, version, name string, t *protocol.Type, numbers numbering) {
+	key := version + "." + name
+	assigned := numbers[key]
+	if assigned == nil {
+		assigned = map[string]int{}
+		numbers[key] = assigned
+	}
+	next := 1
+	for _, n := range assigned {
+		if n >= next {
+			next = n + 1
+		}
+	}
+
+	writeDoc(b, "", t.Doc)
+	fmt.Fprintf(b, "message %s {\n", messageName(version, name))
+	if t.Deprecated {
+		b.WriteString("  option deprecated = true;\n")
+	}
+	for _, fieldName := range sortedFieldNames(t.Fields) {
+		f := t.Fields[fieldName]
+		number, ok := assigned[fieldName]
+		if !ok {
+			number = next
+			assigned[fieldName] = number
+			next++
+		}
+		writeDoc(b, "  ", f.Doc)
+		b.WriteString("  ")
+		if f.List {
+			b.WriteString("repeated ")
+		}
+		fmt.Fprintf(b, "%s %s = %d", fieldType(f), fieldIdentifier(fieldName), number)
+		// proto3 JSON would otherwise camelCase names like error_type, the gateway relies on it matching signald
+		fmt.Fprintf(b, " [json_name = %q];\n", fieldName)
+	}
+	for _, fieldName := range sortedNumberedFields(assigned) {
+		if _, ok := t.Fields[fieldName]; !ok {
+			fmt.Fprintf(b, "  reserved %d;\n  reserved %q;\n", assigned[fieldName], fieldIdentifier(fieldName))
+		}
+	}
+	b.WriteString("}\n")
+}
+
+func fieldType(f *protocol.DataType) string {
+	if f.Version != "" {
+		return messageName(f.Version, f.Type)
+	}
+	if t, ok := scalarTypes[f.Type]; ok {
+		return t
+	}
+	return "google.protobuf.Value"
+}
+
+// messageName prefixes type names with their version since the same name is documented in several versions
+func messageName(version, name string) string {
+	return strings.ToUpper(version[:1]) + version[1:] + name
+}
+
+func methodName(version, action string) string {
+	name := strings.ToUpper(version[:1]) + version[1:]
+	for _, word := range strings.Split(action, "_") {
+		if word != "" {
+			name += strings.ToUpper(word[:1]) + word[1:]
+		}
+	}
+	return name
+}
+
+var notIdentifier = regexp.MustCompile(`[^A-Za-z0-9_]`)
+
+func fieldIdentifier(name string) string {
+	name = notIdentifier.ReplaceAllString(name, "_")
+	if name == "" || (name[0] >= '0' && name[0] <= '9') {
+		name = "_" + name
+	}
+	return name
+}
+
+func writeDoc(b *strings.Builder, indent, doc string) {
+	if doc == "" {
+		return
+	}
+	for _, line := range strings.Split(strings.TrimSpace(doc), "\n") {
+		b.WriteString(strings.TrimRight(indent+"// "+line, " ") + "\n")
+	}
+}
+
+func sortedActionVersions(m map[string]map[string]*protocol.Action) []string {
+	keys := []string{}
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func sortedActionNames(m map[string]*protocol.Action) []string {
+	keys := []string{}
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func sortedTypeVersions(m map[string]map[string]*protocol.Type) []string {
+	keys := []string{}
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func sortedTypeNames(m map[string]*protocol.Type) []string {
+	keys := []string{}
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func sortedFieldNames(m map[string]*protocol.DataType) []string {
+	keys := []string{}
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func sortedNumberedFields(m map[string]int) []string {
+	keys := []string{}
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
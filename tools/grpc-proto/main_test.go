@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"gitlab.com/signald/signald/internal/protocol"
+)
+
+func mustDecode(t *testing.T, doc string) *protocol.Protocol {
+	t.Helper()
+	p, err := protocol.Decode(strings.NewReader(doc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// roundTrip stores numbers the way main does between runs
+func roundTrip(t *testing.T, numbers numbering) numbering {
+	t.Helper()
+	b, err := json.Marshal(numbers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored := numbering{}
+	if err := json.Unmarshal(b, &stored); err != nil {
+		t.Fatal(err)
+	}
+	return stored
+}
+
+func TestRegenerateKeepsFieldNumbers(t *testing.T) {
+	numbers := numbering{}
+	generate(mustDecode(t, `{"types":{"v1":{"SendRequest":{"fields":{
+		"username":{"type":"String"},
+		"messageBody":{"type":"String"},
+		"recipientAddress":{"type":"JsonAddress","version":"v1"}
+	}}}}}`), "signald", numbers)
+	first := map[string]int{"messageBody": 1, "recipientAddress": 2, "username": 3}
+	if !reflect.DeepEqual(numbers["v1.SendRequest"], first) {
+		t.Fatalf("got numbers %v, expected %v", numbers["v1.SendRequest"], first)
+	}
+
+	// attachments is added, recipientAddress is removed
+	numbers = roundTrip(t, numbers)
+	proto := generate(mustDecode(t, `{"types":{"v1":{"SendRequest":{"fields":{
+		"username":{"type":"String"},
+		"messageBody":{"type":"String"},
+		"attachments":{"type":"JsonAttachment","version":"v1","list":true}
+	}}}}}`), "signald", numbers)
+
+	want := map[string]int{"messageBody": 1, "recipientAddress": 2, "username": 3, "attachments": 4}
+	if !reflect.DeepEqual(numbers["v1.SendRequest"], want) {
+		t.Errorf("got numbers %v, expected %v", numbers["v1.SendRequest"], want)
+	}
+	tests := []struct {
+		name, line string
+	}{
+		{"kept", `  string messageBody = 1 [json_name = "messageBody"];`},
+		{"kept", `  string username = 3 [json_name = "username"];`},
+		{"added", `  repeated V1JsonAttachment attachments = 4 [json_name = "attachments"];`},
+		{"removed number", `  reserved 2;`},
+		{"removed name", `  reserved "recipientAddress";`},
+	}
+	for _, tt := range tests {
+		if !strings.Contains(proto, tt.line+"\n") {
+			t.Errorf("%s: expected %q in:\n%s", tt.name, tt.line, proto)
+		}
+	}
+	if strings.Contains(proto, "V1JsonAddress recipientAddress") {
+		t.Error("the removed field is still generated")
+	}
+
+	// adding the field back reuses its number rather than the next free one
+	numbers = roundTrip(t, numbers)
+	proto = generate(mustDecode(t, `{"types":{"v1":{"SendRequest":{"fields":{
+		"recipientAddress":{"type":"JsonAddress","version":"v1"}
+	}}}}}`), "signald", numbers)
+	if !strings.Contains(proto, `V1JsonAddress recipientAddress = 2 [json_name = "recipientAddress"];`) {
+		t.Errorf("expected recipientAddress to get 2 back:\n%s", proto)
+	}
+	for _, line := range []string{"  reserved 1;", "  reserved 3;", "  reserved 4;"} {
+		if !strings.Contains(proto, line+"\n") {
+			t.Errorf("expected %q in:\n%s", line, proto)
+		}
+	}
+}
+
+func TestNames(t *testing.T) {
+	tests := []struct {
+		got, want string
+	}{
+		{methodName("v1", "list_accounts"), "V1ListAccounts"},
+		{methodName("v0", "send"), "V0Send"},
+		{messageName("v1", "JsonAddress"), "V1JsonAddress"},
+		{fieldIdentifier("error_type"), "error_type"},
+		{fieldIdentifier("content-type"), "content_type"},
+		{fieldIdentifier("1st"), "_1st"},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("got %s, expected %s", tt.got, tt.want)
+		}
+	}
+}
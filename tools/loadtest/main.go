@@ -0,0 +1,316 @@
+// loadtest opens a number of connections to signald and sends a weighted mix of requests at a target rate,
+// then reports latency percentiles and error rates per action.
+//
+//	go run ./tools/loadtest -account +12024561414 -connections 20 -rate 200 -duration 1m -mix version=1,list_groups=4,list_contacts=2
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math/rand"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	aurora "github.com/logrusorgru/aurora/v3"
+
+	"gitlab.com/signald/signald/internal/socket"
+)
+
+// payloads for actions that need more than an account. Any other action is sent with just the account, if one is set.
+var payloads = map[string]func(account string) interface{}{
+	"version":       func(string) interface{} { return nil },
+	"protocol":      func(string) interface{} { return nil },
+	"list_accounts": func(string) interface{} { return nil },
+	"get_profile": func(account string) interface{} {
+		return map[string]interface{}{"account": account, "address": map[string]string{"number": account}, "async": true}
+	},
+	"list_contacts": func(account string) interface{} {
+		return map[string]interface{}{"account": account, "async": true}
+	},
+}
+
+type weightedAction struct {
+	action string
+	weight int
+}
+
+type sample struct {
+	action  string
+	latency time.Duration
+	err     error
+}
+
+type actionStats struct {
+	Action    string  `json:"action"`
+	Requests  int     `json:"requests"`
+	Errors    int     `json:"errors"`
+	ErrorRate float64 `json:"error_rate"`
+	P50       float64 `json:"p50_ms"`
+	P90       float64 `json:"p90_ms"`
+	P99       float64 `json:"p99_ms"`
+	Max       float64 `json:"max_ms"`
+
+	// the most common error messages and how often they happened
+	TopErrors map[string]int `json:"top_errors,omitempty"`
+}
+
+type report struct {
+	Duration   string        `json:"duration"`
+	Requests   int           `json:"requests"`
+	Throughput float64       `json:"requests_per_second"`
+	Skipped    int           `json:"skipped"`
+	Actions    []actionStats `json:"actions"`
+}
+
+func main() {
+	socketPath := flag.String("socket", socket.DefaultPath, "path to the signald socket")
+	account := flag.String("account", "", "local account for account specific actions")
+	connections := flag.Int("connections", 10, "number of concurrent connections")
+	rate := flag.Float64("rate", 0, "target requests per second across all connections (0: as fast as possible)")
+	duration := flag.Duration("duration", 30*time.Second, "how long to run")
+	timeout := flag.Duration("timeout", 30*time.Second, "how long to wait for each reply")
+	mix := flag.String("mix", "version=1,list_accounts=1", "comma separated action=weight pairs")
+	jsonReport := flag.Bool("json", false, "print the report as JSON")
+	flag.Parse()
+
+	actions, err := parseMix(*mix)
+	if err != nil {
+		fmt.Println(aurora.Red(err.Error()))
+		os.Exit(2)
+	}
+
+	conns := []*socket.Conn{}
+	for i := 0; i < *connections; i++ {
+		conn, err := socket.Dial(*socketPath)
+		if err != nil {
+			fmt.Println(aurora.Red(fmt.Sprintf("error opening connection %d", i+1)))
+			panic(err)
+		}
+		defer conn.Close()
+		conns = append(conns, conn)
+	}
+
+	// workers take a token for every request. Without a rate they never wait, with one a ticker hands out tokens
+	// and counts the ones no worker was free to take, which means signald can't keep up with the target rate.
+	tokens := make(chan struct{})
+	stopTokens := make(chan struct{})
+	tokensDone := make(chan struct{})
+	skipped := 0
+	go func() {
+		defer close(tokensDone)
+		if *rate <= 0 {
+			for {
+				select {
+				case tokens <- struct{}{}:
+				case <-stopTokens:
+					return
+				}
+			}
+		}
+		ticker := time.NewTicker(time.Duration(float64(time.Second) / *rate))
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				select {
+				case tokens <- struct{}{}:
+				default:
+					skipped++
+				}
+			case <-stopTokens:
+				return
+			}
+		}
+	}()
+
+	samples := make(chan sample, 1024)
+	var wg sync.WaitGroup
+	deadline := time.Now().Add(*duration)
+	start := time.Now()
+	for i, conn := range conns {
+		wg.Add(1)
+		go func(conn *socket.Conn, seed int64) {
+			defer wg.Done()
+			r := rand.New(rand.NewSource(seed))
+			for time.Now().Before(deadline) {
+				select {
+				case <-tokens:
+				case <-time.After(time.Until(deadline)):
+					return
+				}
+				action := pick(r, actions)
+				ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+				sent := time.Now()
+				_, err := conn.Request(ctx, "v1", action, payload(action, *account))
+				cancel()
+				samples <- sample{action: action, latency: time.Since(sent), err: err}
+			}
+		}(conn, time.Now().UnixNano()+int64(i))
+	}
+	go func() {
+		wg.Wait()
+		close(samples)
+	}()
+
+	byAction := map[string][]sample{}
+	total := 0
+	for s := range samples {
+		byAction[s.action] = append(byAction[s.action], s)
+		total++
+	}
+	elapsed := time.Since(start)
+	close(stopTokens)
+	<-tokensDone
+
+	rep := report{
+		Duration:   elapsed.Round(time.Millisecond).String(),
+		Requests:   total,
+		Throughput: float64(total) / elapsed.Seconds(),
+		Skipped:    skipped,
+	}
+	for _, a := range actions {
+		if list, ok := byAction[a.action]; ok {
+			rep.Actions = append(rep.Actions, summarize(a.action, list))
+		}
+	}
+
+	if *jsonReport {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(rep); err != nil {
+			panic(err)
+		}
+	} else {
+		printReport(rep)
+	}
+}
+
+func parseMix(mix string) ([]weightedAction, error) {
+	actions := []weightedAction{}
+	for _, part := range strings.Split(mix, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		weight := 1
+		name := part
+		if i := strings.Index(part, "="); i >= 0 {
+			name = part[:i]
+			w, err := strconv.Atoi(part[i+1:])
+			if err != nil || w < 0 {
+				return nil, fmt.Errorf("invalid weight in %q", part)
+			}
+			weight = w
+		}
+		if weight > 0 {
+			actions = append(actions, weightedAction{action: name, weight: weight})
+		}
+	}
+	if len(actions) == 0 {
+		return nil, fmt.Errorf("-mix does not contain any actions")
+	}
+	return actions, nil
+}
+
+func pick(r *rand.Rand, actions []weightedAction) string {
+	total := 0
+	for _, a := range actions {
+		total += a.weight
+	}
+	n := r.Intn(total)
+	for _, a := range actions {
+		if n < a.weight {
+			return a.action
+		}
+		n -= a.weight
+	}
+	return actions[len(actions)-1].action
+}
+
+func payload(action, account string) interface{} {
+	if build, ok := payloads[action]; ok {
+		return build(account)
+	}
+	if account == "" {
+		return nil
+	}
+	return map[string]string{"account": account}
+}
+
+func summarize(action string, samples []sample) actionStats {
+	stats := actionStats{Action: action, Requests: len(samples), TopErrors: map[string]int{}}
+	latencies := make([]time.Duration, 0, len(samples))
+	for _, s := range samples {
+		latencies = append(latencies, s.latency)
+		if s.err != nil {
+			stats.Errors++
+			stats.TopErrors[s.err.Error()]++
+		}
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	stats.ErrorRate = float64(stats.Errors) / float64(len(samples))
+	stats.P50 = milliseconds(percentile(latencies, 0.50))
+	stats.P90 = milliseconds(percentile(latencies, 0.90))
+	stats.P99 = milliseconds(percentile(latencies, 0.99))
+	stats.Max = milliseconds(latencies[len(latencies)-1])
+	if len(stats.TopErrors) == 0 {
+		stats.TopErrors = nil
+	}
+	return stats
+}
+
+// percentile uses the nearest rank method on sorted latencies
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	rank := int(p*float64(len(sorted))+0.5) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	if rank >= len(sorted) {
+		rank = len(sorted) - 1
+	}
+	return sorted[rank]
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+func printReport(rep report) {
+	fmt.Printf("%d requests in %s (%.1f/s)\n", rep.Requests, rep.Duration, rep.Throughput)
+	if rep.Skipped > 0 {
+		fmt.Println(aurora.Yellow(fmt.Sprintf("%d requests were not sent because every connection was busy, signald did not keep up with -rate", rep.Skipped)))
+	}
+	fmt.Println()
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ACTION\tREQUESTS\tERRORS\tP50\tP90\tP99\tMAX")
+	for _, a := range rep.Actions {
+		fmt.Fprintf(w, "%s\t%d\t%d (%.1f%%)\t%.1fms\t%.1fms\t%.1fms\t%.1fms\n", a.Action, a.Requests, a.Errors, a.ErrorRate*100, a.P50, a.P90, a.P99, a.Max)
+	}
+	w.Flush()
+
+	for _, a := range rep.Actions {
+		if len(a.TopErrors) == 0 {
+			continue
+		}
+		fmt.Printf("\n%s errors:\n", a.Action)
+		messages := make([]string, 0, len(a.TopErrors))
+		for message := range a.TopErrors {
+			messages = append(messages, message)
+		}
+		sort.Slice(messages, func(i, j int) bool { return a.TopErrors[messages[i]] > a.TopErrors[messages[j]] })
+		if len(messages) > 5 {
+			messages = messages[:5]
+		}
+		for _, message := range messages {
+			fmt.Printf("  %6d  %s\n", a.TopErrors[message], message)
+		}
+	}
+}
@@ -0,0 +1,283 @@
+// protocol-coverage reads traffic captures, from tools/signald-proxy or tools/conformance -capture, and reports which
+// documented actions, types and fields they exercised, to show what parts of the protocol no test touches.
+//
+//	go run ./tools/protocol-coverage -protocol protocol.json captures/*.ndjson
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	aurora "github.com/logrusorgru/aurora/v3"
+
+	"gitlab.com/signald/signald/internal/capture"
+	"gitlab.com/signald/signald/internal/protocol"
+)
+
+type coverage struct {
+	protocol *protocol.Protocol
+
+	requests map[string]int // by version.action
+	replies  map[string]int
+	errors   map[string]int
+	types    map[string]bool // by version.Type
+	fields   map[string]int  // by version.Type.field
+}
+
+type pendingRequest struct {
+	version string
+	action  string
+}
+
+func (c *coverage) add(records []capture.Record) {
+	pending := map[int]map[string]pendingRequest{}
+	for _, r := range records {
+		envelope, ok := r.Envelope()
+		if !ok {
+			continue
+		}
+		if pending[r.Session] == nil {
+			pending[r.Session] = map[string]pendingRequest{}
+		}
+
+		if r.From == capture.FromClient {
+			version := protocol.RequestVersion(envelope.Version, envelope.Type)
+			c.requests[version+"."+envelope.Type]++
+			if a := c.protocol.Action(version, envelope.Type); a != nil && a.Request != "" {
+				c.visit(version, a.Request, r.Message)
+			}
+			if envelope.ID != "" {
+				pending[r.Session][envelope.ID] = pendingRequest{version: version, action: envelope.Type}
+			}
+			continue
+		}
+
+		var body struct {
+			Data  json.RawMessage `json:"data"`
+			Error json.RawMessage `json:"error"`
+		}
+		if json.Unmarshal(r.Message, &body) != nil {
+			continue
+		}
+		request, isReply := pending[r.Session][envelope.ID]
+		switch {
+		case isReply && envelope.ID != "":
+			delete(pending[r.Session], envelope.ID)
+			key := request.version + "." + request.action
+			if len(body.Error) > 0 && string(body.Error) != "null" {
+				c.errors[key]++
+				continue
+			}
+			c.replies[key]++
+			if a := c.protocol.Action(request.version, request.action); a != nil && a.Response != "" && len(body.Data) > 0 {
+				c.visit(request.version, a.Response, body.Data)
+			}
+		case envelope.Type == "message" && len(body.Data) > 0:
+			// incoming messages are documented as a type, but not as the response to any action
+			c.visit("v1", "JsonMessageEnvelope", body.Data)
+		}
+	}
+}
+
+func (c *coverage) visit(version, typeName string, value json.RawMessage) {
+	c.types[version+"."+typeName] = true
+	c.protocol.Visit(version, typeName, value, func(version, typeName, field string) {
+		c.types[version+"."+typeName] = true
+		c.fields[version+"."+typeName+"."+field]++
+	})
+}
+
+type typeReport struct {
+	Type          string   `json:"type"`
+	Seen          bool     `json:"seen"`
+	Fields        int      `json:"fields"`
+	CoveredFields int      `json:"covered_fields"`
+	MissingFields []string `json:"missing_fields,omitempty"`
+}
+
+type report struct {
+	Actions          int          `json:"actions"`
+	CoveredActions   int          `json:"covered_actions"`
+	UntestedActions  []string     `json:"untested_actions"`
+	FailedOnly       []string     `json:"failed_only_actions"`
+	Types            int          `json:"types"`
+	CoveredTypes     int          `json:"covered_types"`
+	Fields           int          `json:"fields"`
+	CoveredFields    int          `json:"covered_fields"`
+	FieldCoverage    float64      `json:"field_coverage"`
+	TypeDetails      []typeReport `json:"type_details"`
+	UndocumentedSeen []string     `json:"undocumented_actions_seen,omitempty"`
+}
+
+func main() {
+	protocolPath := flag.String("protocol", "", "protocol.json to measure coverage against")
+	includeDeprecated := flag.Bool("include-deprecated", false, "count deprecated actions and types too")
+	minCoverage := flag.Float64("min-coverage", 0, "exit with an error if field coverage is below this percentage")
+	jsonReport := flag.Bool("json", false, "print the report as JSON")
+	flag.Parse()
+	if *protocolPath == "" || flag.NArg() == 0 {
+		fmt.Println("usage: protocol-coverage -protocol protocol.json capture.ndjson [capture.ndjson ...]")
+		os.Exit(2)
+	}
+
+	p, err := protocol.Load(*protocolPath)
+	if err != nil {
+		fmt.Println(aurora.Red("error loading protocol documentation"))
+		panic(err)
+	}
+
+	c := &coverage{protocol: p, requests: map[string]int{}, replies: map[string]int{}, errors: map[string]int{}, types: map[string]bool{}, fields: map[string]int{}}
+	for _, path := range flag.Args() {
+		records, err := capture.ReadFile(path)
+		if err != nil {
+			fmt.Println(aurora.Red(err.Error()))
+			os.Exit(1)
+		}
+		c.add(records)
+	}
+
+	rep := c.report(*includeDeprecated)
+	if *jsonReport {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(rep); err != nil {
+			panic(err)
+		}
+	} else {
+		printReport(rep)
+	}
+
+	if rep.FieldCoverage < *minCoverage {
+		os.Exit(1)
+	}
+}
+
+func (c *coverage) report(includeDeprecated bool) report {
+	rep := report{UntestedActions: []string{}, FailedOnly: []string{}, TypeDetails: []typeReport{}}
+
+	actions := map[string][]string{}
+	for version, list := range c.protocol.Actions {
+		for name, a := range list {
+			if !a.Deprecated || includeDeprecated {
+				actions[version] = append(actions[version], name)
+			}
+		}
+	}
+	for _, key := range sortedNames(actions) {
+		rep.Actions++
+		switch {
+		case c.replies[key] > 0:
+			rep.CoveredActions++
+		case c.errors[key] > 0:
+			// requests that only ever failed don't tell us the response still works
+			rep.FailedOnly = append(rep.FailedOnly, key)
+		default:
+			rep.UntestedActions = append(rep.UntestedActions, key)
+		}
+	}
+	for key := range c.requests {
+		parts := strings.SplitN(key, ".", 2)
+		if c.protocol.Action(parts[0], parts[1]) == nil {
+			rep.UndocumentedSeen = append(rep.UndocumentedSeen, key)
+		}
+	}
+	sort.Strings(rep.UndocumentedSeen)
+
+	types := map[string][]string{}
+	for version, list := range c.protocol.Types {
+		for name, t := range list {
+			if !t.Deprecated || includeDeprecated {
+				types[version] = append(types[version], name)
+			}
+		}
+	}
+	for _, key := range sortedNames(types) {
+		parts := strings.SplitN(key, ".", 2)
+		t := c.protocol.Type(parts[0], parts[1])
+		tr := typeReport{Type: key, Seen: c.types[key], Fields: len(t.Fields)}
+		fields := make([]string, 0, len(t.Fields))
+		for field := range t.Fields {
+			fields = append(fields, field)
+		}
+		sort.Strings(fields)
+		for _, field := range fields {
+			if c.fields[key+"."+field] > 0 {
+				tr.CoveredFields++
+			} else {
+				tr.MissingFields = append(tr.MissingFields, field)
+			}
+		}
+		rep.Types++
+		if tr.Seen {
+			rep.CoveredTypes++
+		}
+		rep.Fields += tr.Fields
+		rep.CoveredFields += tr.CoveredFields
+		rep.TypeDetails = append(rep.TypeDetails, tr)
+	}
+	if rep.Fields > 0 {
+		rep.FieldCoverage = 100 * float64(rep.CoveredFields) / float64(rep.Fields)
+	}
+	return rep
+}
+
+func printReport(rep report) {
+	fmt.Println(aurora.Bold(fmt.Sprintf("actions: %d of %d exercised", rep.CoveredActions, rep.Actions)))
+	for _, a := range rep.FailedOnly {
+		fmt.Println(aurora.Yellow("  only ever failed: " + a))
+	}
+	for _, a := range rep.UntestedActions {
+		fmt.Println(aurora.Red("  never called:     " + a))
+	}
+	for _, a := range rep.UndocumentedSeen {
+		fmt.Println(aurora.Blue("  not documented:   " + a))
+	}
+
+	fmt.Println()
+	fmt.Println(aurora.Bold(fmt.Sprintf("types: %d of %d seen, fields: %d of %d seen (%.1f%%)", rep.CoveredTypes, rep.Types, rep.CoveredFields, rep.Fields, rep.FieldCoverage)))
+
+	// least covered first, types that were never seen at all are listed last by name only
+	details := append([]typeReport{}, rep.TypeDetails...)
+	sort.SliceStable(details, func(i, j int) bool {
+		return ratio(details[i]) < ratio(details[j])
+	})
+	unseen := []string{}
+	for _, t := range details {
+		switch {
+		case !t.Seen:
+			unseen = append(unseen, t.Type)
+		case len(t.MissingFields) > 0:
+			fmt.Printf("  %s %d/%d, never seen: %s\n", t.Type, t.CoveredFields, t.Fields, strings.Join(t.MissingFields, ", "))
+		}
+	}
+	if len(unseen) > 0 {
+		sort.Strings(unseen)
+		fmt.Println(aurora.Red("  types never seen: " + strings.Join(unseen, ", ")))
+	}
+}
+
+func ratio(t typeReport) float64 {
+	if !t.Seen {
+		return 2
+	}
+	if t.Fields == 0 {
+		return 1
+	}
+	return float64(t.CoveredFields) / float64(t.Fields)
+}
+
+// sortedNames returns the version.name keys of everything documented in versioned maps of actions or types
+func sortedNames(versions map[string][]string) []string {
+	names := []string{}
+	for version, list := range versions {
+		for _, name := range list {
+			names = append(names, version+"."+name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
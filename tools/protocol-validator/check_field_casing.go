@@ -11,6 +11,9 @@ func checkTypeFieldCasing(version, t, field string, _ DataType) (response checkO
 		response.failures = append(response.failures, m)
 
 	}
+	if grandfathered(version, t, field) {
+		return
+	}
 	for _, r := range field {
 		if unicode.IsUpper(r) {
 			m := fmt.Sprintf("[UpperCaseInFieldName] %s.%s has a field name with an upper case letter in it: %s", version, t, field)
@@ -20,3 +23,13 @@ func checkTypeFieldCasing(version, t, field string, _ DataType) (response checkO
 	}
 	return
 }
+
+// grandfathered is true for fields that had upper case letters in them before the check was added
+func grandfathered(version, t, field string) bool {
+	for _, f := range uppercaseFields[version][t] {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
@@ -1,125 +1,262 @@
 package main
 
 import (
-	"encoding/json"
 	"fmt"
-	"net/http"
+	"sort"
 	"strconv"
 
 	aurora "github.com/logrusorgru/aurora/v3"
 )
 
-func checkDiff() (response checkOutput, err error) {
-	resp, err := http.Get("https://signald.org/protocol.json")
-	if err != nil {
-		return
+const stableProtocolURL = "https://signald.org/protocol.json"
+
+// change is one difference between two versions of the protocol. Breaking changes fail validation.
+type change struct {
+	Kind     string `json:"kind"` // added, removed or changed
+	What     string `json:"what"` // the kind of thing that changed: version, action, type, field, or a property of one
+	Path     string `json:"path"`
+	Old      string `json:"old,omitempty"`
+	New      string `json:"new,omitempty"`
+	Breaking bool   `json:"breaking"`
+
+	// field holds the proposed definition of added fields, so they can be checked like the rest of the protocol
+	field *DataType
+}
+
+const (
+	changeAdded   = "added"
+	changeRemoved = "removed"
+	changeChanged = "changed"
+)
+
+// message describes a change the way the console output always has
+func (c change) message() string {
+	switch {
+	case c.Kind == changeAdded && c.What == "action version":
+		return "New action version: " + c.Path
+	case c.Kind == changeAdded && c.What == "version":
+		return "New version: " + c.Path
+	case c.Kind == changeAdded && c.What == "field":
+		return "new field in " + parentPath(c.Path) + ": " + lastPathElement(c.Path)
+	case c.Kind == changeAdded:
+		return "new " + c.What + ": " + c.Path
+	case c.Kind == changeRemoved && c.What == "field":
+		return "field in " + parentPath(c.Path) + " removed: " + lastPathElement(c.Path)
+	case c.Kind == changeRemoved:
+		return "removed " + c.What + ": " + c.Path
+	case c.What == "deprecated":
+		return c.Path + " has changed deprecated status"
+	case c.What == "doc" && c.isField():
+		return parentPath(c.Path) + " field " + lastPathElement(c.Path) + " changed it's doc string"
+	case c.What == "doc":
+		return c.Path + " has changed its doc string"
+	case c.What == "example":
+		return parentPath(c.Path) + " field " + lastPathElement(c.Path) + " changed it's example string"
+	case c.What == "type":
+		return parentPath(c.Path) + " field " + lastPathElement(c.Path) + " changed types"
+	case c.What == "list":
+		return parentPath(c.Path) + " field " + lastPathElement(c.Path) + " changed list state"
+	default:
+		return c.Path + " changed " + c.What
 	}
-	defer resp.Body.Close()
-	var current Protocol
-	err = json.NewDecoder(resp.Body).Decode(&current)
-	if err != nil {
-		return
+}
+
+// isField is true for changes to a field, paths are version.Type.field
+func (c change) isField() bool {
+	dots := 0
+	for _, r := range c.Path {
+		if r == '.' {
+			dots++
+		}
 	}
+	return dots == 2
+}
 
-	// check for additions
-	for version, actions := range protocol.Actions {
-		if _, ok := current.Actions[version]; !ok {
-			// new version
-			fmt.Println(aurora.Bold(aurora.Green("New action version: " + version)))
+func parentPath(path string) string {
+	for i := len(path) - 1; i >= 0; i-- {
+		if path[i] == '.' {
+			return path[:i]
 		}
-		for name := range actions {
-			if _, ok := current.Actions[version][name]; !ok {
-				// new action
-				fmt.Println(aurora.Bold(aurora.Green("new action: " + version + "." + name)))
-			}
+	}
+	return ""
+}
+
+func lastPathElement(path string) string {
+	return path[len(parentPath(path))+1:]
+}
+
+// checkDiff prints the changes since the baseline protocol. With checkNewFields, the field checks run on every new
+// field and their output is returned. Breaking changes are only in changes, see breakingFailures.
+func checkDiff(baseline *Protocol, checkNewFields bool) (response checkOutput, changes []change) {
+	changes = diffProtocols(baseline, &protocol)
+	jobs := []func() checkOutput{}
+	for _, c := range changes {
+		printChange(c)
+		if c.field != nil && checkNewFields {
+			typePath := parentPath(c.Path)
+			jobs = append(jobs, fieldChecksFor(parentPath(typePath), lastPathElement(typePath), lastPathElement(c.Path), *c.field)...)
 		}
 	}
+	result := runChecks(jobs)
+	response.failures = append(response.failures, result.failures...)
+	response.warnings = append(response.warnings, result.warnings...)
+	return
+}
 
-	for version, types := range protocol.Types {
-		if _, ok := current.Types[version]; !ok {
-			// new version
-			fmt.Println(aurora.Bold(aurora.Green("New version: " + version)))
+// breakingFailures lists the breaking changes, each of which fails validation
+func breakingFailures(changes []change) []string {
+	failures := []string{}
+	for _, c := range changes {
+		if c.Breaking {
+			failures = append(failures, c.message())
 		}
-		for typeName, t := range types {
-			c, ok := current.Types[version][typeName]
-			if !ok {
-				// new action
-				fmt.Println(aurora.Bold(aurora.Green("new type: " + version + "." + typeName)))
-				c = &Type{}
-			} else {
-				if c.Deprecated != t.Deprecated {
-					fmt.Println(aurora.Blue(version + "." + typeName + " has changed deprecated status"))
-					stringDiff(strconv.FormatBool(t.Deprecated), strconv.FormatBool(t.Deprecated))
-				}
-				if c.Doc != t.Doc {
-					fmt.Println(aurora.Blue(version + "." + typeName + " has changed its doc string"))
-					stringDiff(t.Doc, c.Doc)
-				}
-			}
-			for fieldName, field := range t.Fields {
-				currentField, ok := c.Fields[fieldName]
-				if !ok {
-					fmt.Println(aurora.Bold(aurora.Green("new field in " + version + "." + typeName + ": " + fieldName)))
-					for _, fieldCheck := range fieldChecks {
-						result := fieldCheck(version, typeName, fieldName, *field)
-						response.failures = append(response.failures, result.failures...)
-						response.warnings = append(response.warnings, result.warnings...)
-					}
-				} else {
-					if field.Type != currentField.Type {
-						response.failures = append(response.failures, version+"."+typeName+" field "+fieldName+" changed types")
-						stringDiff(currentField.Type, field.Type)
-					}
-					if field.List != currentField.List {
-						response.failures = append(response.failures, version+"."+typeName+" field "+fieldName+" changed list state")
-						stringDiff(strconv.FormatBool(currentField.List), strconv.FormatBool(field.List))
-					}
-					if field.Doc != currentField.Doc {
-						fmt.Println(aurora.Blue(version + "." + typeName + " field " + fieldName + " changed it's doc string"))
-						stringDiff(currentField.Doc, field.Doc)
-					}
-					if field.Example != currentField.Example {
-						fmt.Println(aurora.Blue(version + "." + typeName + " field " + fieldName + " changed it's example string"))
-						stringDiff(currentField.Example, field.Example)
-					}
-				}
+	}
+	return failures
+}
+
+func printChange(c change) {
+	switch {
+	case c.Breaking:
+		// also listed with the rest of the failures, but the old and new values only show up here
+		fmt.Println(aurora.Bold(aurora.Red(c.message())))
+	case c.Kind == changeAdded:
+		fmt.Println(aurora.Bold(aurora.Green(c.message())))
+	case c.Kind == changeRemoved:
+		fmt.Println(aurora.Bold(aurora.Red(c.message())))
+	default:
+		fmt.Println(aurora.Blue(c.message()))
+	}
+	if c.Kind == changeChanged {
+		stringDiff(c.Old, c.New)
+	}
+}
+
+// diffProtocols lists the changes from current to proposed, ordered by version and name
+func diffProtocols(current, proposed *Protocol) []change {
+	changes := []change{}
+
+	for _, version := range sortedKeys(proposed.Actions, current.Actions) {
+		actions, currentActions := proposed.Actions[version], current.Actions[version]
+		if actionsUnchanged(current, proposed, version) {
+			continue
+		}
+		switch {
+		case actions == nil:
+			changes = append(changes, change{Kind: changeRemoved, What: "action version", Path: version})
+		case currentActions == nil:
+			changes = append(changes, change{Kind: changeAdded, What: "action version", Path: version})
+		}
+		for _, name := range sortedKeys(actions, currentActions) {
+			_, inProposed := actions[name]
+			_, inCurrent := currentActions[name]
+			switch {
+			case !inCurrent:
+				changes = append(changes, change{Kind: changeAdded, What: "action", Path: version + "." + name})
+			case !inProposed:
+				changes = append(changes, change{Kind: changeRemoved, What: "action", Path: version + "." + name})
 			}
 		}
 	}
 
-	// check for removals
-	for version, actions := range current.Actions {
-		if _, ok := protocol.Actions[version]; !ok {
-			// new version
-			fmt.Println(aurora.Bold(aurora.Red("removed action version: " + version)))
+	for _, version := range sortedKeys(proposed.Types, current.Types) {
+		types, currentTypes := proposed.Types[version], current.Types[version]
+		if typesUnchanged(current, proposed, version) {
+			continue
+		}
+		switch {
+		case types == nil:
+			changes = append(changes, change{Kind: changeRemoved, What: "version", Path: version, Breaking: true})
+		case currentTypes == nil:
+			changes = append(changes, change{Kind: changeAdded, What: "version", Path: version})
 		}
-		for name := range actions {
-			if _, ok := protocol.Actions[version][name]; !ok {
-				// new action
-				fmt.Println(aurora.Bold(aurora.Red("removed action: " + version + "." + name)))
+		for _, typeName := range sortedKeys(types, currentTypes) {
+			if typeUnchanged(current, proposed, version, typeName) {
+				continue
 			}
+			changes = append(changes, diffType(version+"."+typeName, currentTypes[typeName], types[typeName])...)
 		}
 	}
+	return changes
+}
 
-	for version, types := range current.Types {
-		if _, ok := protocol.Types[version]; !ok {
-			// new version
-			response.failures = append(response.failures, "removed version: "+version)
+// diffType compares two versions of a type, either of which may be nil
+func diffType(path string, current, proposed *Type) []change {
+	changes := []change{}
+	switch {
+	case proposed == nil:
+		// the fields went with it, no need to list them
+		return append(changes, change{Kind: changeRemoved, What: "type", Path: path, Breaking: true})
+	case current == nil:
+		changes = append(changes, change{Kind: changeAdded, What: "type", Path: path})
+		current = &Type{}
+	default:
+		if current.Deprecated != proposed.Deprecated {
+			changes = append(changes, change{Kind: changeChanged, What: "deprecated", Path: path, Old: strconv.FormatBool(current.Deprecated), New: strconv.FormatBool(proposed.Deprecated)})
+		}
+		if current.Doc != proposed.Doc {
+			changes = append(changes, change{Kind: changeChanged, What: "doc", Path: path, Old: current.Doc, New: proposed.Doc})
 		}
-		for typeName, t := range types {
-			if _, ok := protocol.Types[version][typeName]; !ok {
-				// new action
-				response.failures = append(response.failures, "removed type: "+version+"."+typeName)
+	}
+
+	for _, fieldName := range sortedKeys(proposed.Fields, current.Fields) {
+		fieldPath := path + "." + fieldName
+		field, currentField := proposed.Fields[fieldName], current.Fields[fieldName]
+		switch {
+		case currentField == nil:
+			changes = append(changes, change{Kind: changeAdded, What: "field", Path: fieldPath, field: field})
+		case field == nil:
+			changes = append(changes, change{Kind: changeRemoved, What: "field", Path: fieldPath, Breaking: true})
+		default:
+			if field.Type != currentField.Type {
+				changes = append(changes, change{Kind: changeChanged, What: "type", Path: fieldPath, Old: currentField.Type, New: field.Type, Breaking: true})
+			}
+			if field.List != currentField.List {
+				changes = append(changes, change{Kind: changeChanged, What: "list", Path: fieldPath, Old: strconv.FormatBool(currentField.List), New: strconv.FormatBool(field.List), Breaking: true})
+			}
+			if field.Doc != currentField.Doc {
+				changes = append(changes, change{Kind: changeChanged, What: "doc", Path: fieldPath, Old: currentField.Doc, New: field.Doc})
 			}
-			for fieldName := range t.Fields {
-				_, ok := protocol.Types[version][typeName].Fields[fieldName]
-				if !ok {
-					response.failures = append(response.failures, "field in "+version+"."+typeName+" removed: "+fieldName)
-				}
+			if field.Example != currentField.Example {
+				changes = append(changes, change{Kind: changeChanged, What: "example", Path: fieldPath, Old: currentField.Example, New: field.Example})
 			}
 		}
 	}
-	return
+	return changes
+}
+
+// sortedKeys returns the union of the keys of maps with string keys, sorted
+func sortedKeys(maps ...interface{}) []string {
+	seen := map[string]bool{}
+	for _, m := range maps {
+		switch typed := m.(type) {
+		case map[string]map[string]*Action:
+			for k := range typed {
+				seen[k] = true
+			}
+		case map[string]*Action:
+			for k := range typed {
+				seen[k] = true
+			}
+		case map[string]map[string]*Type:
+			for k := range typed {
+				seen[k] = true
+			}
+		case map[string]*Type:
+			for k := range typed {
+				seen[k] = true
+			}
+		case map[string]*DataType:
+			for k := range typed {
+				seen[k] = true
+			}
+		}
+	}
+	keys := make([]string, 0, len(seen))
+	for k := range seen {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
 }
 
 func stringDiff(old, new string) {
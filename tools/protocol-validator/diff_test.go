@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestDiffProtocols(t *testing.T) {
+	const base = `{
+		"types": {"v1": {"A": {"fields": {"x": {"type": "String", "doc": "x"}}}}},
+		"actions": {"v1": {"send": {"request": "A"}}}
+	}`
+	tests := []struct {
+		name     string
+		proposed string
+		want     []string
+	}{
+		{"same", base, []string{}},
+		{"field added", `{
+			"types": {"v1": {"A": {"fields": {"x": {"type": "String", "doc": "x"}, "y": {"type": "int"}}}}},
+			"actions": {"v1": {"send": {"request": "A"}}}
+		}`, []string{"added field v1.A.y"}},
+		{"field removed", `{
+			"types": {"v1": {"A": {"fields": {}}}},
+			"actions": {"v1": {"send": {"request": "A"}}}
+		}`, []string{"removed field v1.A.x breaking"}},
+		{"field retyped and redocumented", `{
+			"types": {"v1": {"A": {"fields": {"x": {"type": "int", "list": true, "doc": "y"}}}}},
+			"actions": {"v1": {"send": {"request": "A"}}}
+		}`, []string{"changed type v1.A.x breaking", "changed list v1.A.x breaking", "changed doc v1.A.x"}},
+		{"type deprecated", `{
+			"types": {"v1": {"A": {"deprecated": true, "fields": {"x": {"type": "String", "doc": "x"}}}}},
+			"actions": {"v1": {"send": {"request": "A"}}}
+		}`, []string{"changed deprecated v1.A"}},
+		{"action and version added", `{
+			"types": {"v1": {"A": {"fields": {"x": {"type": "String", "doc": "x"}}}}, "v2": {"B": {}}},
+			"actions": {"v1": {"send": {"request": "A"}, "react": {"request": "A"}}}
+		}`, []string{"added action v1.react", "added version v2", "added type v2.B"}},
+		{"version removed", `{
+			"types": {},
+			"actions": {}
+		}`, []string{"removed action version v1", "removed action v1.send", "removed version v1 breaking", "removed type v1.A breaking"}},
+	}
+
+	current := mustDecode(t, base)
+	for _, tt := range tests {
+		got := []string{}
+		for _, c := range diffProtocols(current, mustDecode(t, tt.proposed)) {
+			s := fmt.Sprintf("%s %s %s", c.Kind, c.What, c.Path)
+			if c.Breaking {
+				s += " breaking"
+			}
+			got = append(got, s)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %q\nexpected %q", tt.name, got, tt.want)
+		}
+	}
+}
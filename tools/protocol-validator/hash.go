@@ -0,0 +1,72 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+)
+
+// onlyChanged skips versions and types that hash the same as in the baseline, instead of comparing them field by field
+var onlyChanged = false
+
+type bucketSum [sha256.Size]byte
+
+// unhashable is what bucketHash returns for a bucket it can't marshal, and what a missing bucket looks up as. It never
+// counts as unchanged.
+var unhashable bucketSum
+
+// bucketHashes holds the hash of each version's actions and types and of each type in a protocol
+type bucketHashes struct {
+	actions  map[string]bucketSum
+	types    map[string]bucketSum
+	eachType map[string]map[string]bucketSum
+}
+
+// bucketHash hashes a version's types or actions, or a single type. encoding/json sorts map keys, so equal values
+// always hash the same.
+func bucketHash(v interface{}) bucketSum {
+	b, err := json.Marshal(v)
+	if err != nil {
+		// can't happen for protocol types
+		return unhashable
+	}
+	return sha256.Sum256(b)
+}
+
+// hashes computes every bucket hash of p the first time it's called and returns the same ones after that
+func (p *Protocol) hashes() *bucketHashes {
+	if p.bucketHashes != nil {
+		return p.bucketHashes
+	}
+	h := &bucketHashes{actions: map[string]bucketSum{}, types: map[string]bucketSum{}, eachType: map[string]map[string]bucketSum{}}
+	for version, actions := range p.Actions {
+		h.actions[version] = bucketHash(actions)
+	}
+	for version, types := range p.Types {
+		h.types[version] = bucketHash(types)
+		h.eachType[version] = map[string]bucketSum{}
+		for name, t := range types {
+			h.eachType[version][name] = bucketHash(t)
+		}
+	}
+	p.bucketHashes = h
+	return h
+}
+
+// unchanged reports whether two bucket hashes match and are both known
+func unchanged(baseline, proposed bucketSum) bool {
+	return baseline != unhashable && baseline == proposed
+}
+
+// actionsUnchanged, typesUnchanged and typeUnchanged report whether a bucket can be skipped, which is only the case
+// with onlyChanged set
+func actionsUnchanged(baseline, proposed *Protocol, version string) bool {
+	return onlyChanged && unchanged(baseline.hashes().actions[version], proposed.hashes().actions[version])
+}
+
+func typesUnchanged(baseline, proposed *Protocol, version string) bool {
+	return onlyChanged && unchanged(baseline.hashes().types[version], proposed.hashes().types[version])
+}
+
+func typeUnchanged(baseline, proposed *Protocol, version, name string) bool {
+	return onlyChanged && unchanged(baseline.hashes().eachType[version][name], proposed.hashes().eachType[version][name])
+}
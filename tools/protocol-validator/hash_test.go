@@ -0,0 +1,58 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func mustDecode(t *testing.T, doc string) *Protocol {
+	t.Helper()
+	p, err := decodeProtocol(strings.NewReader(doc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestUnchanged(t *testing.T) {
+	a, b := bucketHash(map[string]int{"a": 1}), bucketHash(map[string]int{"a": 2})
+	tests := []struct {
+		name               string
+		baseline, proposed bucketSum
+		want               bool
+	}{
+		{"equal", a, a, true},
+		{"different", a, b, false},
+		{"unmarshalable", bucketHash(func() {}), bucketHash(func() {}), false},
+		{"missing", unhashable, unhashable, false},
+	}
+	for _, tt := range tests {
+		if got := unchanged(tt.baseline, tt.proposed); got != tt.want {
+			t.Errorf("%s: unchanged = %v, expected %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestHashesComputedOnce(t *testing.T) {
+	p := mustDecode(t, `{"types":{"v1":{"A":{"fields":{"x":{"type":"String"}}}}}}`)
+	before := p.hashes().eachType["v1"]["A"]
+	p.Types["v1"]["A"].Doc = "changed after hashing"
+	if p.hashes().eachType["v1"]["A"] != before {
+		t.Error("hashes were recomputed")
+	}
+}
+
+func TestDiffOnlyChanged(t *testing.T) {
+	onlyChanged = true
+	defer func() { onlyChanged = false }()
+
+	current := mustDecode(t, `{"types":{"v1":{"A":{"fields":{"x":{"type":"String"}}},"B":{"fields":{"y":{"type":"String"}}}}}}`)
+	proposed := mustDecode(t, `{"types":{"v1":{"A":{"fields":{"x":{"type":"String"}}},"B":{"fields":{"y":{"type":"int"}}}}}}`)
+	changes := diffProtocols(current, proposed)
+	if len(changes) != 1 || !strings.HasPrefix(changes[0].Path, "v1.B.y") {
+		t.Errorf("expected only v1.B.y to change, got %+v", changes)
+	}
+	if changes := diffProtocols(current, current); len(changes) != 0 {
+		t.Errorf("expected no changes against itself, got %+v", changes)
+	}
+}
@@ -1,15 +1,21 @@
 package main
 
 import (
-	"encoding/json"
+	"flag"
 	"fmt"
 	"os"
+	"runtime"
 
 	aurora "github.com/logrusorgru/aurora/v3"
+
+	"gitlab.com/signald/signald/internal/socket"
 )
 
 var protocol Protocol
 
+// workers is how many checks run at once
+var workers = 1
+
 type checkOutput struct {
 	warnings []string
 	failures []string
@@ -24,26 +30,78 @@ var checks = []check{checkRequestResponseTypesExist, checkMissingCriticalFields}
 var fieldChecks = []fieldCheck{checkTypeFieldCasing, checkCrossVersionReferences}
 
 func main() {
-	err := json.NewDecoder(os.Stdin).Decode(&protocol)
+	if len(os.Args) > 1 && os.Args[1] == "serve" {
+		if err := serve(os.Args[2:]); err != nil {
+			fmt.Println(aurora.Red(err.Error()))
+			os.Exit(1)
+		}
+		return
+	}
+
+	flag.IntVar(&workers, "workers", runtime.NumCPU(), "number of checks to run at once")
+	all := flag.Bool("all", false, "run the field checks on every field, not only on fields that are new since the baseline")
+	baselineSource := flag.String("baseline", stableProtocolURL, "protocol to diff against: a file, URL or git:<rev>:<path>")
+	flag.BoolVar(&onlyChanged, "only-changed", false, "only compare and check versions and types whose hash differs from the baseline")
+	var n notifier
+	flag.StringVar(&n.webhook, "notify-webhook", "", "URL to POST a summary to when the protocol changed")
+	flag.StringVar(&n.group, "notify-group", "", "Signal group ID to send a summary to when the protocol changed")
+	flag.StringVar(&n.account, "notify-account", "", "local account to send -notify-group messages from")
+	flag.StringVar(&n.socketPath, "notify-socket", socket.DefaultPath, "signald socket to send -notify-group messages through")
+	prRef := flag.String("pr-comment", "", "post the results as a comment on a pull request: github:<owner>/<repo>#<number>, gitlab:<project>!<iid>, or gitlab for the current merge request pipeline")
+	prTokenEnv := flag.String("pr-token-env", "PR_COMMENT_TOKEN", "environment variable holding the API token for -pr-comment")
+	flag.Parse()
+
+	var pr *pullRequest
+	if *prRef != "" {
+		var err error
+		if pr, err = parsePullRequest(*prRef, os.Getenv(*prTokenEnv)); err != nil {
+			fmt.Println(aurora.Red(err.Error()))
+			os.Exit(2)
+		}
+	}
+
+	p, err := decodeProtocol(os.Stdin)
 	if err != nil {
 		fmt.Println(aurora.Red("error parsing stdin"))
 		panic(err)
 	}
-	combinedOutput := checkOutput{}
-	for _, c := range checks {
-		result := c()
-		combinedOutput.failures = append(combinedOutput.failures, result.failures...)
-		combinedOutput.warnings = append(combinedOutput.warnings, result.warnings...)
-	}
+	protocol = *p
 
-	d, err := checkDiff()
+	baseline, err := loadSource(*baselineSource, "")
 	if err != nil {
-		fmt.Println(aurora.Red("error diffing against stable protocol version"))
+		fmt.Println(aurora.Red("error loading baseline protocol version"))
 		panic(err)
 	}
-	combinedOutput.failures = append(combinedOutput.failures, d.failures...)
+
+	jobs := []func() checkOutput{}
+	for _, c := range checks {
+		jobs = append(jobs, c)
+	}
+	if *all {
+		jobs = append(jobs, allFieldChecks(baseline)...)
+	}
+	combinedOutput := runChecks(jobs)
+
+	d, changes := checkDiff(baseline, !*all)
+	// the failed checks, not counting breaking changes: announcements list those with the rest of the changes
+	checkFailures := append(append([]string{}, combinedOutput.failures...), d.failures...)
+	combinedOutput.failures = append(append(combinedOutput.failures, breakingFailures(changes)...), d.failures...)
 	combinedOutput.warnings = append(combinedOutput.warnings, d.warnings...)
 
+	if n.enabled() && len(changes) > 0 {
+		// a failed announcement shouldn't fail validation
+		for _, err := range n.notify(changes, checkFailures) {
+			combinedOutput.warnings = append(combinedOutput.warnings, err.Error())
+		}
+	}
+
+	if pr != nil {
+		body := commentBody(changes, checkFailures)
+		if err := pr.comment(body, len(changes) == 0 && len(checkFailures) == 0); err != nil {
+			combinedOutput.warnings = append(combinedOutput.warnings, "error commenting on pull request: "+err.Error())
+		}
+	}
+
 	for _, failure := range combinedOutput.failures {
 		fmt.Println(aurora.Red(aurora.Bold(failure)))
 	}
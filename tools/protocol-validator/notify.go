@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"gitlab.com/signald/signald/internal/socket"
+)
+
+// maxNotifiedChanges keeps notifications readable in chat, the full list is in the CI log
+const maxNotifiedChanges = 25
+
+// notifier announces protocol changes. Either sink can be left unset.
+type notifier struct {
+	webhook string
+
+	socketPath string
+	account    string
+	group      string
+}
+
+func (n notifier) enabled() bool {
+	return n.webhook != "" || n.group != ""
+}
+
+// notification is posted to the webhook. text holds the summary, which is what Slack and Mattermost style incoming
+// webhooks display.
+type notification struct {
+	Text     string   `json:"text"`
+	Version  string   `json:"version"`
+	Commit   string   `json:"commit"`
+	Breaking int      `json:"breaking"`
+	Changes  []change `json:"changes"`
+	Failures []string `json:"failures"`
+}
+
+func newNotification(changes []change, failures []string) notification {
+	n := notification{Version: protocol.Version.Version, Commit: protocol.Version.Commit, Changes: changes, Failures: failures}
+	counts := map[string]int{}
+	for _, c := range changes {
+		counts[c.Kind]++
+		if c.Breaking {
+			n.Breaking++
+		}
+	}
+
+	var b strings.Builder
+	b.WriteString("signald protocol changes")
+	if protocol.Version.Version != "" {
+		b.WriteString(" in " + protocol.Version.Version)
+	}
+	if protocol.Version.Commit != "" {
+		fmt.Fprintf(&b, " (%s)", protocol.Version.Commit)
+	}
+	fmt.Fprintf(&b, ": %d added, %d removed, %d changed, %d breaking\n", counts[changeAdded], counts[changeRemoved], counts[changeChanged], n.Breaking)
+
+	// breaking changes first, they're what people need to act on
+	listed := 0
+	for _, breaking := range []bool{true, false} {
+		for _, c := range changes {
+			if c.Breaking != breaking || listed >= maxNotifiedChanges {
+				continue
+			}
+			if c.Breaking {
+				b.WriteString("BREAKING ")
+			}
+			b.WriteString(c.message() + "\n")
+			listed++
+		}
+	}
+	if listed < len(changes) {
+		fmt.Fprintf(&b, "and %d more\n", len(changes)-listed)
+	}
+	if len(failures) > 0 {
+		fmt.Fprintf(&b, "%d checks failed\n", len(failures))
+	}
+	n.Text = strings.TrimSuffix(b.String(), "\n")
+	return n
+}
+
+func (n notifier) notify(changes []change, failures []string) []error {
+	message := newNotification(changes, failures)
+	errs := []error{}
+	if n.webhook != "" {
+		if err := n.postWebhook(message); err != nil {
+			errs = append(errs, fmt.Errorf("error posting to webhook: %v", err))
+		}
+	}
+	if n.group != "" {
+		if err := n.sendToGroup(message.Text); err != nil {
+			errs = append(errs, fmt.Errorf("error sending to Signal group: %v", err))
+		}
+	}
+	return errs
+}
+
+func (n notifier) postWebhook(message notification) error {
+	body, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	client := http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Post(n.webhook, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook responded %s", resp.Status)
+	}
+	return nil
+}
+
+func (n notifier) sendToGroup(text string) error {
+	if n.account == "" {
+		return fmt.Errorf("-notify-account is required to send to a group")
+	}
+	conn, err := socket.Dial(n.socketPath)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	_, err = conn.Request(ctx, "v1", "send", map[string]string{"username": n.account, "recipientGroupId": n.group, "messageBody": text})
+	return err
+}
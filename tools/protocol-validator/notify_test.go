@@ -0,0 +1,51 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// withProtocol makes doc the protocol being validated for the rest of the test
+func withProtocol(t *testing.T, doc string) {
+	t.Helper()
+	previous := protocol
+	protocol = *mustDecode(t, doc)
+	t.Cleanup(func() { protocol = previous })
+}
+
+func TestNotifyCountsBreakingChangesOnce(t *testing.T) {
+	baseline := mustDecode(t, `{"types":{"v1":{"A":{"fields":{"x":{"type":"String"},"y":{"type":"String"}}}}}}`)
+	withProtocol(t, `{"version":{"version":"0.16.0"},"types":{"v1":{"A":{"fields":{"x":{"type":"String"}}}}}}`)
+
+	var posted notification
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&posted); err != nil {
+			t.Error(err)
+		}
+	}))
+	defer server.Close()
+
+	d, changes := checkDiff(baseline, true)
+	if len(d.failures) != 0 {
+		t.Errorf("checkDiff should leave breaking changes to changes, got failures %q", d.failures)
+	}
+	if failures := breakingFailures(changes); len(failures) != 1 {
+		t.Errorf("expected one breaking failure, got %q", failures)
+	}
+	for _, err := range (notifier{webhook: server.URL}).notify(changes, d.failures) {
+		t.Error(err)
+	}
+
+	if posted.Breaking != 1 || len(posted.Changes) != 1 {
+		t.Errorf("expected one breaking change, got %d breaking of %d", posted.Breaking, len(posted.Changes))
+	}
+	if len(posted.Failures) != 0 {
+		t.Errorf("the breaking change was also listed as a failed check: %q", posted.Failures)
+	}
+	if n := strings.Count(posted.Text, "v1.A"); n != 1 || strings.Contains(posted.Text, "checks failed") {
+		t.Errorf("expected the change to be mentioned once and no failed checks, got:\n%s", posted.Text)
+	}
+}
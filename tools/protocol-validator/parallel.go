@@ -0,0 +1,78 @@
+package main
+
+import "sync"
+
+// runChecks runs jobs on up to workers goroutines. The output is combined in the order of jobs, so it reads the same
+// no matter which checks finish first.
+func runChecks(jobs []func() checkOutput) checkOutput {
+	results := make([]checkOutput, len(jobs))
+	next := make(chan int)
+	n := workers
+	if n < 1 {
+		n = 1
+	}
+	var wg sync.WaitGroup
+	for w := 0; w < n; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				results[i] = jobs[i]()
+			}
+		}()
+	}
+	for i := range jobs {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+
+	combined := checkOutput{}
+	for _, result := range results {
+		combined.failures = append(combined.failures, result.failures...)
+		combined.warnings = append(combined.warnings, result.warnings...)
+	}
+	return combined
+}
+
+// fieldChecksFor returns a job for every field check on one field
+func fieldChecksFor(version, typeName, fieldName string, field DataType) []func() checkOutput {
+	jobs := []func() checkOutput{}
+	for _, c := range fieldChecks {
+		c := c
+		jobs = append(jobs, func() checkOutput { return c(version, typeName, fieldName, field) })
+	}
+	return jobs
+}
+
+// allFieldChecks returns the field check jobs for every field in the protocol, one job per type. Types that are
+// unchanged since the baseline are skipped with onlyChanged.
+func allFieldChecks(baseline *Protocol) []func() checkOutput {
+	jobs := []func() checkOutput{}
+	for _, version := range sortedKeys(protocol.Types) {
+		if typesUnchanged(baseline, &protocol, version) {
+			continue
+		}
+		for _, typeName := range sortedKeys(protocol.Types[version]) {
+			version, typeName, t := version, typeName, protocol.Types[version][typeName]
+			if typeUnchanged(baseline, &protocol, version, typeName) {
+				continue
+			}
+			jobs = append(jobs, func() checkOutput {
+				output := checkOutput{}
+				for _, fieldName := range sortedKeys(t.Fields) {
+					if t.Fields[fieldName] == nil {
+						continue
+					}
+					for _, job := range fieldChecksFor(version, typeName, fieldName, *t.Fields[fieldName]) {
+						result := job()
+						output.failures = append(output.failures, result.failures...)
+						output.warnings = append(output.warnings, result.warnings...)
+					}
+				}
+				return output
+			})
+		}
+	}
+	return jobs
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// commentMarker identifies the validator's comment on a pull request, so later runs edit it instead of adding another
+const commentMarker = "<!-- signald-protocol-validator -->"
+
+// maxCommentPages stops the search for an earlier comment on pull requests with a very long discussion
+const maxCommentPages = 20
+
+// pullRequest is where -pr-comment posts to:
+//
+//	github:<owner>/<repo>#<number>
+//	gitlab:<project path or id>!<iid>
+//	gitlab                           the merge request of the current GitLab CI pipeline
+type pullRequest struct {
+	host    string // github or gitlab
+	project string
+	number  string
+	token   string
+}
+
+var pullRequestPattern = regexp.MustCompile(`^(github|gitlab):(.+)[#!]([0-9]+)$`)
+
+func parsePullRequest(ref, token string) (*pullRequest, error) {
+	if ref == "gitlab" {
+		pr := &pullRequest{host: "gitlab", project: os.Getenv("CI_MERGE_REQUEST_PROJECT_ID"), number: os.Getenv("CI_MERGE_REQUEST_IID"), token: token}
+		if pr.project == "" || pr.number == "" {
+			return nil, fmt.Errorf("-pr-comment gitlab only works in merge request pipelines")
+		}
+		return pr, nil
+	}
+	m := pullRequestPattern.FindStringSubmatch(ref)
+	if m == nil {
+		return nil, fmt.Errorf("unrecognized pull request %q, expected github:<owner>/<repo>#<number> or gitlab:<project>!<iid>", ref)
+	}
+	return &pullRequest{host: m[1], project: m[2], number: m[3], token: token}, nil
+}
+
+type prComment struct {
+	ID   int64  `json:"id"`
+	Body string `json:"body"`
+}
+
+// comment creates or updates the validator's comment. With nothing to report, an earlier comment is updated to say so
+// but no new one is created.
+func (pr *pullRequest) comment(body string, empty bool) error {
+	existing, err := pr.findComment()
+	if err != nil {
+		return err
+	}
+	switch {
+	case existing != nil:
+		return pr.request(http.MethodPut, pr.commentURL(existing.ID), map[string]string{"body": body}, nil)
+	case empty:
+		return nil
+	default:
+		return pr.request(http.MethodPost, pr.commentsURL(), map[string]string{"body": body}, nil)
+	}
+}
+
+func (pr *pullRequest) findComment() (*prComment, error) {
+	for page := 1; page <= maxCommentPages; page++ {
+		var comments []prComment
+		if err := pr.request(http.MethodGet, fmt.Sprintf("%s?per_page=100&page=%d", pr.commentsURL(), page), nil, &comments); err != nil {
+			return nil, err
+		}
+		for _, c := range comments {
+			if strings.Contains(c.Body, commentMarker) {
+				return &c, nil
+			}
+		}
+		if len(comments) < 100 {
+			break
+		}
+	}
+	return nil, nil
+}
+
+func (pr *pullRequest) commentsURL() string {
+	if pr.host == "github" {
+		return fmt.Sprintf("%s/repos/%s/issues/%s/comments", apiURL("GITHUB_API_URL", "https://api.github.com"), pr.project, pr.number)
+	}
+	return fmt.Sprintf("%s/projects/%s/merge_requests/%s/notes", apiURL("CI_API_V4_URL", "https://gitlab.com/api/v4"), url.PathEscape(pr.project), pr.number)
+}
+
+func (pr *pullRequest) commentURL(id int64) string {
+	if pr.host == "github" {
+		return fmt.Sprintf("%s/repos/%s/issues/comments/%d", apiURL("GITHUB_API_URL", "https://api.github.com"), pr.project, id)
+	}
+	return fmt.Sprintf("%s/%d", pr.commentsURL(), id)
+}
+
+func apiURL(env, fallback string) string {
+	if u := os.Getenv(env); u != "" {
+		return strings.TrimSuffix(u, "/")
+	}
+	return fallback
+}
+
+func (pr *pullRequest) request(method, u string, body interface{}, out interface{}) error {
+	if method == http.MethodPut && pr.host == "github" {
+		// GitHub edits comments with PATCH, GitLab with PUT
+		method = http.MethodPatch
+	}
+	var reader *bytes.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(b)
+	} else {
+		reader = bytes.NewReader(nil)
+	}
+	req, err := http.NewRequest(method, u, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if pr.host == "github" {
+		req.Header.Set("Authorization", "token "+pr.token)
+		req.Header.Set("Accept", "application/vnd.github.v3+json")
+	} else {
+		req.Header.Set("PRIVATE-TOKEN", pr.token)
+	}
+
+	client := http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%s %s: %s", method, u, resp.Status)
+	}
+	if out != nil {
+		return json.NewDecoder(resp.Body).Decode(out)
+	}
+	return nil
+}
+
+// commentBody formats the diff and check failures as markdown. Breaking changes are listed from changes, so failures
+// should only hold the other failed checks.
+func commentBody(changes []change, failures []string) string {
+	var b strings.Builder
+	b.WriteString(commentMarker + "\n### signald protocol changes\n\n")
+	if len(changes) == 0 && len(failures) == 0 {
+		b.WriteString("No protocol changes.\n")
+		return b.String()
+	}
+
+	counts := map[string]int{}
+	breaking := []change{}
+	for _, c := range changes {
+		counts[c.Kind]++
+		if c.Breaking {
+			breaking = append(breaking, c)
+		}
+	}
+	if len(breaking) > 0 {
+		fmt.Fprintf(&b, "**%d breaking**, ", len(breaking))
+	}
+	fmt.Fprintf(&b, "%d added, %d removed, %d changed.\n", counts[changeAdded], counts[changeRemoved], counts[changeChanged])
+
+	if len(breaking) > 0 {
+		b.WriteString("\n#### Breaking changes\n\n")
+		for _, c := range breaking {
+			b.WriteString("- " + markdownCode(c.message()) + "\n")
+		}
+	}
+	if len(failures) > 0 {
+		b.WriteString("\n#### Failed checks\n\n")
+		for _, f := range failures {
+			b.WriteString("- " + markdownCode(f) + "\n")
+		}
+	}
+	if len(changes) > 0 {
+		fmt.Fprintf(&b, "\n<details><summary>All changes (%d)</summary>\n\n", len(changes))
+		b.WriteString("| | path | what | old | new |\n|---|---|---|---|---|\n")
+		for _, c := range changes {
+			kind := c.Kind
+			if c.Breaking {
+				kind = "**" + kind + "**"
+			}
+			fmt.Fprintf(&b, "| %s | `%s` | %s | %s | %s |\n", kind, c.Path, c.What, markdownCell(c.Old), markdownCell(c.New))
+		}
+		b.WriteString("\n</details>\n")
+	}
+	return b.String()
+}
+
+func markdownCode(s string) string {
+	return "`" + strings.Replace(s, "`", "'", -1) + "`"
+}
+
+func markdownCell(s string) string {
+	s = strings.Replace(s, "|", "\\|", -1)
+	return strings.Replace(s, "\n", "<br>", -1)
+}
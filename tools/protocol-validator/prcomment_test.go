@@ -0,0 +1,29 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCommentBodyListsBreakingChangesOnce(t *testing.T) {
+	baseline := mustDecode(t, `{"types":{"v1":{"A":{"fields":{"x":{"type":"String"},"y":{"type":"String"}}}}}}`)
+	withProtocol(t, `{"types":{"v1":{"A":{"fields":{"x":{"type":"String"}},"doc":"new doc"}}}}`)
+
+	d, changes := checkDiff(baseline, true)
+	body := commentBody(changes, d.failures)
+	removed := changes[len(changes)-1].message()
+	if n := strings.Count(body, removed); n != 1 {
+		t.Errorf("expected %q once, found it %d times in:\n%s", removed, n, body)
+	}
+	if strings.Contains(body, "Failed checks") {
+		t.Errorf("a breaking change was listed as a failed check:\n%s", body)
+	}
+	if !strings.Contains(body, "**1 breaking**, 0 added, 1 removed, 1 changed.") {
+		t.Errorf("unexpected summary in:\n%s", body)
+	}
+
+	body = commentBody(changes, []string{"v1.A field x: not camelCase"})
+	if !strings.Contains(body, "#### Failed checks\n\n- `v1.A field x: not camelCase`") {
+		t.Errorf("other failed checks should still be listed:\n%s", body)
+	}
+}
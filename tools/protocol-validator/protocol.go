@@ -1,38 +1,28 @@
 package main
 
+import (
+	"io"
+
+	signaldprotocol "gitlab.com/signald/signald/internal/protocol"
+)
+
+// Protocol is the protocol documentation along with the hashes -only-changed compares, which are computed once
 type Protocol struct {
-	DocVersion string `json:"doc_version"`
-	Version    struct {
-		Name    string
-		Version string
-		Branch  string
-		Commit  string
-	}
-	Info    string
-	Types   map[string]map[string]*Type
-	Actions map[string]map[string]*Action
-}
+	*signaldprotocol.Protocol
 
-type Type struct {
-	Fields     map[string]*DataType
-	Request    bool `json:"-"`
-	Doc        string
-	Deprecated bool
+	bucketHashes *bucketHashes
 }
 
-type DataType struct {
-	List    bool
-	Type    string
-	Version string
-	Doc     string
-	Example string
-}
+type (
+	Type     = signaldprotocol.Type
+	DataType = signaldprotocol.DataType
+	Action   = signaldprotocol.Action
+)
 
-type Action struct {
-	FnName        string
-	Request       string
-	RequestFields map[string]*DataType
-	Response      string
-	Doc           string
-	Deprecated    bool
+func decodeProtocol(r io.Reader) (*Protocol, error) {
+	p, err := signaldprotocol.Decode(r)
+	if err != nil {
+		return nil, err
+	}
+	return &Protocol{Protocol: p}, nil
 }
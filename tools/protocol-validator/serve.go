@@ -0,0 +1,294 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// serve runs a local web server for browsing the protocol and diffing any two versions of it, see loadSource for
+// how versions are picked. Pages only load archive: and git: sources and the configured defaults unless -any-source is
+// given, since anyone who can reach the server could otherwise have it open local files and fetch URLs. It still runs
+// git for whoever can reach it, so it listens on localhost by default.
+func serve(args []string) error {
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	listen := flags.String("listen", "127.0.0.1:8080", "address to listen on")
+	defaultSource := flags.String("protocol", stableProtocolURL, "source to show when none is picked")
+	archive := flags.String("archive", "", "directory of protocol.json snapshots to offer as archive:<name> sources")
+	anySource := flags.Bool("any-source", false, "let pages load any local path or URL, not just archive: and git: sources")
+	flags.Parse(args)
+
+	s := &server{defaultSource: *defaultSource, archive: *archive, anySource: *anySource}
+	http.HandleFunc("/", s.browse)
+	http.HandleFunc("/diff", s.diff)
+	fmt.Printf("serving protocol browser on http://%s/\n", *listen)
+	return http.ListenAndServe(*listen, nil)
+}
+
+type server struct {
+	defaultSource string
+	archive       string
+	anySource     bool
+}
+
+// load reads a source picked on a page, refusing local paths and URLs other than the defaults without -any-source
+func (s *server) load(source string) (*Protocol, error) {
+	if !s.allowed(source) {
+		return nil, fmt.Errorf("%s: only archive:<name> and git:<rev>:<path> sources can be loaded, start the server with -any-source to load local paths and URLs", source)
+	}
+	return loadSource(source, s.archive)
+}
+
+func (s *server) allowed(source string) bool {
+	return s.anySource || source == s.defaultSource || source == stableProtocolURL ||
+		strings.HasPrefix(source, "archive:") || strings.HasPrefix(source, "git:")
+}
+
+// snapshots lists the archive sources
+func (s *server) snapshots() []string {
+	if s.archive == "" {
+		return nil
+	}
+	files, err := ioutil.ReadDir(s.archive)
+	if err != nil {
+		return nil
+	}
+	sources := []string{}
+	for _, f := range files {
+		if !f.IsDir() && strings.HasSuffix(f.Name(), ".json") {
+			sources = append(sources, "archive:"+f.Name())
+		}
+	}
+	return sources
+}
+
+type browseAction struct {
+	Version, Name, Request, Response, Doc string
+	Deprecated                            bool
+}
+
+type browseType struct {
+	Version, Name, Doc string
+	Deprecated         bool
+	Fields             []browseField
+}
+
+type browseField struct {
+	Name, Type, Version, Doc, Example string
+	List                              bool
+}
+
+type browsePage struct {
+	Source    string
+	Snapshots []string
+	Error     string
+	Protocol  *Protocol
+	Actions   []browseAction
+	Types     []browseType
+}
+
+func (s *server) browse(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	page := browsePage{Source: r.URL.Query().Get("source"), Snapshots: s.snapshots()}
+	if page.Source == "" {
+		page.Source = s.defaultSource
+	}
+	p, err := s.load(page.Source)
+	if err != nil {
+		page.Error = err.Error()
+		render(w, browseTemplate, page)
+		return
+	}
+	page.Protocol = p
+
+	for _, version := range sortedKeys(p.Actions) {
+		for _, name := range sortedKeys(p.Actions[version]) {
+			a := p.Actions[version][name]
+			page.Actions = append(page.Actions, browseAction{Version: version, Name: name, Request: a.Request, Response: a.Response, Doc: a.Doc, Deprecated: a.Deprecated})
+		}
+	}
+	for _, version := range sortedKeys(p.Types) {
+		for _, name := range sortedKeys(p.Types[version]) {
+			t := p.Types[version][name]
+			bt := browseType{Version: version, Name: name, Doc: t.Doc, Deprecated: t.Deprecated}
+			for _, fieldName := range sortedKeys(t.Fields) {
+				f := t.Fields[fieldName]
+				bt.Fields = append(bt.Fields, browseField{Name: fieldName, Type: f.Type, Version: f.Version, Doc: f.Doc, Example: f.Example, List: f.List})
+			}
+			page.Types = append(page.Types, bt)
+		}
+	}
+	render(w, browseTemplate, page)
+}
+
+type diffPage struct {
+	Old, New  string
+	Snapshots []string
+	Error     string
+	Changes   []change
+	Breaking  int
+}
+
+func (s *server) diff(w http.ResponseWriter, r *http.Request) {
+	page := diffPage{Old: r.URL.Query().Get("old"), New: r.URL.Query().Get("new"), Snapshots: s.snapshots()}
+	if page.Old == "" {
+		page.Old = stableProtocolURL
+	}
+	if page.New == "" {
+		render(w, diffTemplate, page)
+		return
+	}
+	old, err := s.load(page.Old)
+	if err != nil {
+		page.Error = err.Error()
+		render(w, diffTemplate, page)
+		return
+	}
+	proposed, err := s.load(page.New)
+	if err != nil {
+		page.Error = err.Error()
+		render(w, diffTemplate, page)
+		return
+	}
+	page.Changes = diffProtocols(old, proposed)
+	// breaking changes first, the rest in protocol order
+	sort.SliceStable(page.Changes, func(i, j int) bool {
+		return page.Changes[i].Breaking && !page.Changes[j].Breaking
+	})
+	for _, c := range page.Changes {
+		if c.Breaking {
+			page.Breaking++
+		}
+	}
+
+	if r.URL.Query().Get("format") == "json" {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(page.Changes)
+		return
+	}
+	render(w, diffTemplate, page)
+}
+
+func render(w http.ResponseWriter, t *template.Template, data interface{}) {
+	var b bytes.Buffer
+	if err := t.Execute(&b, data); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(b.Bytes())
+}
+
+const pageHeader = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>signald protocol</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+nav a { margin-right: 1em; }
+input[type=text] { width: 30em; }
+table { border-collapse: collapse; margin-bottom: 1em; }
+td, th { border: 1px solid #ccc; padding: 0.2em 0.5em; text-align: left; vertical-align: top; }
+.deprecated { color: #888; text-decoration: line-through; }
+.error { color: #b00; }
+.added { background: #e6ffe6; }
+.removed { background: #ffe6e6; }
+.changed { background: #e6f0ff; }
+.breaking { font-weight: bold; color: #b00; }
+.old { color: #b00; white-space: pre-wrap; }
+.new { color: #070; white-space: pre-wrap; }
+</style>
+</head>
+<body>
+<nav><a href="/">browse</a><a href="/diff">diff</a></nav>
+{{ if .Snapshots }}<datalist id="snapshots">{{ range .Snapshots }}<option value="{{ . }}">{{ end }}</datalist>{{ end }}
+`
+
+const pageFooter = `</body>
+</html>
+`
+
+var browseTemplate = template.Must(template.New("browse").Parse(pageHeader + `
+<form>
+<input type="text" name="source" value="{{ .Source }}" list="snapshots"> <button>load</button>
+</form>
+{{ if .Error }}<p class="error">{{ .Error }}</p>{{ else }}
+<p>{{ .Protocol.Version.Name }} {{ .Protocol.Version.Version }} {{ .Protocol.Version.Commit }}</p>
+<p><input type="text" id="search" placeholder="search actions, types and fields" autofocus></p>
+
+<h2>Actions</h2>
+<table id="actions">
+<tr><th>action</th><th>request</th><th>response</th><th>doc</th></tr>
+{{ range .Actions }}<tr class="searchable{{ if .Deprecated }} deprecated{{ end }}">
+<td>{{ .Version }}.{{ .Name }}</td>
+<td><a href="#{{ .Version }}.{{ .Request }}">{{ .Request }}</a></td>
+<td>{{ if .Response }}<a href="#{{ .Version }}.{{ .Response }}">{{ .Response }}</a>{{ end }}</td>
+<td>{{ .Doc }}</td>
+</tr>
+{{ end }}</table>
+
+<h2>Types</h2>
+{{ range .Types }}<div class="searchable">
+<h3 id="{{ .Version }}.{{ .Name }}"{{ if .Deprecated }} class="deprecated"{{ end }}>{{ .Version }}.{{ .Name }}</h3>
+{{ if .Doc }}<p>{{ .Doc }}</p>{{ end }}
+<table>
+<tr><th>field</th><th>type</th><th>doc</th><th>example</th></tr>
+{{ range .Fields }}<tr>
+<td>{{ .Name }}</td>
+<td>{{ if .List }}list of {{ end }}{{ if .Version }}<a href="#{{ .Version }}.{{ .Type }}">{{ .Version }}.{{ .Type }}</a>{{ else }}{{ .Type }}{{ end }}</td>
+<td>{{ .Doc }}</td>
+<td><code>{{ .Example }}</code></td>
+</tr>
+{{ end }}</table>
+</div>
+{{ end }}
+<script>
+document.getElementById("search").addEventListener("input", function (e) {
+  var q = e.target.value.toLowerCase();
+  document.querySelectorAll(".searchable").forEach(function (el) {
+    el.style.display = el.textContent.toLowerCase().indexOf(q) >= 0 ? "" : "none";
+  });
+});
+</script>
+{{ end }}
+` + pageFooter))
+
+var diffTemplate = template.Must(template.New("diff").Parse(pageHeader + `
+<form action="/diff">
+<p>old <input type="text" name="old" value="{{ .Old }}" list="snapshots"></p>
+<p>new <input type="text" name="new" value="{{ .New }}" list="snapshots" placeholder="git:rev:path or archive:name"></p>
+<button>diff</button>
+</form>
+{{ if .Error }}<p class="error">{{ .Error }}</p>
+{{ else if .New }}
+<p>{{ len .Changes }} changes, <span class="breaking">{{ .Breaking }} breaking</span>. <input type="text" id="search" placeholder="filter"></p>
+<table>
+<tr><th></th><th>path</th><th>what</th><th>old</th><th>new</th></tr>
+{{ range .Changes }}<tr class="searchable {{ .Kind }}">
+<td{{ if .Breaking }} class="breaking"{{ end }}>{{ .Kind }}{{ if .Breaking }} (breaking){{ end }}</td>
+<td>{{ .Path }}</td>
+<td>{{ .What }}</td>
+<td class="old">{{ .Old }}</td>
+<td class="new">{{ .New }}</td>
+</tr>
+{{ end }}</table>
+<script>
+document.getElementById("search").addEventListener("input", function (e) {
+  var q = e.target.value.toLowerCase();
+  document.querySelectorAll(".searchable").forEach(function (el) {
+    el.style.display = el.textContent.toLowerCase().indexOf(q) >= 0 ? "" : "none";
+  });
+});
+</script>
+{{ end }}
+` + pageFooter))
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestServerAllowed(t *testing.T) {
+	s := &server{defaultSource: "protocol.json"}
+	tests := []struct {
+		source string
+		want   bool
+	}{
+		{"protocol.json", true},
+		{stableProtocolURL, true},
+		{"archive:0.15.0.json", true},
+		{"git:main:protocol.json", true},
+		{"/etc/passwd", false},
+		{"../protocol.json", false},
+		{"http://169.254.169.254/latest/meta-data/", false},
+		{"https://example.com/protocol.json", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := s.allowed(tt.source); got != tt.want {
+			t.Errorf("allowed(%q) = %v, expected %v", tt.source, got, tt.want)
+		}
+	}
+
+	s.anySource = true
+	for _, tt := range tests {
+		if !s.allowed(tt.source) {
+			t.Errorf("allowed(%q) = false with -any-source", tt.source)
+		}
+	}
+}
+
+func TestServerRefusesLocalPaths(t *testing.T) {
+	s := &server{defaultSource: stableProtocolURL}
+	for _, path := range []string{"/?source=" + url.QueryEscape("/etc/passwd"), "/diff?old=" + url.QueryEscape("/etc/passwd") + "&new=" + url.QueryEscape("/etc/hosts")} {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		if strings.HasPrefix(path, "/diff") {
+			s.diff(w, r)
+		} else {
+			s.browse(w, r)
+		}
+		if !strings.Contains(w.Body.String(), "-any-source") {
+			t.Errorf("%s: expected the page to refuse the source, got:\n%s", path, w.Body.String())
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// loadSource reads a protocol document from one of:
+//
+//	path/to/protocol.json         a local file
+//	https://signald.org/protocol.json
+//	git:<rev>:<path>              a file as of a git revision, for example git:main:protocol.json
+//	archive:<name>                a snapshot in the archive directory
+func loadSource(source, archive string) (*Protocol, error) {
+	r, err := openSource(source, archive)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	p, err := decodeProtocol(r)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing %s: %v", source, err)
+	}
+	return p, nil
+}
+
+func openSource(source, archive string) (io.ReadCloser, error) {
+	switch {
+	case strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://"):
+		resp, err := http.Get(source)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, fmt.Errorf("%s: %s", source, resp.Status)
+		}
+		return resp.Body, nil
+	case strings.HasPrefix(source, "git:"):
+		parts := strings.SplitN(strings.TrimPrefix(source, "git:"), ":", 2)
+		if len(parts) != 2 || strings.HasPrefix(parts[0], "-") {
+			return nil, fmt.Errorf("git sources look like git:<rev>:<path>")
+		}
+		var stderr bytes.Buffer
+		cmd := exec.Command("git", "show", parts[0]+":"+parts[1])
+		cmd.Stderr = &stderr
+		b, err := cmd.Output()
+		if err != nil {
+			return nil, fmt.Errorf("git show %s:%s: %s", parts[0], parts[1], strings.TrimSpace(stderr.String()))
+		}
+		return ioutil.NopCloser(bytes.NewReader(b)), nil
+	case strings.HasPrefix(source, "archive:"):
+		if archive == "" {
+			return nil, fmt.Errorf("no -archive directory configured")
+		}
+		return os.Open(filepath.Join(archive, filepath.Base(strings.TrimPrefix(source, "archive:"))))
+	default:
+		return os.Open(source)
+	}
+}
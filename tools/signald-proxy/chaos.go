@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+
+	aurora "github.com/logrusorgru/aurora/v3"
+	"gopkg.in/yaml.v3"
+)
+
+// chaos injects faults into what the replay and scenario servers send, to test how clients cope with a slow or
+// broken signald. It is read from a YAML file given with -chaos:
+//
+//	seed: 42
+//	default: {delay: 0.2, max-delay: 2s, drop: 0.05, truncate: 0.02, reset: 0.01, reorder: 0.1}
+//	actions:
+//	  send: {drop: 0.5}
+//	  version: {}
+//
+// Rates are probabilities between 0 and 1, checked for every message sent. An entry under actions replaces the
+// default for replies to that request type, or for unsolicited messages of that type, like message.
+type chaos struct {
+	Seed    int64             `yaml:"seed"`
+	Default faults            `yaml:"default"`
+	Actions map[string]faults `yaml:"actions"`
+
+	lock sync.Mutex
+	rand *rand.Rand
+}
+
+type faults struct {
+	// Delay is the rate of messages held back for up to MaxDelay, holding back everything sent after them too
+	Delay    float64       `yaml:"delay"`
+	MaxDelay time.Duration `yaml:"max-delay"`
+
+	// Drop is the rate of messages that are never sent
+	Drop float64 `yaml:"drop"`
+
+	// Truncate is the rate of messages cut off at a random point, still followed by a newline
+	Truncate float64 `yaml:"truncate"`
+
+	// Reset is the rate of messages where the connection is closed instead
+	Reset float64 `yaml:"reset"`
+
+	// Reorder is the rate of unsolicited messages sent up to MaxDelay later, after messages that followed them
+	Reorder float64 `yaml:"reorder"`
+}
+
+func loadChaos(path string) (*chaos, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var c chaos
+	if err := yaml.Unmarshal(b, &c); err != nil {
+		return nil, fmt.Errorf("error parsing %s: %v", path, err)
+	}
+	if c.Seed == 0 {
+		c.Seed = time.Now().UnixNano()
+	}
+	c.rand = rand.New(rand.NewSource(c.Seed))
+	fmt.Printf("chaos seed %d\n", c.Seed)
+	return &c, nil
+}
+
+func (c *chaos) faults(action string) faults {
+	if f, ok := c.Actions[action]; ok {
+		return f
+	}
+	return c.Default
+}
+
+func (c *chaos) happens(rate float64) bool {
+	if rate <= 0 {
+		return false
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.rand.Float64() < rate
+}
+
+func (c *chaos) duration(max time.Duration) time.Duration {
+	if max <= 0 {
+		max = time.Second
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return time.Duration(c.rand.Int63n(int64(max)))
+}
+
+func (c *chaos) intn(n int) int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.rand.Intn(n)
+}
+
+// connWriter writes newline terminated messages to a client, injecting faults if chaos is set. It is safe for
+// concurrent use.
+type connWriter struct {
+	conn  net.Conn
+	chaos *chaos
+
+	lock   sync.Mutex
+	writer *bufio.Writer
+}
+
+func newConnWriter(conn net.Conn, c *chaos) *connWriter {
+	return &connWriter{conn: conn, chaos: c, writer: bufio.NewWriter(conn)}
+}
+
+// fault is what chaos decided to do with one message
+type fault struct {
+	reset, drop, reorder bool
+
+	// late is how long to hold back a reordered message, delay how long to hold up this and every later message
+	late, delay time.Duration
+
+	// truncate is the length to cut the message to, 0 to send all of it
+	truncate int
+}
+
+// decide picks the faults for one message of length n. Every decision is drawn from the seeded source in the same
+// order, so a seed always gives the same faults for the same sequence of messages.
+func (c *chaos) decide(action string, unsolicited bool, n int) fault {
+	f := c.faults(action)
+	switch {
+	case c.happens(f.Reset):
+		return fault{reset: true}
+	case c.happens(f.Drop):
+		return fault{drop: true}
+	case unsolicited && c.happens(f.Reorder):
+		return fault{reorder: true, late: c.duration(f.MaxDelay)}
+	}
+	var decided fault
+	if c.happens(f.Delay) {
+		decided.delay = c.duration(f.MaxDelay)
+	}
+	if n > 1 && c.happens(f.Truncate) {
+		decided.truncate = 1 + c.intn(n-1)
+	}
+	return decided
+}
+
+// write sends line, the reply to an action or an unsolicited message of that type. It returns false once the
+// connection is unusable.
+func (w *connWriter) write(action string, unsolicited bool, line []byte) bool {
+	if w.chaos == nil {
+		return w.writeNow(line)
+	}
+	f := w.chaos.decide(action, unsolicited, len(line))
+	switch {
+	case f.reset:
+		fmt.Println(aurora.Yellow("chaos: resetting connection instead of sending " + action))
+		w.conn.Close()
+		return false
+	case f.drop:
+		fmt.Println(aurora.Yellow("chaos: dropped " + action))
+		return true
+	case f.reorder:
+		fmt.Println(aurora.Yellow(fmt.Sprintf("chaos: sending %s %s late", action, f.late)))
+		go func() {
+			time.Sleep(f.late)
+			w.writeNow(line)
+		}()
+		return true
+	}
+	if f.delay > 0 {
+		fmt.Println(aurora.Yellow(fmt.Sprintf("chaos: delaying %s by %s", action, f.delay)))
+		time.Sleep(f.delay)
+	}
+	if f.truncate > 0 {
+		fmt.Println(aurora.Yellow("chaos: truncated " + action))
+		line = line[:f.truncate]
+	}
+	return w.writeNow(line)
+}
+
+func (w *connWriter) writeNow(line []byte) bool {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.writer.Write(line)
+	w.writer.WriteByte('\n')
+	return w.writer.Flush() == nil
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func parseChaos(t *testing.T, doc string) *chaos {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "chaos.yaml")
+	if err := ioutil.WriteFile(path, []byte(doc), 0644); err != nil {
+		t.Fatal(err)
+	}
+	c, err := loadChaos(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+const chaosConfig = `
+seed: %d
+default: {delay: 0.2, max-delay: 2s, drop: 0.1, truncate: 0.1, reset: 0.05, reorder: 0.3}
+actions:
+  send: {drop: 0.5}
+  version: {}
+`
+
+// decisions runs the same sequence of messages through c
+func decisions(c *chaos) []fault {
+	actions := []string{"send", "message", "version", "list_accounts"}
+	out := []fault{}
+	for i := 0; i < 500; i++ {
+		action := actions[i%len(actions)]
+		out = append(out, c.decide(action, action == "message", 100+i))
+	}
+	return out
+}
+
+func TestChaosSeedIsDeterministic(t *testing.T) {
+	first := decisions(parseChaos(t, fmt.Sprintf(chaosConfig, 42)))
+	if again := decisions(parseChaos(t, fmt.Sprintf(chaosConfig, 42))); !reflect.DeepEqual(first, again) {
+		t.Error("the same seed gave different faults")
+	}
+	if other := decisions(parseChaos(t, fmt.Sprintf(chaosConfig, 43))); reflect.DeepEqual(first, other) {
+		t.Error("a different seed gave the same faults")
+	}
+
+	// make sure the sequence actually exercises every kind of fault
+	var resets, drops, reorders, delays, truncates int
+	for i, f := range first {
+		switch {
+		case f.reset:
+			resets++
+		case f.drop:
+			drops++
+		case f.reorder:
+			reorders++
+			if i%4 != 1 {
+				t.Errorf("message %d: only unsolicited messages should be reordered", i)
+			}
+		}
+		if f.delay > 0 {
+			delays++
+			if f.delay >= 2*time.Second {
+				t.Errorf("message %d: delay %s is over max-delay", i, f.delay)
+			}
+		}
+		if f.truncate > 0 {
+			truncates++
+			if f.truncate >= 100+i {
+				t.Errorf("message %d: truncated to %d bytes, which is the whole message", i, f.truncate)
+			}
+		}
+		if i%4 == 2 && f != (fault{}) {
+			t.Errorf("message %d: version has no faults configured, got %+v", i, f)
+		}
+	}
+	for name, n := range map[string]int{"reset": resets, "drop": drops, "reorder": reorders, "delay": delays, "truncate": truncates} {
+		if n == 0 {
+			t.Errorf("no %s in 500 messages", name)
+		}
+	}
+}
+
+// TestChaosWriterIsDeterministic checks what a client receives, with only the faults that don't sleep
+func TestChaosWriterIsDeterministic(t *testing.T) {
+	received := func() []string {
+		c := parseChaos(t, "seed: 7\ndefault: {drop: 0.3, truncate: 0.3}\n")
+		client, server := net.Pipe()
+		defer client.Close()
+		w := newConnWriter(server, c)
+		go func() {
+			for i := 0; i < 50; i++ {
+				w.write("send", false, []byte(fmt.Sprintf(`{"id":"%d","type":"send"}`, i)))
+			}
+			server.Close()
+		}()
+		lines := []string{}
+		scanner := bufio.NewScanner(client)
+		for scanner.Scan() {
+			lines = append(lines, scanner.Text())
+		}
+		return lines
+	}
+
+	first := received()
+	if len(first) == 0 || len(first) == 50 {
+		t.Fatalf("expected some but not all of 50 messages to be dropped, got %d", len(first))
+	}
+	if again := received(); !reflect.DeepEqual(first, again) {
+		t.Errorf("the same seed sent different messages:\n%q\n%q", first, again)
+	}
+}
@@ -0,0 +1,123 @@
+// signald-proxy records the traffic between clients and signald, and serves recorded replies back to clients.
+//
+// record listens on its own socket, forwards every connection to signald and writes all traffic to a capture:
+//
+//	go run ./tools/signald-proxy record -listen /tmp/proxy.sock -upstream /var/run/signald/signald.sock -out bug.ndjson -redact
+//
+// replay pretends to be signald, answering requests with the matching replies from a capture:
+//
+//	go run ./tools/signald-proxy replay -listen /tmp/replay.sock -capture bug.ndjson
+//
+// scenario pretends to be signald following a script of replies and incoming messages, see the scenario type:
+//
+//	go run ./tools/signald-proxy scenario -listen /tmp/bot-test.sock -scenario ping-pong.yaml
+//
+// replay and scenario take -chaos to inject delays, dropped and truncated messages and connection resets, see the
+// chaos type:
+//
+//	go run ./tools/signald-proxy replay -listen /tmp/replay.sock -capture bug.ndjson -chaos flaky.yaml
+//
+// redact removes personal data and message content from an existing capture:
+//
+//	go run ./tools/signald-proxy redact -in bug.ndjson -out bug-redacted.ndjson
+package main
+
+import (
+	"crypto/rand"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	aurora "github.com/logrusorgru/aurora/v3"
+
+	"gitlab.com/signald/signald/internal/capture"
+)
+
+const usage = `usage: signald-proxy <record|replay|scenario|redact> [flags]
+
+run signald-proxy <command> -h for the flags of each command`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Println(usage)
+		os.Exit(2)
+	}
+
+	var err error
+	switch os.Args[1] {
+	case "record":
+		err = record(os.Args[2:])
+	case "replay":
+		err = replay(os.Args[2:])
+	case "scenario":
+		err = runScenario(os.Args[2:])
+	case "redact":
+		err = redact(os.Args[2:])
+	default:
+		fmt.Println(usage)
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Println(aurora.Red(err.Error()))
+		os.Exit(1)
+	}
+}
+
+// redactFlags registers the flags shared by record and redact
+func redactFlags(flags *flag.FlagSet) (fields *string, key *string) {
+	fields = flags.String("redact-fields", strings.Join(capture.DefaultRedactFields, ","), "comma separated JSON field names to redact")
+	key = flags.String("redact-key", "", "secret used to derive placeholders (default: random, placeholders then only match within one run)")
+	return
+}
+
+func newRedactor(fields, key string) (*capture.Redactor, error) {
+	k := []byte(key)
+	if key == "" {
+		k = make([]byte, 32)
+		if _, err := rand.Read(k); err != nil {
+			return nil, err
+		}
+	}
+	return capture.NewRedactor(strings.Split(fields, ","), k), nil
+}
+
+func redact(args []string) error {
+	flags := flag.NewFlagSet("redact", flag.ExitOnError)
+	in := flags.String("in", "", "capture to read")
+	out := flags.String("out", "", "file to write the redacted capture to")
+	fields, key := redactFlags(flags)
+	flags.Parse(args)
+	if *in == "" || *out == "" {
+		return fmt.Errorf("-in and -out are required")
+	}
+
+	redactor, err := newRedactor(*fields, *key)
+	if err != nil {
+		return err
+	}
+	src, err := os.Open(*in)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.OpenFile(*out, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+
+	w := capture.NewWriter(dst, redactor)
+	return capture.Read(src, w.Write)
+}
+
+// listenUnix listens on a unix socket, replacing a stale socket file left over from an earlier run
+func listenUnix(path string) (net.Listener, error) {
+	if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+	return net.Listen("unix", path)
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+
+	aurora "github.com/logrusorgru/aurora/v3"
+
+	"gitlab.com/signald/signald/internal/capture"
+	"gitlab.com/signald/signald/internal/socket"
+)
+
+func record(args []string) error {
+	flags := flag.NewFlagSet("record", flag.ExitOnError)
+	listen := flags.String("listen", "", "socket to accept client connections on")
+	upstream := flags.String("upstream", socket.DefaultPath, "signald socket to forward connections to")
+	out := flags.String("out", "", "capture file to append to")
+	redactEnabled := flags.Bool("redact", false, "redact personal data and message content before writing the capture")
+	fields, key := redactFlags(flags)
+	flags.Parse(args)
+	if *listen == "" || *out == "" {
+		return fmt.Errorf("-listen and -out are required")
+	}
+
+	var redactor *capture.Redactor
+	if *redactEnabled {
+		var err error
+		if redactor, err = newRedactor(*fields, *key); err != nil {
+			return err
+		}
+	}
+
+	f, err := os.OpenFile(*out, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w := capture.NewWriter(f, redactor)
+
+	l, err := listenUnix(*listen)
+	if err != nil {
+		return err
+	}
+	// closing the listener removes the socket file
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		l.Close()
+	}()
+
+	fmt.Printf("recording connections to %s into %s, forwarding to %s\n", *listen, *out, *upstream)
+	for session := 1; ; session++ {
+		client, err := l.Accept()
+		if err != nil {
+			return nil
+		}
+		go proxy(client, *upstream, session, w)
+	}
+}
+
+func proxy(client net.Conn, upstreamPath string, session int, w *capture.Writer) {
+	defer client.Close()
+	upstream, err := net.Dial("unix", upstreamPath)
+	if err != nil {
+		fmt.Println(aurora.Red(fmt.Sprintf("session %d: error connecting to signald: %v", session, err)))
+		return
+	}
+	defer upstream.Close()
+	fmt.Printf("session %d: connected\n", session)
+
+	done := make(chan struct{}, 2)
+	go func() {
+		pipe(client, upstream, session, capture.FromClient, w)
+		done <- struct{}{}
+	}()
+	go func() {
+		pipe(upstream, client, session, capture.FromSignald, w)
+		done <- struct{}{}
+	}()
+	// once either side hangs up, close both so the other copy ends too
+	<-done
+	client.Close()
+	upstream.Close()
+	<-done
+	fmt.Printf("session %d: closed\n", session)
+}
+
+// pipe forwards src to dst line by line, recording each line after it was forwarded
+func pipe(src io.Reader, dst io.Writer, session int, from string, w *capture.Writer) {
+	reader := bufio.NewReader(src)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 {
+			if _, writeErr := dst.Write(line); writeErr != nil {
+				return
+			}
+			if trimmed := bytes.TrimRight(line, "\r\n"); len(trimmed) > 0 {
+				if recordErr := w.Write(capture.NewRecord(session, from, trimmed)); recordErr != nil {
+					fmt.Println(aurora.Red(fmt.Sprintf("session %d: error writing capture: %v", session, recordErr)))
+				}
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	aurora "github.com/logrusorgru/aurora/v3"
+
+	"gitlab.com/signald/signald/internal/capture"
+)
+
+func replay(args []string) error {
+	flags := flag.NewFlagSet("replay", flag.ExitOnError)
+	listen := flags.String("listen", "", "socket to accept client connections on")
+	capturePath := flags.String("capture", "", "capture to serve replies from")
+	chaosPath := flags.String("chaos", "", "YAML file describing faults to inject into replies, see chaos.go")
+	flags.Parse(args)
+	if *listen == "" || *capturePath == "" {
+		return fmt.Errorf("-listen and -capture are required")
+	}
+
+	records, err := capture.ReadFile(*capturePath)
+	if err != nil {
+		return err
+	}
+	s := newReplayServer(records)
+	if *chaosPath != "" {
+		if s.chaos, err = loadChaos(*chaosPath); err != nil {
+			return err
+		}
+	}
+
+	l, err := listenUnix(*listen)
+	if err != nil {
+		return err
+	}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		l.Close()
+	}()
+
+	fmt.Printf("replaying %d recorded requests from %s on %s\n", s.count(), *capturePath, *listen)
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return nil
+		}
+		go s.serve(conn)
+	}
+}
+
+// exchange is one recorded request with everything signald sent in response: the reply carrying the request's id,
+// and the unsolicited messages (incoming messages after a subscribe, for example) that followed it
+type exchange struct {
+	key     string
+	id      string
+	replies []capture.Record
+}
+
+type replayServer struct {
+	// greeting is what signald sent on connect before any request, normally the version message
+	greeting []capture.Record
+
+	chaos *chaos
+
+	lock      sync.Mutex
+	exchanges map[string][]*exchange
+	next      map[string]int
+}
+
+func exchangeKey(e capture.Envelope) string {
+	return e.Version + " " + e.Type
+}
+
+func newReplayServer(records []capture.Record) *replayServer {
+	s := &replayServer{exchanges: map[string][]*exchange{}, next: map[string]int{}}
+
+	sessions := []int{}
+	bySession := map[int][]capture.Record{}
+	for _, r := range records {
+		if _, ok := bySession[r.Session]; !ok {
+			sessions = append(sessions, r.Session)
+		}
+		bySession[r.Session] = append(bySession[r.Session], r)
+	}
+
+	for i, session := range sessions {
+		var current *exchange
+		// clients may send several requests before the first reply arrives
+		inFlight := map[string]*exchange{}
+		greeted := false
+		for _, r := range bySession[session] {
+			envelope, _ := r.Envelope()
+			if r.From == capture.FromClient {
+				current = &exchange{key: exchangeKey(envelope), id: envelope.ID}
+				s.exchanges[current.key] = append(s.exchanges[current.key], current)
+				if envelope.ID != "" {
+					inFlight[envelope.ID] = current
+				}
+				continue
+			}
+			// the version message is sent on connect, but often recorded after a client's first request
+			isGreeting := current == nil || (!greeted && envelope.ID == "" && envelope.Type == "version")
+			greeted = true
+			switch {
+			case isGreeting:
+				if i == 0 {
+					s.greeting = append(s.greeting, r)
+				}
+			case envelope.ID != "" && inFlight[envelope.ID] != nil:
+				inFlight[envelope.ID].replies = append(inFlight[envelope.ID].replies, r)
+			default:
+				current.replies = append(current.replies, r)
+			}
+		}
+	}
+	return s
+}
+
+func (s *replayServer) count() int {
+	n := 0
+	for _, list := range s.exchanges {
+		n += len(list)
+	}
+	return n
+}
+
+// lookup returns the recorded exchanges for a request in the order they were recorded. Once all of them have been
+// used, the last one keeps being served, so clients that poll keep getting an answer.
+func (s *replayServer) lookup(e capture.Envelope) *exchange {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	key := exchangeKey(e)
+	list := s.exchanges[key]
+	if len(list) == 0 {
+		return nil
+	}
+	i := s.next[key]
+	if i >= len(list) {
+		i = len(list) - 1
+	}
+	s.next[key]++
+	return list[i]
+}
+
+func (s *replayServer) serve(conn net.Conn) {
+	defer conn.Close()
+	w := newConnWriter(conn, s.chaos)
+
+	for _, r := range s.greeting {
+		envelope, _ := r.Envelope()
+		if !w.write(envelope.Type, true, r.Line()) {
+			return
+		}
+	}
+
+	reader := bufio.NewReader(conn)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err != nil {
+			return
+		}
+		var request capture.Envelope
+		if err := json.Unmarshal(line, &request); err != nil {
+			// signald answers requests it can't parse the legacy way
+			reply, _ := json.Marshal(map[string]interface{}{"id": "", "type": "unexpected_error", "data": map[string]string{"message": err.Error()}})
+			if !w.write("unexpected_error", false, reply) {
+				return
+			}
+			continue
+		}
+
+		ex := s.lookup(request)
+		if ex == nil {
+			fmt.Println(aurora.Yellow("no recorded reply for " + exchangeKey(request)))
+			if !w.write(request.Type, false, errorReply(request.ID, request.Type, "NoRecordedReply", "no recorded reply for "+exchangeKey(request))) {
+				return
+			}
+			continue
+		}
+		for _, r := range ex.replies {
+			reply := r.Line()
+			envelope, _ := r.Envelope()
+			isReply := envelope.ID != "" && envelope.ID == ex.id
+			if isReply {
+				reply = withID(reply, request.ID)
+			}
+			action := envelope.Type
+			if isReply {
+				action = request.Type
+			}
+			if !w.write(action, !isReply, reply) {
+				return
+			}
+		}
+	}
+}
+
+// withID replaces the id of a recorded reply with the id of the request being answered
+func withID(message []byte, id string) []byte {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(message, &fields); err != nil {
+		return message
+	}
+	fields["id"], _ = json.Marshal(id)
+	b, err := json.Marshal(fields)
+	if err != nil {
+		return message
+	}
+	return b
+}
+
+func errorReply(id, requestType, errorType, message string) []byte {
+	reply := map[string]interface{}{"id": id, "type": requestType, "error": map[string]string{"message": message}, "error_type": errorType}
+	b, err := json.Marshal(reply)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+
+	"gitlab.com/signald/signald/internal/capture"
+)
+
+func captured(session int, from, message string) capture.Record {
+	return capture.NewRecord(session, from, []byte(message))
+}
+
+var replayRecords = []capture.Record{
+	captured(1, capture.FromSignald, `{"type":"version","data":{"version":"recorded"}}`),
+	captured(1, capture.FromClient, `{"id":"1","type":"version","version":"v1"}`),
+	// a second request before the first is answered, the replies come back in the other order
+	captured(1, capture.FromClient, `{"id":"2","type":"list_accounts","version":"v1"}`),
+	captured(1, capture.FromSignald, `{"id":"2","type":"list_accounts","data":{"accounts":[]}}`),
+	captured(1, capture.FromSignald, `{"id":"1","type":"version","data":{"version":"recorded"}}`),
+	captured(1, capture.FromClient, `{"id":"3","type":"subscribe","username":"+12024561414"}`),
+	captured(1, capture.FromSignald, `{"id":"3","type":"subscribed"}`),
+	captured(1, capture.FromSignald, `{"type":"message","data":{"username":"+12024561414"}}`),
+	captured(2, capture.FromSignald, `{"type":"version","data":{"version":"second session"}}`),
+	captured(2, capture.FromClient, `{"id":"1","type":"list_accounts","version":"v1"}`),
+	captured(2, capture.FromSignald, `{"id":"1","type":"list_accounts","data":{"accounts":[{"account_id":"+12024561414"}]}}`),
+	captured(2, capture.FromClient, `{"id":"2","type":"list_accounts","version":"v0"}`),
+	captured(2, capture.FromSignald, `{"id":"2","type":"list_accounts","data":{"accounts":"v0"}}`),
+}
+
+func replyLines(ex *exchange) []string {
+	lines := []string{}
+	for _, r := range ex.replies {
+		lines = append(lines, string(r.Line()))
+	}
+	return lines
+}
+
+func TestNewReplayServer(t *testing.T) {
+	s := newReplayServer(replayRecords)
+	if len(s.greeting) != 1 || string(s.greeting[0].Line()) != `{"type":"version","data":{"version":"recorded"}}` {
+		t.Errorf("expected only the first session's greeting, got %d records", len(s.greeting))
+	}
+	if s.count() != 5 {
+		t.Errorf("expected 5 recorded requests, got %d", s.count())
+	}
+
+	tests := []struct {
+		version, action string
+		want            [][]string
+	}{
+		{"v1", "version", [][]string{{`{"id":"1","type":"version","data":{"version":"recorded"}}`}}},
+		{"v1", "list_accounts", [][]string{
+			{`{"id":"2","type":"list_accounts","data":{"accounts":[]}}`},
+			{`{"id":"1","type":"list_accounts","data":{"accounts":[{"account_id":"+12024561414"}]}}`},
+		}},
+		{"v0", "list_accounts", [][]string{{`{"id":"2","type":"list_accounts","data":{"accounts":"v0"}}`}}},
+		{"", "subscribe", [][]string{{`{"id":"3","type":"subscribed"}`, `{"type":"message","data":{"username":"+12024561414"}}`}}},
+	}
+	for _, tt := range tests {
+		key := tt.version + " " + tt.action
+		exchanges := s.exchanges[key]
+		if len(exchanges) != len(tt.want) {
+			t.Errorf("%s: expected %d exchanges, got %d", key, len(tt.want), len(exchanges))
+			continue
+		}
+		for i, ex := range exchanges {
+			got := replyLines(ex)
+			if len(got) != len(tt.want[i]) {
+				t.Errorf("%s #%d: got replies %q, expected %q", key, i, got, tt.want[i])
+				continue
+			}
+			for j := range got {
+				if got[j] != tt.want[i][j] {
+					t.Errorf("%s #%d: got replies %q, expected %q", key, i, got, tt.want[i])
+					break
+				}
+			}
+		}
+	}
+}
+
+func TestReplayLookupRepeatsLast(t *testing.T) {
+	s := newReplayServer(replayRecords)
+	request := capture.Envelope{Version: "v1", Type: "list_accounts"}
+	wantIDs := []string{"2", "1", "1", "1"}
+	for i, want := range wantIDs {
+		if ex := s.lookup(request); ex == nil || ex.id != want {
+			t.Fatalf("lookup %d: got %+v, expected the exchange recorded with id %s", i, ex, want)
+		}
+	}
+	if ex := s.lookup(capture.Envelope{Version: "v1", Type: "send"}); ex != nil {
+		t.Errorf("expected no exchange for an unrecorded request, got %+v", ex)
+	}
+}
+
+// replayClient connects to a replay server and reads the greeting
+func replayClient(t *testing.T, s *replayServer) (net.Conn, *bufio.Reader) {
+	t.Helper()
+	client, server := net.Pipe()
+	go s.serve(server)
+	t.Cleanup(func() { client.Close() })
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+	reader := bufio.NewReader(client)
+	if greeting := readMessage(t, reader); greeting["type"] != "version" {
+		t.Fatalf("expected the version greeting, got %v", greeting)
+	}
+	return client, reader
+}
+
+func readMessage(t *testing.T, reader *bufio.Reader) map[string]interface{} {
+	t.Helper()
+	line, err := reader.ReadBytes('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	var message map[string]interface{}
+	if err := json.Unmarshal(line, &message); err != nil {
+		t.Fatalf("%v: %s", err, line)
+	}
+	return message
+}
+
+func TestReplayRewritesIDs(t *testing.T) {
+	client, reader := replayClient(t, newReplayServer(replayRecords))
+
+	client.Write([]byte(`{"id":"mine","type":"subscribe","username":"+12024561414"}` + "\n"))
+	if reply := readMessage(t, reader); reply["id"] != "mine" || reply["type"] != "subscribed" {
+		t.Errorf("expected the recorded reply with the request's id, got %v", reply)
+	}
+	if message := readMessage(t, reader); message["type"] != "message" || message["id"] != nil {
+		t.Errorf("expected the unsolicited message as recorded, got %v", message)
+	}
+
+	client.Write([]byte(`{"id":"other","type":"send","version":"v1"}` + "\n"))
+	if reply := readMessage(t, reader); reply["id"] != "other" || reply["error_type"] != "NoRecordedReply" {
+		t.Errorf("expected a NoRecordedReply error for an unrecorded request, got %v", reply)
+	}
+}
+
+func TestWithID(t *testing.T) {
+	tests := []struct {
+		message, id, want string
+	}{
+		{`{"id":"1","type":"version"}`, "abc", `{"id":"abc","type":"version"}`},
+		{`{"type":"version"}`, "abc", `{"id":"abc","type":"version"}`},
+		{`not json`, "abc", `not json`},
+	}
+	for _, tt := range tests {
+		if got := string(withID([]byte(tt.message), tt.id)); got != tt.want {
+			t.Errorf("withID(%s, %q) = %s, expected %s", tt.message, tt.id, got, tt.want)
+		}
+	}
+}
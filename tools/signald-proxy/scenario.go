@@ -0,0 +1,345 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/signal"
+	"reflect"
+	"syscall"
+	"time"
+
+	aurora "github.com/logrusorgru/aurora/v3"
+	"gopkg.in/yaml.v3"
+)
+
+// scenario scripts a fake signald for bot integration tests. It is read from YAML (or JSON, which is valid YAML):
+//
+//	actions:
+//	  send:
+//	    - delay: 200ms
+//	      data: {results: [{address: {number: "+12024561111"}, success: {}}], timestamp: 1612345678901}
+//	    - error_type: RateLimitError
+//	      error: {message: rate limited}
+//	conversation:
+//	  - wait-for: subscribe
+//	  - delay: 1s
+//	    emit: {type: message, data: {account: "+12024561414", source: {number: "+12024561111"}, dataMessage: {body: ping}}}
+//	  - wait-for: send
+//	    match: {messageBody: pong}
+//	    timeout: 10s
+//	  - close: true
+//
+// Every connection gets its own copy of the script: replies to each action are used in order, the last one repeating,
+// and the conversation runs from the start.
+type scenario struct {
+	// Greeting is the version message sent on connect, signald's is used if it is not set
+	Greeting map[string]interface{} `yaml:"greeting"`
+
+	// Actions are the replies to each request type, by action name
+	Actions map[string][]scriptedReply `yaml:"actions"`
+
+	// Conversation is run once per connection, alongside the replies to requests
+	Conversation []step `yaml:"conversation"`
+
+	chaos *chaos
+}
+
+type scriptedReply struct {
+	Delay     time.Duration `yaml:"delay"`
+	Data      interface{}   `yaml:"data"`
+	Error     interface{}   `yaml:"error"`
+	ErrorType string        `yaml:"error_type"`
+
+	// Type overrides the type of the reply, which is otherwise the type of the request
+	Type string `yaml:"type"`
+}
+
+type step struct {
+	// WaitFor waits until the client has sent a request of this type, and it has been answered
+	WaitFor string `yaml:"wait-for"`
+
+	// Match limits WaitFor to requests containing these fields and values
+	Match map[string]interface{} `yaml:"match"`
+
+	// Timeout hangs up on the client if WaitFor hasn't happened by then, so a bot that never sends it fails its test
+	// instead of hanging it
+	Timeout time.Duration `yaml:"timeout"`
+
+	Delay time.Duration `yaml:"delay"`
+
+	// Emit is written to the client as is, to simulate incoming messages and other unsolicited events
+	Emit map[string]interface{} `yaml:"emit"`
+
+	// Close hangs up on the client
+	Close bool `yaml:"close"`
+}
+
+var defaultGreeting = map[string]interface{}{
+	"type": "version",
+	"data": map[string]interface{}{"name": "signald", "version": "scenario", "branch": "", "commit": ""},
+}
+
+func loadScenario(path string) (*scenario, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var s scenario
+	if err := yaml.Unmarshal(b, &s); err != nil {
+		return nil, fmt.Errorf("error parsing %s: %v", path, err)
+	}
+	if s.Greeting == nil {
+		s.Greeting = defaultGreeting
+	}
+	for i, st := range s.Conversation {
+		actions := 0
+		for _, set := range []bool{st.WaitFor != "", st.Emit != nil, st.Close} {
+			if set {
+				actions++
+			}
+		}
+		if actions > 1 {
+			return nil, fmt.Errorf("conversation step %d: only one of wait-for, emit and close can be used per step", i+1)
+		}
+		if st.Match != nil && st.WaitFor == "" {
+			return nil, fmt.Errorf("conversation step %d: match needs wait-for", i+1)
+		}
+		if st.Timeout != 0 && st.WaitFor == "" {
+			return nil, fmt.Errorf("conversation step %d: timeout needs wait-for", i+1)
+		}
+	}
+	return &s, nil
+}
+
+func runScenario(args []string) error {
+	flags := flag.NewFlagSet("scenario", flag.ExitOnError)
+	listen := flags.String("listen", "", "socket to accept client connections on")
+	scenarioPath := flags.String("scenario", "", "YAML or JSON file describing replies and incoming messages")
+	chaosPath := flags.String("chaos", "", "YAML file describing faults to inject into replies, see chaos.go")
+	flags.Parse(args)
+	if *listen == "" || *scenarioPath == "" {
+		return fmt.Errorf("-listen and -scenario are required")
+	}
+
+	s, err := loadScenario(*scenarioPath)
+	if err != nil {
+		return err
+	}
+	if *chaosPath != "" {
+		if s.chaos, err = loadChaos(*chaosPath); err != nil {
+			return err
+		}
+	}
+
+	l, err := listenUnix(*listen)
+	if err != nil {
+		return err
+	}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		l.Close()
+	}()
+
+	fmt.Printf("running scenario %s on %s\n", *scenarioPath, *listen)
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return nil
+		}
+		go s.serve(conn)
+	}
+}
+
+// scenarioConn is one client connection running a scenario
+type scenarioConn struct {
+	scenario *scenario
+	conn     net.Conn
+	writer   *connWriter
+
+	// next is the index of the next reply to use for each action
+	next map[string]int
+
+	// requests carries every answered request to the conversation
+	requests chan map[string]interface{}
+	done     chan struct{}
+}
+
+func (s *scenario) serve(conn net.Conn) {
+	c := &scenarioConn{
+		scenario: s,
+		conn:     conn,
+		writer:   newConnWriter(conn, s.chaos),
+		next:     map[string]int{},
+		requests: make(chan map[string]interface{}, 1024),
+		done:     make(chan struct{}),
+	}
+	defer conn.Close()
+	defer close(c.done)
+
+	if !c.write("version", true, s.Greeting) {
+		return
+	}
+	go c.converse()
+
+	// requests are answered one at a time, so a delayed reply also delays the replies to the requests after it
+	reader := bufio.NewReader(conn)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err != nil {
+			return
+		}
+		var request map[string]interface{}
+		if err := json.Unmarshal(line, &request); err != nil {
+			if !c.write("unexpected_error", false, map[string]interface{}{"id": "", "type": "unexpected_error", "data": map[string]string{"message": err.Error()}}) {
+				return
+			}
+			continue
+		}
+		if !c.reply(request) {
+			return
+		}
+		select {
+		case c.requests <- request:
+		case <-c.done:
+			return
+		}
+	}
+}
+
+func (c *scenarioConn) reply(request map[string]interface{}) bool {
+	id, _ := request["id"].(string)
+	requestType, _ := request["type"].(string)
+
+	replies := c.scenario.Actions[requestType]
+	if len(replies) == 0 {
+		if requestType == "subscribe" {
+			// bots subscribe before anything else, not every scenario should have to script it
+			return c.write(requestType, false, map[string]interface{}{"id": id, "type": "subscribed"})
+		}
+		fmt.Println(aurora.Yellow("no scripted reply for " + requestType))
+		var reply map[string]interface{}
+		json.Unmarshal(errorReply(id, requestType, "NoScriptedReply", "the scenario has no reply for "+requestType), &reply)
+		return c.write(requestType, false, reply)
+	}
+	i := c.next[requestType]
+	if i >= len(replies) {
+		i = len(replies) - 1
+	}
+	c.next[requestType]++
+	r := replies[i]
+
+	time.Sleep(r.Delay)
+	reply := map[string]interface{}{"id": id, "type": requestType}
+	if r.Type != "" {
+		reply["type"] = r.Type
+	}
+	if r.Data != nil {
+		reply["data"] = r.Data
+	}
+	if r.Error != nil || r.ErrorType != "" {
+		reply["error"] = r.Error
+		reply["error_type"] = r.ErrorType
+	}
+	return c.write(requestType, false, reply)
+}
+
+func (c *scenarioConn) converse() {
+	for i, st := range c.scenario.Conversation {
+		select {
+		case <-time.After(st.Delay):
+		case <-c.done:
+			return
+		}
+		switch {
+		case st.WaitFor != "":
+			if !c.waitFor(i, st) {
+				return
+			}
+		case st.Emit != nil:
+			emitType, _ := st.Emit["type"].(string)
+			if !c.write(emitType, true, st.Emit) {
+				return
+			}
+		case st.Close:
+			fmt.Printf("closing connection at conversation step %d\n", i+1)
+			c.conn.Close()
+			return
+		}
+	}
+}
+
+// waitFor returns false if the connection closed first, or after hanging up when the step timed out
+func (c *scenarioConn) waitFor(i int, st step) bool {
+	var timeout <-chan time.Time
+	if st.Timeout > 0 {
+		timer := time.NewTimer(st.Timeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	for {
+		select {
+		case request := <-c.requests:
+			if request["type"] == st.WaitFor && contains(request, st.Match) {
+				return true
+			}
+		case <-timeout:
+			fmt.Println(aurora.Red(fmt.Sprintf("conversation step %d: no %s within %s, closing connection", i+1, st.WaitFor, st.Timeout)))
+			c.conn.Close()
+			return false
+		case <-c.done:
+			return false
+		}
+	}
+}
+
+// contains reports whether value has all the fields in subset, comparing nested objects the same way
+func contains(value, subset map[string]interface{}) bool {
+	for k, want := range subset {
+		got, ok := value[k]
+		if !ok {
+			return false
+		}
+		// YAML and JSON decode numbers differently, compare both the way JSON sees them
+		got, want = normalize(got), normalize(want)
+		gotObject, gotIsObject := got.(map[string]interface{})
+		wantObject, wantIsObject := want.(map[string]interface{})
+		if gotIsObject && wantIsObject {
+			if !contains(gotObject, wantObject) {
+				return false
+			}
+			continue
+		}
+		if !reflect.DeepEqual(got, want) {
+			return false
+		}
+	}
+	return true
+}
+
+func normalize(v interface{}) interface{} {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return v
+	}
+	var out interface{}
+	if err := json.Unmarshal(b, &out); err != nil {
+		return v
+	}
+	return out
+}
+
+func (c *scenarioConn) write(action string, unsolicited bool, message interface{}) bool {
+	b, err := json.Marshal(message)
+	if err != nil {
+		fmt.Println(aurora.Red("error encoding scripted message: " + err.Error()))
+		return false
+	}
+	return c.writer.write(action, unsolicited, b)
+}
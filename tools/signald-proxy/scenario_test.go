@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestContains(t *testing.T) {
+	var fromYAML map[string]interface{}
+	if err := yaml.Unmarshal([]byte("{count: 5, address: {number: '+12024561111'}, tags: [a, b]}"), &fromYAML); err != nil {
+		t.Fatal(err)
+	}
+	value := map[string]interface{}{
+		"messageBody": "pong",
+		"count":       float64(5),
+		"address":     map[string]interface{}{"number": "+12024561111", "uuid": "u"},
+		"tags":        []interface{}{"a", "b"},
+	}
+	tests := []struct {
+		name   string
+		subset map[string]interface{}
+		want   bool
+	}{
+		{"empty", nil, true},
+		{"equal field", map[string]interface{}{"messageBody": "pong"}, true},
+		{"different value", map[string]interface{}{"messageBody": "ping"}, false},
+		{"missing field", map[string]interface{}{"recipientGroupId": "g"}, false},
+		{"nested subset", map[string]interface{}{"address": map[string]interface{}{"number": "+12024561111"}}, true},
+		{"nested mismatch", map[string]interface{}{"address": map[string]interface{}{"number": "+12024560000"}}, false},
+		{"object against string", map[string]interface{}{"messageBody": map[string]interface{}{}}, false},
+		{"yaml int against json number", map[string]interface{}{"count": 5}, true},
+		{"decoded from yaml", fromYAML, true},
+		{"lists compare whole", map[string]interface{}{"tags": []interface{}{"a"}}, false},
+	}
+	for _, tt := range tests {
+		if got := contains(value, tt.subset); got != tt.want {
+			t.Errorf("%s: contains = %v, expected %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func parseScenario(t *testing.T, doc string) (*scenario, error) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "scenario.yaml")
+	if err := ioutil.WriteFile(path, []byte(doc), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return loadScenario(path)
+}
+
+func TestLoadScenarioErrors(t *testing.T) {
+	tests := []struct {
+		name, doc, err string
+	}{
+		{"two things in a step", "conversation: [{wait-for: send, close: true}]", "only one of"},
+		{"match without wait-for", "conversation: [{match: {messageBody: pong}}]", "match needs wait-for"},
+		{"timeout without wait-for", "conversation: [{emit: {type: message}, timeout: 1s}]", "timeout needs wait-for"},
+		{"not yaml", "actions: [", "error parsing"},
+	}
+	for _, tt := range tests {
+		_, err := parseScenario(t, tt.doc)
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%s: got error %v, expected %q", tt.name, err, tt.err)
+		}
+	}
+}
+
+// scenarioClient connects to a scenario and reads the greeting
+func scenarioClient(t *testing.T, s *scenario) (net.Conn, *bufio.Reader) {
+	t.Helper()
+	client, server := net.Pipe()
+	go s.serve(server)
+	t.Cleanup(func() { client.Close() })
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+	reader := bufio.NewReader(client)
+	if greeting := readMessage(t, reader); greeting["type"] != "version" {
+		t.Fatalf("expected the version greeting, got %v", greeting)
+	}
+	return client, reader
+}
+
+func TestScenarioWaitFor(t *testing.T) {
+	s, err := parseScenario(t, `
+actions:
+  send:
+    - data: {n: 1}
+    - data: {n: 2}
+conversation:
+  - wait-for: send
+    match: {messageBody: pong}
+  - emit: {type: message, data: {dataMessage: {body: got it}}}
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, reader := scenarioClient(t, s)
+
+	client.Write([]byte(`{"id":"1","type":"send","messageBody":"ping"}` + "\n"))
+	if reply := readMessage(t, reader); reply["id"] != "1" || reply["data"].(map[string]interface{})["n"] != float64(1) {
+		t.Errorf("expected the first scripted reply, got %v", reply)
+	}
+
+	// only the matching request moves the conversation on
+	client.Write([]byte(`{"id":"2","type":"send","messageBody":"pong"}` + "\n"))
+	if reply := readMessage(t, reader); reply["id"] != "2" || reply["data"].(map[string]interface{})["n"] != float64(2) {
+		t.Errorf("expected the second scripted reply, got %v", reply)
+	}
+	if message := readMessage(t, reader); message["type"] != "message" {
+		t.Errorf("expected the emitted message after the matching request, got %v", message)
+	}
+
+	client.Write([]byte(`{"id":"3","type":"send","messageBody":"again"}` + "\n"))
+	if reply := readMessage(t, reader); reply["id"] != "3" || reply["data"].(map[string]interface{})["n"] != float64(2) {
+		t.Errorf("expected the last scripted reply to repeat, got %v", reply)
+	}
+}
+
+func TestScenarioWaitForTimeout(t *testing.T) {
+	s, err := parseScenario(t, `
+conversation:
+  - wait-for: send
+    timeout: 50ms
+  - emit: {type: message}
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, reader := scenarioClient(t, s)
+
+	// requests that don't match keep being answered until the timeout
+	client.Write([]byte(`{"id":"1","type":"subscribe","username":"+12024561414"}` + "\n"))
+	if reply := readMessage(t, reader); reply["type"] != "subscribed" {
+		t.Errorf("expected the default subscribe reply, got %v", reply)
+	}
+
+	started := time.Now()
+	if line, err := reader.ReadBytes('\n'); err != io.EOF {
+		t.Fatalf("expected the connection to close, got %q, %v", line, err)
+	}
+	if elapsed := time.Since(started); elapsed > 2*time.Second {
+		t.Errorf("connection closed after %s", elapsed)
+	}
+}
+
+func TestScenarioReplyDelay(t *testing.T) {
+	s, err := parseScenario(t, `
+actions:
+  version:
+    - delay: 100ms
+      data: {version: delayed}
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, reader := scenarioClient(t, s)
+
+	started := time.Now()
+	client.Write([]byte(`{"id":"1","type":"version","version":"v1"}` + "\n"))
+	reply := readMessage(t, reader)
+	if elapsed := time.Since(started); elapsed < 100*time.Millisecond {
+		t.Errorf("reply came after %s, expected at least the 100ms delay", elapsed)
+	}
+	if reply["data"].(map[string]interface{})["version"] != "delayed" {
+		t.Errorf("got %v", reply)
+	}
+}